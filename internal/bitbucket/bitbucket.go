@@ -0,0 +1,274 @@
+// Package bitbucket implements bitbucket server querying logic.
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+)
+
+// A handle for querying a Bitbucket Server (formerly Stash) host through its
+// REST API.
+type BitbucketSCM struct {
+	bitbucketHostName string
+	client            *vcs.RESTClient
+	useHTTPS          bool
+
+	// Which tags to index. See SetRules.
+	rules *rules.Rules
+}
+
+// Creates a new Bitbucket Server SCM that authenticates with the given HTTP
+// access token.
+func NewBitbucketSCM(bitbucketHostName, authToken string, useHTTPS bool) *BitbucketSCM {
+	return &BitbucketSCM{bitbucketHostName: bitbucketHostName, client: vcs.NewRESTClient("bitbucket", "Authorization", "Bearer "+authToken), useHTTPS: useHTTPS}
+}
+
+// Sets the rules that decide which tags TagsForRepos excludes. A nil *Rules,
+// the default, excludes nothing.
+func (scm *BitbucketSCM) SetRules(r *rules.Rules) {
+	scm.rules = r
+}
+
+func (scm *BitbucketSCM) apiURL() string {
+	protocol := "http://"
+	if scm.useHTTPS {
+		protocol = "https://"
+	}
+	return protocol + scm.bitbucketHostName + "/rest/api/1.0"
+}
+
+// The API URL of the given repo, ex ".../projects/PROJ/repos/my-repo".
+func (scm *BitbucketSCM) repoURL(r repo) string {
+	return fmt.Sprintf("%s/projects/%s/repos/%s", scm.apiURL(), url.PathEscape(r.project), url.PathEscape(r.slug))
+}
+
+// A repo, named "PROJ/my-repo" after its project key and slug.
+type repo struct {
+	project string
+	slug    string
+}
+
+func newRepo(orgRepoName string) (repo, error) {
+	project, slug, ok := strings.Cut(orgRepoName, "/")
+	if !ok || strings.Contains(slug, "/") {
+		return repo{}, fmt.Errorf("expected PROJECT/repo format, but got %s", orgRepoName)
+	}
+	return repo{project: project, slug: slug}, nil
+}
+
+// Returns the module path implied by the repo URL, ex
+// "stash.somecompany.net/proj/my-repo". Project keys are upper case, but
+// lower case in module paths.
+func (scm *BitbucketSCM) modulePath(r repo) string {
+	return fmt.Sprintf("%s/%s/%s", scm.bitbucketHostName, strings.ToLower(r.project), r.slug)
+}
+
+// A page of a paged Bitbucket API. See
+// https://developer.atlassian.com/server/bitbucket/rest/v906/intro/#paged-apis.
+type page[T any] struct {
+	Values        []T  `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+// Retrieves every value of the paged API at the given URL, which must already
+// have a query string.
+func getAll[T any](ctx context.Context, scm *BitbucketSCM, url string) ([]T, error) {
+	var values []T
+	for start := 0; ; {
+		var p page[T]
+		if _, err := scm.client.GetJSON(ctx, fmt.Sprintf("%s&start=%d", url, start), &p); err != nil {
+			return nil, err
+		}
+		values = append(values, p.Values...)
+		if p.IsLastPage {
+			return values, nil
+		}
+		start = p.NextPageStart
+	}
+}
+
+type apiRepo struct {
+	ID       int64  `json:"id"`
+	Slug     string `json:"slug"`
+	Archived bool   `json:"archived"`
+	// Set for forks.
+	Origin *struct {
+		ID int64 `json:"id"`
+	} `json:"origin"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+}
+
+// Retrieves all repos with a go.mod file at their root on their default
+// branch, named after their project key and slug, ex "PROJ/my-repo".
+// Bitbucket doesn't classify repos by language, so every repo of every project
+// is looked at.
+//
+// Bitbucket doesn't say when repos were last pushed to either, so PushedAt is
+// left unknown. complete is false if some repos couldn't be looked at.
+func (scm *BitbucketSCM) GoRepos(ctx context.Context) (_ []*vcs.Repo, complete bool, _ error) {
+	projects, err := getAll[struct {
+		Key string `json:"key"`
+	}](ctx, scm, scm.apiURL()+"/projects?limit=100")
+	if err != nil {
+		return nil, false, fmt.Errorf("GoRepos: %w", err)
+	}
+
+	var results []*vcs.Repo
+	complete = true
+	for _, p := range projects {
+		repos, err := getAll[*apiRepo](ctx, scm, fmt.Sprintf("%s/projects/%s/repos?limit=100", scm.apiURL(), url.PathEscape(p.Key)))
+		if err != nil {
+			return nil, false, fmt.Errorf("GoRepos: %w", err)
+		}
+		for _, r := range repos {
+			orgRepoName := r.Project.Key + "/" + r.Slug
+			// Without a ref, the default branch.
+			_, found, err := scm.goMod(ctx, repo{project: r.Project.Key, slug: r.Slug}, "", "")
+			if errors.Is(err, vcs.ErrRateLimited) {
+				return nil, false, err
+			}
+			if err != nil {
+				slog.Warn(fmt.Sprintf("error looking for the go.mod file of %s: %v. Leaving it out", orgRepoName, err))
+				complete = false
+				continue
+			}
+			if !found {
+				continue
+			}
+			results = append(results, &vcs.Repo{
+				OrgRepoName: orgRepoName,
+				Archived:    r.Archived,
+				Fork:        r.Origin != nil,
+				// Repo IDs survive renames and moves between projects.
+				NodeID: fmt.Sprintf("repo/%d", r.ID),
+			})
+		}
+	}
+	return results, complete, nil
+}
+
+type apiTag struct {
+	DisplayID    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+}
+
+// Retrieves the tags of each of the given repos, one by one. Tags that aren't
+// valid module versions, or that the rules exclude, are included with their
+// Rejection set.
+//
+// Tags are dated by their commit, which takes a request per tag, unless the
+// tag is known and still points at the same commit. Bitbucket doesn't say how
+// many tags a repo has, so there's no telling whether any were deleted without
+// listing them all: every listing is complete, even for incremental requests.
+//
+// Returns an error, rather than per repo errors, if Bitbucket rate limits us.
+func (scm *BitbucketSCM) TagsForRepos(ctx context.Context, requests []*vcs.TagsRequest) ([]*vcs.TagsResult, error) {
+	var results []*vcs.TagsResult
+	for _, r := range requests {
+		tags, err := scm.tagsForRepo(ctx, r)
+		if errors.Is(err, vcs.ErrRateLimited) {
+			return nil, err
+		}
+		results = append(results, &vcs.TagsResult{OrgRepoName: r.OrgRepoName, Tags: tags, Complete: err == nil, Err: err})
+	}
+	return results, nil
+}
+
+func (scm *BitbucketSCM) tagsForRepo(ctx context.Context, r *vcs.TagsRequest) ([]*vcs.RepoTag, error) {
+	repo, err := newRepo(r.OrgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("TagsForRepos: %v", err)
+	}
+	apiTags, err := getAll[*apiTag](ctx, scm, scm.repoURL(repo)+"/tags?orderBy=MODIFICATION&limit=100")
+	if err != nil {
+		return nil, fmt.Errorf("error querying tags for %s: %w", r.OrgRepoName, err)
+	}
+
+	var tags []*vcs.RepoTag
+	for _, t := range apiTags {
+		tag := &vcs.RepoTag{Tag: t.DisplayID, TargetSHA: t.LatestCommit}
+		if k, ok := r.Known[tag.Tag]; ok && k.TargetSHA == tag.TargetSHA && !k.TagDate.IsZero() {
+			tag.TagDate = k.TagDate
+		} else if tag.TagDate, err = scm.commitDate(ctx, repo, t.LatestCommit); err != nil {
+			return nil, fmt.Errorf("error querying the commit of %s tag %s: %w", r.OrgRepoName, tag.Tag, err)
+		}
+		tags = append(tags, tag)
+	}
+
+	_, _, err = vcs.ResolveTags(ctx, r.OrgRepoName, scm.modulePath(repo), tags, r.Known, scm.rules, func(ctx context.Context, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+		var results []*vcs.GoModFetch
+		for _, f := range files {
+			content, found, err := scm.goMod(ctx, repo, "refs/tags/"+f.Tag, f.Dir)
+			if errors.Is(err, vcs.ErrRateLimited) {
+				return nil, err
+			}
+			results = append(results, &vcs.GoModFetch{Content: content, Found: found, Err: err})
+		}
+		return results, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Returns when the given commit was committed.
+func (scm *BitbucketSCM) commitDate(ctx context.Context, r repo, sha string) (time.Time, error) {
+	var commit struct {
+		// In milliseconds since the epoch.
+		CommitterTimestamp int64 `json:"committerTimestamp"`
+	}
+	if _, err := scm.client.GetJSON(ctx, scm.repoURL(r)+"/commits/"+url.PathEscape(sha), &commit); err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(commit.CommitterTimestamp).UTC(), nil
+}
+
+// Retrieves the contents of the go.mod file in the given repo subdirectory at
+// the given tag. found is false if there is no such go.mod file.
+func (scm *BitbucketSCM) GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error) {
+	repo, err := newRepo(orgRepoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("GoMod: %v", err)
+	}
+	return scm.goMod(ctx, repo, "refs/tags/"+tag, dir)
+}
+
+// Retrieves the go.mod file like GoMod, but at the given ref, or at the default
+// branch if ref is empty.
+func (scm *BitbucketSCM) goMod(ctx context.Context, r repo, ref, dir string) ([]byte, bool, error) {
+	goModURL := scm.repoURL(r) + "/raw/" + path.Join(dir, "go.mod")
+	if ref != "" {
+		goModURL += "?at=" + url.QueryEscape(ref)
+	}
+	return scm.client.GetFile(ctx, goModURL)
+}
+
+// Retrieves a zip archive of the repo contents at the given tag. All files in
+// the archive are inside a single top-level directory.
+func (scm *BitbucketSCM) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	repo, err := newRepo(orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("Zipball: %v", err)
+	}
+
+	// Bitbucket archives have no top-level directory unless asked for one.
+	prefix := repo.slug + "-" + strings.ReplaceAll(tag, "/", "-") + "/"
+	archive, err := scm.client.GetZipball(ctx, fmt.Sprintf("%s/archive?format=zip&at=%s&prefix=%s", scm.repoURL(repo), url.QueryEscape("refs/tags/"+tag), url.QueryEscape(prefix)))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching archive of %s (tag: %s): %w", orgRepoName, tag, err)
+	}
+	return archive, nil
+}
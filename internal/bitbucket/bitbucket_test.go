@@ -0,0 +1,249 @@
+package bitbucket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testAuthToken = "test-token"
+
+// Stands up a Bitbucket host that serves the given go.mod files, keyed by
+// "PROJ/repo@ref:path", ex "PROJ/repo@refs/tags/v1.0.0:tools/go.mod" (with an
+// empty ref for the default branch), and answers every other request with the
+// response for its request URI. Records the request URIs of every request but
+// go.mod ones.
+func createTestBitbucketServer(t *testing.T, responses map[string]string, goMods map[string]string) (string, *[]string) {
+	t.Helper()
+
+	var gotRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAuthToken {
+			http.Error(w, "wrong Authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		// Ex /rest/api/1.0/projects/PROJ/repos/repo/raw/tools/go.mod
+		if repoPath, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/rest/api/1.0/projects/"), "/raw/"); ok {
+			repoPath = strings.Replace(repoPath, "/repos/", "/", 1)
+			if content, ok := goMods[fmt.Sprintf("%s@%s:%s", repoPath, r.URL.Query().Get("at"), file)]; ok {
+				fmt.Fprint(w, content)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+
+		gotRequests = append(gotRequests, r.URL.RequestURI())
+		response, ok := responses[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://"), &gotRequests
+}
+
+func TestGoRepos(t *testing.T) {
+	hostName, _ := createTestBitbucketServer(t, map[string]string{
+		"/rest/api/1.0/projects?limit=100&start=0": `{"values": [{"key": "PROJ"}], "isLastPage": false, "nextPageStart": 1}`,
+		"/rest/api/1.0/projects?limit=100&start=1": `{"values": [{"key": "OTHER"}], "isLastPage": true}`,
+		"/rest/api/1.0/projects/PROJ/repos?limit=100&start=0": `{"values": [
+			{"id": 1, "slug": "repo1", "project": {"key": "PROJ"}},
+			{"id": 2, "slug": "not-go", "project": {"key": "PROJ"}}
+		], "isLastPage": true}`,
+		"/rest/api/1.0/projects/OTHER/repos?limit=100&start=0": `{"values": [
+			{"id": 3, "slug": "fork", "archived": true, "origin": {"id": 1}, "project": {"key": "OTHER"}}
+		], "isLastPage": true}`,
+	}, map[string]string{
+		"PROJ/repo1@:go.mod": "module stash.somecompany.net/proj/repo1\n",
+		"OTHER/fork@:go.mod": "module stash.somecompany.net/proj/repo1\n",
+	})
+	sut := NewBitbucketSCM(hostName, testAuthToken, false)
+
+	got, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+
+	// Repos without a go.mod file are left out.
+	want := []*vcs.Repo{
+		{OrgRepoName: "PROJ/repo1", NodeID: "repo/1"},
+		{OrgRepoName: "OTHER/fork", Archived: true, Fork: true, NodeID: "repo/3"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+}
+
+const tagsPage = "/rest/api/1.0/projects/PROJ/repos/repo1/tags?orderBy=MODIFICATION&limit=100"
+
+func TestTagsForRepos(t *testing.T) {
+	hostName, _ := createTestBitbucketServer(t, map[string]string{
+		tagsPage + "&start=0": `{"values": [
+			{"displayId": "v1.1.0", "latestCommit": "sha3"},
+			{"displayId": "tools/cli/v0.1.0", "latestCommit": "sha2"}
+		], "isLastPage": false, "nextPageStart": 2}`,
+		tagsPage + "&start=2": `{"values": [
+			{"displayId": "v1.0", "latestCommit": "sha1"},
+			{"displayId": "_gheMigrationPR-1", "latestCommit": "sha1"}
+		], "isLastPage": true}`,
+		"/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha3": `{"id": "sha3", "committerTimestamp": 1735776000000}`,
+		"/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha2": `{"id": "sha2", "committerTimestamp": 1735689600000}`,
+		"/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha1": `{"id": "sha1", "committerTimestamp": 1735603200000}`,
+	}, map[string]string{
+		"PROJ/repo1@refs/tags/v1.1.0:go.mod":                     "module stash.somecompany.net/proj/repo1\n",
+		"PROJ/repo1@refs/tags/tools/cli/v0.1.0:tools/cli/go.mod": "module stash.someorg.company.com/proj/cli\n",
+	})
+	sut := NewBitbucketSCM(hostName, testAuthToken, false)
+	r, err := rules.Parse([]byte(`{"excludeTags": ["_gheMigrationPR-*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut.SetRules(r)
+
+	got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "PROJ/repo1"}, {OrgRepoName: "PROJ/missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("TagsForRepos: expected 2 results, got %d", len(got))
+	}
+
+	want := &vcs.TagsResult{
+		OrgRepoName: "PROJ/repo1",
+		Complete:    true,
+		Tags: []*vcs.RepoTag{
+			{Tag: "v1.1.0", TagDate: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), ModulePath: "stash.somecompany.net/proj/repo1", Version: "v1.1.0", TargetSHA: "sha3"},
+			{Tag: "tools/cli/v0.1.0", TagDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), ModulePath: "stash.someorg.company.com/proj/cli", Version: "v0.1.0", TargetSHA: "sha2"},
+			{Tag: "v1.0", TagDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), Rejection: "v1.0 is not a canonical semantic version (should be v1.0.0)", TargetSHA: "sha1"},
+			{Tag: "_gheMigrationPR-1", TagDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), Rejection: `excluded by tag pattern "_gheMigrationPR-*"`, TargetSHA: "sha1"},
+		},
+	}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+	// Other repos are fetched despite the missing one.
+	if got[1].Err == nil {
+		t.Errorf("TagsForRepos: expected an error for PROJ/missing")
+	}
+}
+
+func TestTagsForRepos_ReusesKnownTagDates(t *testing.T) {
+	hostName, gotRequests := createTestBitbucketServer(t, map[string]string{
+		tagsPage + "&start=0": `{"values": [
+			{"displayId": "v1.1.0", "latestCommit": "sha2"},
+			{"displayId": "v1.0.0", "latestCommit": "sha1"}
+		], "isLastPage": true}`,
+		"/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha2": `{"id": "sha2", "committerTimestamp": 1735689600000}`,
+	}, map[string]string{
+		"PROJ/repo1@refs/tags/v1.1.0:go.mod": "module stash.somecompany.net/proj/repo1\n",
+	})
+	sut := NewBitbucketSCM(hostName, testAuthToken, false)
+
+	// v1.1.0 was moved to another commit since it was last seen.
+	known := map[string]*vcs.RepoTag{
+		"v1.1.0": {Tag: "v1.1.0", TagDate: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), ModulePath: "stash.somecompany.net/proj/repo1", Version: "v1.1.0", TargetSHA: "sha0"},
+		"v1.0.0": {Tag: "v1.0.0", TagDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), ModulePath: "stash.somecompany.net/proj/repo1", Version: "v1.0.0", TargetSHA: "sha1"},
+	}
+	got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "PROJ/repo1", Known: known, Incremental: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got[0].Err != nil || !got[0].Complete || len(got[0].Tags) != 2 {
+		t.Fatalf("TagsForRepos: expected 2 tags of a complete listing, got %+v", got[0])
+	}
+	if want := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC); !got[0].Tags[0].TagDate.Equal(want) {
+		t.Errorf("TagsForRepos: expected the moved tag to be dated %v, got %v", want, got[0].Tags[0].TagDate)
+	}
+	if want := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC); !got[0].Tags[1].TagDate.Equal(want) {
+		t.Errorf("TagsForRepos: expected the known tag to keep its date %v, got %v", want, got[0].Tags[1].TagDate)
+	}
+	// Only the moved tag's commit is fetched.
+	if diff := cmp.Diff([]string{tagsPage + "&start=0", "/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha2"}, *gotRequests); diff != "" {
+		t.Errorf("unexpected requests: -want, +got: %s", diff)
+	}
+}
+
+func TestGetAll_FollowsNextPageStart(t *testing.T) {
+	// Pages may hold fewer values than asked for, so the next page starts where
+	// Bitbucket says rather than after the values seen.
+	hostName, gotRequests := createTestBitbucketServer(t, map[string]string{
+		"/rest/api/1.0/projects?limit=100&start=0":  `{"values": [{"key": "A"}], "isLastPage": false, "nextPageStart": 25}`,
+		"/rest/api/1.0/projects?limit=100&start=25": `{"values": [{"key": "B"}], "isLastPage": true, "nextPageStart": 26}`,
+	}, nil)
+	sut := NewBitbucketSCM(hostName, testAuthToken, false)
+
+	got, err := getAll[struct{ Key string }](t.Context(), sut, sut.apiURL()+"/projects?limit=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]struct{ Key string }{{"A"}, {"B"}}, got); diff != "" {
+		t.Errorf("getAll: -want, +got: %s", diff)
+	}
+	// The last page's nextPageStart isn't followed.
+	if diff := cmp.Diff([]string{"/rest/api/1.0/projects?limit=100&start=0", "/rest/api/1.0/projects?limit=100&start=25"}, *gotRequests); diff != "" {
+		t.Errorf("unexpected requests: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepos_LowerCaseProjectKeys(t *testing.T) {
+	hostName, _ := createTestBitbucketServer(t, map[string]string{
+		"/rest/api/1.0/projects/PROJ/repos/repo1/tags?orderBy=MODIFICATION&limit=100&start=0": `{"values": [
+			{"displayId": "v1.0.0", "latestCommit": "sha1"},
+			{"displayId": "v2.0.0", "latestCommit": "sha2"}
+		], "isLastPage": true}`,
+		"/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha1": `{"id": "sha1", "committerTimestamp": 1735603200000}`,
+		"/rest/api/1.0/projects/PROJ/repos/repo1/commits/sha2": `{"id": "sha2", "committerTimestamp": 1735689600000}`,
+	}, nil)
+	sut := NewBitbucketSCM(hostName, testAuthToken, false)
+
+	got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "PROJ/repo1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without go.mod files, tags get the module path implied by the repo URL,
+	// whose project key is lower case.
+	want := []*vcs.RepoTag{
+		{Tag: "v1.0.0", TagDate: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), ModulePath: hostName + "/proj/repo1", Version: "v1.0.0", TargetSHA: "sha1"},
+		{Tag: "v2.0.0", TagDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), ModulePath: hostName + "/proj/repo1", Version: "v2.0.0+incompatible", TargetSHA: "sha2"},
+	}
+	if diff := cmp.Diff(want, got[0].Tags); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+}
+
+func TestZipball(t *testing.T) {
+	// Archives are asked for at the tag ref, with a top-level directory.
+	hostName, _ := createTestBitbucketServer(t, map[string]string{
+		"/rest/api/1.0/projects/PROJ/repos/repo1/archive?format=zip&at=refs%2Ftags%2Ftools%2Fv1.0.0&prefix=repo1-tools-v1.0.0%2F": "PK",
+	}, nil)
+	sut := NewBitbucketSCM(hostName, testAuthToken, false)
+
+	got, err := sut.Zipball(t.Context(), "PROJ/repo1", "tools/v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "PK" {
+		t.Errorf("Zipball: unexpected archive %q", got)
+	}
+	if _, err := sut.Zipball(t.Context(), "PROJ/repo1", "v2.0.0"); err == nil {
+		t.Errorf("Zipball: expected an error for a missing tag")
+	}
+}
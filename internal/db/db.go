@@ -4,18 +4,28 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
 	"log"
+	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+
 	// TODO(jbarkhuysen): Consider switching to pgx instead.
-	_ "github.com/lib/pq" // Postgres driver.
+	"github.com/lib/pq" // Postgres driver.
 )
 
 // A db handle with specialised logic for indexing.
 type DB struct {
 	db *sql.DB
+
+	// Which repos and tags to keep. See SetRules.
+	rules *rules.Rules
 }
 
 // Establishes a new DB.
@@ -38,43 +48,177 @@ func NewDB(username, password, host string, port uint16, dbname string) (*DB, er
 	return &DB{db: db}, nil
 }
 
+// Sets the rules that decide which repos and tags StoreRepos keeps. Must be
+// called before StoreRepos is. A nil *Rules, the default, excludes nothing.
+func (d *DB) SetRules(r *rules.Rules) {
+	d.rules = r
+}
+
 // A tag for a repo.
 type RepoTag struct {
 	OrgRepoName string
 	TagName     string
 	ModulePath  string
-	Created     time.Time
+	Version     string
+	// Why the tag can't be served as a module version. Empty if it can.
+	Rejection string
+	// The tag or commit date.
+	Created time.Time
+	// When the tag was first stored. Set by StoreRepoTags.
+	FirstSeen time.Time
+	// The SHA of the commit the tag points to. Empty if unknown.
+	TargetSHA string
 }
 
-// Fetches repo tags.
-func (d *DB) FetchRepoTags(ctx context.Context, since time.Time, limit int64) ([]*RepoTag, error) {
+// Streams repo tags first seen at or after since, ordered by when they were
+// first seen. Repo tags first seen at the same time are ordered by repo and tag
+// name, so the order is always the same. Rejected and deleted repo tags are not
+// included.
+//
+// Repo tags are ordered by when they were first seen rather than by when they
+// were created, so that clients polling with since don't miss new tags on old
+// commits.
+//
+// Repo tags are read from the database as the returned sequence is iterated. If
+// an error occurs, it is yielded and iteration stops.
+func (d *DB) FetchRepoTags(ctx context.Context, since time.Time, limit int64) iter.Seq2[*RepoTag, error] {
 	query := `
-SELECT org_repo_name, tag_name, module_path, created
+SELECT org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha
 FROM repo_tags
-WHERE created >= $1
-ORDER BY created ASC
+WHERE first_seen >= $1
+AND rejection = ''
+AND deleted_at IS NULL
+ORDER BY first_seen ASC, org_repo_name ASC, tag_name ASC
 LIMIT $2;`
 
-	rows, err := d.db.QueryContext(ctx, query, since, limit)
+	return d.streamRepoTags(ctx, "FetchRepoTags", query, since, limit)
+}
+
+// A position in the repo tags feed: the repo tag with the given first seen
+// date, repo name and tag name.
+type FeedCursor struct {
+	FirstSeen   time.Time
+	OrgRepoName string
+	TagName     string
+}
+
+// The position of the given repo tag in the repo tags feed.
+func (rt *RepoTag) FeedCursor() FeedCursor {
+	return FeedCursor{FirstSeen: rt.FirstSeen, OrgRepoName: rt.OrgRepoName, TagName: rt.TagName}
+}
+
+// Streams repo tags that come strictly after the given cursor, in the same
+// order as FetchRepoTags. Unlike paging by date, paging by cursor never skips or
+// repeats repo tags, no matter how many were first seen at the same time.
+// Rejected and deleted repo tags are not included.
+func (d *DB) FetchRepoTagsAfter(ctx context.Context, after FeedCursor, limit int64) iter.Seq2[*RepoTag, error] {
+	query := `
+SELECT org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha
+FROM repo_tags
+WHERE (first_seen, org_repo_name, tag_name) > ($1, $2, $3)
+AND rejection = ''
+AND deleted_at IS NULL
+ORDER BY first_seen ASC, org_repo_name ASC, tag_name ASC
+LIMIT $4;`
+
+	return d.streamRepoTags(ctx, "FetchRepoTagsAfter", query, after.FirstSeen, after.OrgRepoName, after.TagName, limit)
+}
+
+// Fetches the repo tags of the given module, ordered by creation date. Rejected
+// and deleted repo tags are not included.
+func (d *DB) FetchModuleVersions(ctx context.Context, modulePath string) ([]*RepoTag, error) {
+	query := `
+SELECT org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha
+FROM repo_tags
+WHERE module_path = $1
+AND rejection = ''
+AND deleted_at IS NULL
+ORDER BY created ASC, org_repo_name ASC;`
+
+	repoTags, err := d.queryRepoTags(ctx, query, modulePath)
 	if err != nil {
-		return nil, fmt.Errorf("FetchRepoTags:\nquery: %s\nerror: %v", query, err)
+		return nil, fmt.Errorf("FetchModuleVersions: %v", err)
 	}
-	defer rows.Close()
-	var repoTags []*RepoTag
-	for rows.Next() {
-		var rt RepoTag
-		if err := rows.Scan(&rt.OrgRepoName, &rt.TagName, &rt.ModulePath, &rt.Created); err != nil {
-			return nil, fmt.Errorf("FetchRepoTags: %v", err)
-		}
-		repoTags = append(repoTags, &rt)
+	return repoTags, nil
+}
+
+// Fetches the rejected repo tags of the given repo, ordered by tag name.
+func (d *DB) FetchRejectedRepoTags(ctx context.Context, orgRepoName string) ([]*RepoTag, error) {
+	query := `
+SELECT org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha
+FROM repo_tags
+WHERE org_repo_name = $1
+AND rejection <> ''
+ORDER BY tag_name ASC;`
+
+	repoTags, err := d.queryRepoTags(ctx, query, orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("FetchRejectedRepoTags: %v", err)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("FetchRepoTags: %v", err)
+	return repoTags, nil
+}
+
+// Fetches all repo tags of the given repo, including rejected ones, ordered by
+// tag name.
+func (d *DB) FetchAllRepoTags(ctx context.Context, orgRepoName string) ([]*RepoTag, error) {
+	query := `
+SELECT org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha
+FROM repo_tags
+WHERE org_repo_name = $1
+ORDER BY tag_name ASC;`
+	repoTags, err := d.queryRepoTags(ctx, query, orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("FetchAllRepoTags: %v", err)
 	}
+	return repoTags, nil
+}
 
+// Runs the given query, which must select the columns org_repo_name, tag_name,
+// module_path, version, rejection, created, first_seen and target_sha, in that
+// order.
+func (d *DB) queryRepoTags(ctx context.Context, query string, args ...any) ([]*RepoTag, error) {
+	var repoTags []*RepoTag
+	for rt, err := range d.streamRepoTags(ctx, "", query, args...) {
+		if err != nil {
+			return nil, err
+		}
+		repoTags = append(repoTags, rt)
+	}
 	return repoTags, nil
 }
 
+// Like queryRepoTags, but yields repo tags one at a time as they are read from
+// the database. Errors are prefixed with the given function name, if any.
+func (d *DB) streamRepoTags(ctx context.Context, funcName, query string, args ...any) iter.Seq2[*RepoTag, error] {
+	wrap := func(err error) error {
+		if funcName == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %v", funcName, err)
+	}
+	return func(yield func(*RepoTag, error) bool) {
+		rows, err := d.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			yield(nil, wrap(fmt.Errorf("\nquery: %s\nerror: %v", query, err)))
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var rt RepoTag
+			if err := rows.Scan(&rt.OrgRepoName, &rt.TagName, &rt.ModulePath, &rt.Version, &rt.Rejection, &rt.Created, &rt.FirstSeen, &rt.TargetSHA); err != nil {
+				yield(nil, wrap(err))
+				return
+			}
+			if !yield(&rt, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(nil, wrap(err))
+		}
+	}
+}
+
 // Retrieves from the work queue whether it's time to re-index all repos.
 func (d *DB) NextReindexAllReposWork(ctx context.Context, reindexTTL, reindexPeriod time.Duration) (shouldReindex bool, _ error) {
 	query := `
@@ -95,103 +239,725 @@ AND indexing_finished + ($2 * INTERVAL '1 SECOND') < NOW();`
 
 // Retrieves from the work queue the next repo for which to re-index tags.
 // workWasFound will be false if no work was found.
-func (d *DB) NextReindexRepoTagsWork(ctx context.Context, reindexTTL, reindexPeriod time.Duration) (repoToReindex string, workWasFound bool, _ error) {
+//
+// Once reindexPeriod has passed, a repo is only re-indexed if it was pushed to,
+// or its number of tags changed, since its tags were last indexed (see
+// StoreRepos), if it isn't known when it was pushed to, or if the last indexing
+// didn't finish. All repos are re-indexed once fullResyncPeriod has passed since all of their tags were last stored
+// with StoreRepoTags regardless, in case a change was missed: fullSync is true
+// for those, and all of the repo's tags should be fetched rather than only the
+// newest ones.
+//
+// Repos whose push time isn't known are re-indexed every reindexPeriod, ex
+// those of hosts that don't say, like Bitbucket Server and git hosts, and
+// repos stored by webhooks until the next listing of all repos. That costs a
+// listing of all of their tags each time, since such hosts can't tell whether
+// tags were deleted otherwise.
+//
+// Repos that were asked to be re-indexed with RequestReindex are returned
+// first, without waiting for reindexPeriod, as soon as they're not being
+// indexed.
+func (d *DB) NextReindexRepoTagsWork(ctx context.Context, reindexTTL, reindexPeriod, fullResyncPeriod time.Duration) (repoToReindex string, fullSync bool, workWasFound bool, _ error) {
+	work, err := d.nextReindexRepoTagsWork(ctx, "NextReindexRepoTagsWork", 1, reindexTTL, reindexPeriod, fullResyncPeriod)
+	if err != nil || len(work) == 0 {
+		return "", false, false, err
+	}
+	return work[0].OrgRepoName, work[0].FullSync, true, nil
+}
+
+// A repo for which to re-index tags.
+type ReindexWork struct {
+	OrgRepoName string
+
+	// See NextReindexRepoTagsWork.
+	FullSync bool
+}
+
+// Like NextReindexRepoTagsWork, but retrieves up to n repos at once, so that
+// their tags can be fetched together. Returns no work if none was found.
+// Repos that another worker is claiming at the same time are skipped, so that
+// no repo is handed to two workers.
+func (d *DB) NextReindexRepoTagsWorkBatch(ctx context.Context, n int, reindexTTL, reindexPeriod, fullResyncPeriod time.Duration) ([]*ReindexWork, error) {
+	return d.nextReindexRepoTagsWork(ctx, "NextReindexRepoTagsWorkBatch", n, reindexTTL, reindexPeriod, fullResyncPeriod)
+}
+
+func (d *DB) nextReindexRepoTagsWork(ctx context.Context, funcName string, n int, reindexTTL, reindexPeriod, fullResyncPeriod time.Duration) ([]*ReindexWork, error) {
 	query := fmt.Sprintf(`
 UPDATE repos
-SET indexing_began = NOW()
-WHERE org_repo_name = (
+SET indexing_began = NOW(), indexed_pushed_at = pushed_at, indexed_tag_count = tag_count
+WHERE org_repo_name IN (
     SELECT org_repo_name
     FROM repos
-    WHERE indexing_began + (%d * INTERVAL '1 SECOND') < NOW()
-    AND indexing_finished + (%d * INTERVAL '1 SECOND') < NOW()
-    ORDER BY indexing_finished ASC
-    LIMIT 1
+    WHERE deleted_at IS NULL AND ((
+        indexing_began + (%[1]d * INTERVAL '1 SECOND') < NOW()
+        AND (
+            full_sync_finished + (%[3]d * INTERVAL '1 SECOND') < NOW()
+            OR (
+                indexing_finished + (%[2]d * INTERVAL '1 SECOND') < NOW()
+                AND (
+                    indexing_finished <= indexing_began
+                    OR pushed_at IS NULL
+                    OR pushed_at IS DISTINCT FROM indexed_pushed_at
+                    OR tag_count IS DISTINCT FROM indexed_tag_count
+                )
+            )
+        )
+    ) OR (
+        reindex_requested > indexing_began
+        AND (indexing_finished >= indexing_began OR indexing_began + (%[1]d * INTERVAL '1 SECOND') < NOW())
+    ))
+    ORDER BY reindex_requested > indexing_began DESC, indexing_finished ASC
+    LIMIT %[4]d
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING org_repo_name, full_sync_finished + (%[3]d * INTERVAL '1 SECOND') < NOW();`, int64(reindexTTL.Seconds()), int64(reindexPeriod.Seconds()), int64(fullResyncPeriod.Seconds()), n)
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s:\nquery: %s\nerror: %v", funcName, query, err)
+	}
+	defer rows.Close()
+
+	var work []*ReindexWork
+	for rows.Next() {
+		var w ReindexWork
+		if err := rows.Scan(&w.OrgRepoName, &w.FullSync); err != nil {
+			return nil, fmt.Errorf("%s: %v", funcName, err)
+		}
+		work = append(work, &w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", funcName, err)
+	}
+	return work, nil
+}
+
+// Asks for the given repo's tags to be re-indexed as soon as possible, rather
+// than at the next scheduled re-index. If the repo is being indexed right now,
+// it's re-indexed again once that finishes, since the running indexing may have
+// missed the change that prompted the request.
+//
+// known will be false if the repo isn't stored, or is deleted, in which case
+// nothing is done.
+func (d *DB) RequestReindex(ctx context.Context, orgRepoName string) (known bool, _ error) {
+	query := `
+UPDATE repos
+SET reindex_requested = NOW()
+WHERE org_repo_name = $1
+AND deleted_at IS NULL;`
+	res, err := d.db.ExecContext(ctx, query, orgRepoName)
+	if err != nil {
+		return false, fmt.Errorf("RequestReindex:\nquery: %s\nerror: %v", query, err)
+	}
+	a, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("RequestReindex: %v", err)
+	}
+	return a > 0, nil
+}
+
+// Moves a repo and its tags to a new name, ex after the repo was renamed or
+// transferred to another org, and records the move: see moveRepo. The new name
+// is asked to be re-indexed, since its module paths have changed.
+//
+// moved is false, and nothing is done, if the old name isn't stored: whether
+// the repo is a Go repo isn't known, so it's left to the next listing of all
+// repos.
+func (d *DB) RenameRepo(ctx context.Context, fromOrgRepoName, toOrgRepoName string) (moved bool, _ error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("RenameRepo: %v", err)
+	}
+	// Defer a rollback in case anything fails.
+	defer tx.Rollback()
+
+	moved, err = moveRepo(ctx, tx, fromOrgRepoName, toOrgRepoName)
+	if err != nil {
+		return false, fmt.Errorf("RenameRepo: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("RenameRepo: %v", err)
+	}
+	return moved, nil
+}
+
+// Renames the stored repo fromOrgRepoName, and its tags, to toOrgRepoName, and
+// records the move in repo_moves. A repo already stored as toOrgRepoName, ex
+// because a webhook reported it before the move was noticed, is replaced.
+//
+// The repo is asked to be fully re-indexed right away, and its tags' module
+// paths are determined again, since those that come from the repo's URL have
+// changed. Tags whose module path doesn't change keep their place in the feed.
+//
+// moved is false, and nothing is done, if fromOrgRepoName isn't stored.
+func moveRepo(ctx context.Context, tx *sql.Tx, fromOrgRepoName, toOrgRepoName string) (moved bool, _ error) {
+	query := `
+SELECT 1
+FROM repos
+WHERE org_repo_name = $1;`
+	var exists int
+	if err := tx.QueryRowContext(ctx, query, fromOrgRepoName).Scan(&exists); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+
+	if err := deleteRepo(ctx, tx, toOrgRepoName); err != nil {
+		return false, err
+	}
+	for _, query := range []string{
+		`
+UPDATE repos
+SET org_repo_name = $2, reindex_requested = NOW(), full_sync_finished = TIMESTAMP '-infinity', deleted_at = NULL
+WHERE org_repo_name = $1;`,
+		// Renamed along with the repo by the foreign key.
+		`
+UPDATE repo_tags
+SET target_sha = '', deleted_at = NULL
+WHERE org_repo_name = $2;`,
+		`
+INSERT INTO repo_moves (node_id, from_org_repo_name, to_org_repo_name)
+SELECT node_id, $1, $2
+FROM repos
+WHERE org_repo_name = $2;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, fromOrgRepoName, toOrgRepoName); err != nil {
+			return false, fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+		}
+	}
+	slog.Info(fmt.Sprintf("repo %s moved to %s", fromOrgRepoName, toOrgRepoName))
+	return true, nil
+}
+
+// Moves the stored repos whose node IDs match the given repos but whose names
+// don't: they were renamed or transferred since they were stored.
+func moveRenamedRepos(ctx context.Context, tx *sql.Tx, repos []*Repo) error {
+	var nodeIDs []string
+	for _, r := range repos {
+		if r.NodeID != "" {
+			nodeIDs = append(nodeIDs, r.NodeID)
+		}
+	}
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+
+	query := `
+SELECT node_id, org_repo_name
+FROM repos
+WHERE node_id = ANY($1);`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(nodeIDs))
+	if err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	defer rows.Close()
+	stored := make(map[string]string)
+	for rows.Next() {
+		var nodeID, orgRepoName string
+		if err := rows.Scan(&nodeID, &orgRepoName); err != nil {
+			return err
+		}
+		stored[nodeID] = orgRepoName
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range repos {
+		if from, ok := stored[r.NodeID]; ok && r.NodeID != "" && from != r.OrgRepoName {
+			if _, err := moveRepo(ctx, tx, from, r.OrgRepoName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A rename or transfer of a repo.
+type RepoMove struct {
+	// Empty if the repo's node ID wasn't known when it moved.
+	NodeID          string
+	FromOrgRepoName string
+	ToOrgRepoName   string
+	MovedAt         time.Time
+}
+
+// Fetches the repo moves made at or after since, oldest first.
+func (d *DB) FetchRepoMoves(ctx context.Context, since time.Time, limit int64) ([]*RepoMove, error) {
+	query := `
+SELECT COALESCE(node_id, ''), from_org_repo_name, to_org_repo_name, moved_at
+FROM repo_moves
+WHERE moved_at >= $1
+ORDER BY moved_at ASC, id ASC
+LIMIT $2;`
+	moves, err := d.queryRepoMoves(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("FetchRepoMoves: %v", err)
+	}
+	return moves, nil
+}
+
+// Fetches the moves that led to the given repo's current name, oldest first:
+// its path history.
+func (d *DB) FetchRepoPathHistory(ctx context.Context, orgRepoName string) ([]*RepoMove, error) {
+	// Moves made before the node ID was known are followed back by name.
+	query := `
+WITH RECURSIVE history AS (
+    SELECT id, node_id, from_org_repo_name, to_org_repo_name, moved_at
+    FROM repo_moves
+    WHERE id = (SELECT MAX(id) FROM repo_moves WHERE to_org_repo_name = $1)
+    UNION
+    SELECT m.id, m.node_id, m.from_org_repo_name, m.to_org_repo_name, m.moved_at
+    FROM repo_moves m
+    JOIN history h ON m.to_org_repo_name = h.from_org_repo_name AND m.id < h.id
 )
-RETURNING org_repo_name;`, int64(reindexTTL.Seconds()), int64(reindexPeriod.Seconds()))
+SELECT COALESCE(node_id, ''), from_org_repo_name, to_org_repo_name, moved_at
+FROM history
+ORDER BY id ASC;`
+	moves, err := d.queryRepoMoves(ctx, query, orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("FetchRepoPathHistory: %v", err)
+	}
+	return moves, nil
+}
+
+func (d *DB) queryRepoMoves(ctx context.Context, query string, args ...any) ([]*RepoMove, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	defer rows.Close()
+	var moves []*RepoMove
+	for rows.Next() {
+		var m RepoMove
+		if err := rows.Scan(&m.NodeID, &m.FromOrgRepoName, &m.ToOrgRepoName, &m.MovedAt); err != nil {
+			return nil, err
+		}
+		moves = append(moves, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+// Soft-deletes the given repo and its tags, ex after the repo was deleted: like
+// repos missing from the listing of all repos, they're no longer indexed or
+// served, but are restored if the repo is listed again. Deleting a repo that
+// isn't stored is not an error.
+func (d *DB) SoftDeleteRepo(ctx context.Context, orgRepoName string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("SoftDeleteRepo: %v", err)
+	}
+	// Defer a rollback in case anything fails.
+	defer tx.Rollback()
 
-	row := d.db.QueryRowContext(ctx, query)
-	if row.Err() != nil {
-		return "", false, fmt.Errorf("NextReindexRepoTagsWork:\nquery: %s\nerror: %v", query, row.Err())
+	if err := softDeleteRepos(ctx, tx, []string{orgRepoName}); err != nil {
+		return fmt.Errorf("SoftDeleteRepo: %v", err)
 	}
-	var r string
-	if err := row.Scan(&r); err != nil {
-		if err == sql.ErrNoRows {
-			return "", false, nil
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("SoftDeleteRepo: %v", err)
+	}
+	return nil
+}
+
+func deleteRepo(ctx context.Context, tx *sql.Tx, orgRepoName string) error {
+	for _, query := range []string{
+		`DELETE FROM repo_tags WHERE org_repo_name = $1;`,
+		`DELETE FROM repos WHERE org_repo_name = $1;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, orgRepoName); err != nil {
+			return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
 		}
-		return "", false, fmt.Errorf("NextReindexRepoTagsWork: %v", err)
 	}
-	return r, true, nil
+	return nil
 }
 
-// Store the given repos. Afterwards, they will be ready for repo tag indexing.
+// A repo, as found when re-indexing the list of all repos.
+type Repo struct {
+	// Something like "corp/my-repo".
+	OrgRepoName string
+
+	// When the repo was last pushed to, and how many tags it has. A zero
+	// PushedAt means both are unknown, in which case any stored values are
+	// kept.
+	PushedAt time.Time
+	TagCount int
+
+	// Whether the repo is archived, and whether it's a fork, which the rules
+	// may exclude.
+	Archived bool
+	Fork     bool
+
+	// The repo's GitHub node ID, which survives renames and transfers. Empty
+	// if unknown.
+	NodeID string
+}
+
+// Returned, wrapped, by StoreRepos when storing the given repos would delete
+// too many of the stored ones.
+var ErrTooManyDeletions = errors.New("too many repos would be deleted")
+
+// Stores the given repos, which must be a complete listing of all repos.
+// Afterwards, they will be ready for repo tag indexing. Repos that are already
+// stored get their PushedAt and TagCount updated, which makes them due for
+// re-indexing if they changed: see NextReindexRepoTagsWork.
+//
+// Stored repos with the same NodeID as one of the given repos, but a different
+// name, are moved to the new name: see RenameRepo.
+//
+// Other stored repos missing from the listing, ex because they were deleted or
+// are no longer Go repos, are soft-deleted along with their tags: they're no
+// longer indexed or served, but are restored if they're listed again. If that
+// would delete more than maxDeletedPercent of the stored repos, nothing is
+// stored and an error wrapping ErrTooManyDeletions is returned instead, since
+// the listing is more likely to be partial, ex during a GitHub outage.
+//
+// The given repos that the rules exclude aren't stored, and are deleted along
+// with their tags if they were. So are stored repos whose names the rules now
+// exclude. Stored tags that the rules now exclude are rejected, which takes
+// them out of the feed.
 //
-// TODO(jbarkhuysen): The given orgRepoNames should be treated as authoratative.
-// Any repos in GitHub not in this list should be deleted (and their repo tags).
-func (d *DB) StoreRepos(ctx context.Context, orgRepoNames []string) error {
-	if len(orgRepoNames) == 0 {
+// WARNING: Timezones aren't retained. Always pass UTC timezones.
+func (d *DB) StoreRepos(ctx context.Context, repos []*Repo, maxDeletedPercent float64) error {
+	if len(repos) == 0 {
 		return fmt.Errorf("StoreRepos called with 0 repos")
 	}
+	if err := d.storeRepos(ctx, repos, true, nil, maxDeletedPercent); err != nil {
+		return fmt.Errorf("StoreRepos: %w", err)
+	}
+	return nil
+}
+
+// Like StoreRepos, but the given repos need only be a complete listing of the
+// stored repos for which owned returns true, ex the repos of one source code
+// host: only those are soft-deleted if missing, and maxDeletedPercent is a
+// percentage of those.
+func (d *DB) StoreOwnedRepos(ctx context.Context, repos []*Repo, owned func(orgRepoName string) bool, maxDeletedPercent float64) error {
+	if len(repos) == 0 {
+		return fmt.Errorf("StoreOwnedRepos called with 0 repos")
+	}
+	if err := d.storeRepos(ctx, repos, true, owned, maxDeletedPercent); err != nil {
+		return fmt.Errorf("StoreOwnedRepos: %w", err)
+	}
+	return nil
+}
 
+// Like StoreRepos, but the given repos needn't be a complete listing: no stored
+// repos are deleted unless the rules exclude them, and stored repos aren't
+// checked against the rules.
+func (d *DB) UpsertRepos(ctx context.Context, repos []*Repo) error {
+	if err := d.storeRepos(ctx, repos, false, nil, 0); err != nil {
+		return fmt.Errorf("UpsertRepos: %w", err)
+	}
+	return nil
+}
+
+// owned, if not nil, limits the stored repos that authoritative repos may be
+// missing from.
+func (d *DB) storeRepos(ctx context.Context, repos []*Repo, authoritative bool, owned func(string) bool, maxDeletedPercent float64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Defer a rollback in case anything fails.
+	defer tx.Rollback()
+
+	var kept []*Repo
+	listed := make(map[string]bool)
+	excluded := make(map[string]string)
+	for _, r := range repos {
+		listed[r.OrgRepoName] = true
+		if reason := d.rules.RepoExclusion(r.OrgRepoName, r.Archived, r.Fork); reason != "" {
+			excluded[r.OrgRepoName] = reason
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if authoritative && d.rules != nil {
+		if err := d.excludeStoredRepoTags(ctx, tx, excluded); err != nil {
+			return err
+		}
+	}
+	for _, orgRepoName := range slices.Sorted(maps.Keys(excluded)) {
+		if err := deleteRepo(ctx, tx, orgRepoName); err != nil {
+			return err
+		}
+	}
+
+	// Before looking for missing repos, since renamed repos aren't missing.
+	if err := moveRenamedRepos(ctx, tx, kept); err != nil {
+		return err
+	}
+
+	if authoritative {
+		if err := softDeleteMissingRepos(ctx, tx, listed, owned, maxDeletedPercent); err != nil {
+			return err
+		}
+	}
+
+	if len(kept) > 0 {
+		if err := upsertRepos(ctx, tx, kept); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Soft-deletes the stored repos that aren't listed, and their tags, unless
+// that's more than maxDeletedPercent of them. Only the stored repos for which
+// owned returns true are considered, unless it's nil. See StoreRepos.
+func softDeleteMissingRepos(ctx context.Context, tx *sql.Tx, listed map[string]bool, owned func(string) bool, maxDeletedPercent float64) error {
+	query := `
+SELECT org_repo_name
+FROM repos
+WHERE deleted_at IS NULL;`
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	defer rows.Close()
+	var stored int
+	var missing []string
+	for rows.Next() {
+		var orgRepoName string
+		if err := rows.Scan(&orgRepoName); err != nil {
+			return err
+		}
+		if owned != nil && !owned(orgRepoName) {
+			continue
+		}
+		stored++
+		if !listed[orgRepoName] {
+			missing = append(missing, orgRepoName)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if percent := 100 * float64(len(missing)) / float64(stored); percent > maxDeletedPercent {
+		return fmt.Errorf("%w: %d of %d stored repos (%.1f%%) are missing from the listing, more than the %.1f%% allowed", ErrTooManyDeletions, len(missing), stored, percent, maxDeletedPercent)
+	}
+	if err := softDeleteRepos(ctx, tx, missing); err != nil {
+		return err
+	}
+	slices.Sort(missing)
+	slog.Info(fmt.Sprintf("soft-deleted %d repos missing from the listing of all repos: %s", len(missing), strings.Join(missing, ", ")))
+	return nil
+}
+
+// Soft-deletes the given repos and their tags. Repos that are already deleted
+// keep their deletion time.
+func softDeleteRepos(ctx context.Context, tx *sql.Tx, orgRepoNames []string) error {
+	for _, query := range []string{
+		`UPDATE repo_tags SET deleted_at = NOW() WHERE org_repo_name = ANY($1) AND deleted_at IS NULL;`,
+		`UPDATE repos SET deleted_at = NOW() WHERE org_repo_name = ANY($1) AND deleted_at IS NULL;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, pq.Array(orgRepoNames)); err != nil {
+			return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+		}
+	}
+	return nil
+}
+
+// Restores the given soft-deleted repos and their tags. Repos that aren't
+// deleted are left as is.
+func restoreRepos(ctx context.Context, tx *sql.Tx, orgRepoNames []string) error {
+	for _, query := range []string{
+		`UPDATE repo_tags SET deleted_at = NULL WHERE org_repo_name = ANY($1) AND deleted_at IS NOT NULL;`,
+		`UPDATE repos SET deleted_at = NULL WHERE org_repo_name = ANY($1) AND deleted_at IS NOT NULL;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, pq.Array(orgRepoNames)); err != nil {
+			return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+		}
+	}
+	return nil
+}
+
+// Adds the stored repos whose names the rules exclude to excluded, with the
+// reason, and rejects the stored tags that the rules exclude.
+func (d *DB) excludeStoredRepoTags(ctx context.Context, tx *sql.Tx, excluded map[string]string) error {
+	query := `
+SELECT org_repo_name
+FROM repos;`
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orgRepoName string
+		if err := rows.Scan(&orgRepoName); err != nil {
+			return err
+		}
+		if reason := d.rules.RepoExclusion(orgRepoName, false, false); reason != "" {
+			excluded[orgRepoName] = reason
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, orgRepoName := range slices.Sorted(maps.Keys(excluded)) {
+		slog.Info(fmt.Sprintf("removing repo %s: %s", orgRepoName, excluded[orgRepoName]))
+	}
+
+	if !d.rules.ExcludesTags() {
+		return nil
+	}
+	// Tags are excluded by name alone, so each excluded name is rejected in
+	// every repo at once, with an update per reason.
+	query = `
+SELECT DISTINCT tag_name
+FROM repo_tags
+WHERE rejection = '';`
+	tagRows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	defer tagRows.Close()
+	tagNamesByReason := make(map[string][]string)
+	for tagRows.Next() {
+		var tagName string
+		if err := tagRows.Scan(&tagName); err != nil {
+			return err
+		}
+		if reason := d.rules.TagExclusion(tagName); reason != "" {
+			tagNamesByReason[reason] = append(tagNamesByReason[reason], tagName)
+		}
+	}
+	if err := tagRows.Err(); err != nil {
+		return err
+	}
+	query = `
+UPDATE repo_tags
+SET rejection = $1
+WHERE tag_name = ANY($2) AND rejection = '';`
+	var rejected int64
+	for _, reason := range slices.Sorted(maps.Keys(tagNamesByReason)) {
+		res, err := tx.ExecContext(ctx, query, reason, pq.Array(tagNamesByReason[reason]))
+		if err != nil {
+			return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+		}
+		a, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		rejected += a
+	}
+	if rejected > 0 {
+		slog.Info(fmt.Sprintf("rejected %d stored tags excluded by the rules", rejected))
+	}
+	return nil
+}
+
+// Inserts or updates the given repos, restoring any that were soft-deleted.
+func upsertRepos(ctx context.Context, tx *sql.Tx, repos []*Repo) error {
 	var valueStrings []string
 	var valueArgs []any
-	for i, orn := range orgRepoNames {
-		valueStrings = append(valueStrings, fmt.Sprintf("($%d)", i+1))
-		valueArgs = append(valueArgs, orn)
+
+	// Number of fields in the SQL query used to correctly number query
+	// placeholders.
+	const fieldCount = 4
+
+	for i, r := range repos {
+		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d::TIMESTAMP, $%d::INTEGER, $%d)", fieldCount*i+1, fieldCount*i+2, fieldCount*i+3, fieldCount*i+4))
+		nodeID := sql.NullString{String: r.NodeID, Valid: r.NodeID != ""}
+		if r.PushedAt.IsZero() {
+			valueArgs = append(valueArgs, r.OrgRepoName, nil, nil, nodeID)
+		} else {
+			valueArgs = append(valueArgs, r.OrgRepoName, r.PushedAt.Format(time.RFC3339), r.TagCount, nodeID)
+		}
 	}
 
 	query := fmt.Sprintf(`
-INSERT INTO repos (org_repo_name)
+INSERT INTO repos (org_repo_name, pushed_at, tag_count, node_id)
 VALUES %s
-ON CONFLICT (org_repo_name) DO NOTHING;`, strings.Join(valueStrings, ",\n\t"))
+ON CONFLICT (org_repo_name) DO UPDATE
+SET pushed_at = COALESCE(EXCLUDED.pushed_at, repos.pushed_at), tag_count = COALESCE(EXCLUDED.tag_count, repos.tag_count), node_id = COALESCE(EXCLUDED.node_id, repos.node_id);`, strings.Join(valueStrings, ",\n\t"))
+
+	if _, err := tx.ExecContext(ctx, query, valueArgs...); err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+
+	var orgRepoNames []string
+	for _, r := range repos {
+		orgRepoNames = append(orgRepoNames, r.OrgRepoName)
+	}
+	return restoreRepos(ctx, tx, orgRepoNames)
+}
+
+// Stores that the given repo has no tags: any stored tags are deleted. Like
+// StoreRepoTags, this marks the repo's tag indexing as finished.
+func (d *DB) StoreNoRepoTags(ctx context.Context, orgRepoName string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("StoreNoRepoTags: %v", err)
+	}
+	// Defer a rollback in case anything fails.
+	defer tx.Rollback()
+
+	query := `
+DELETE FROM repo_tags
+WHERE org_repo_name = $1;`
+	if _, err := tx.ExecContext(ctx, query, orgRepoName); err != nil {
+		return fmt.Errorf("StoreNoRepoTags:\nquery: %s\nerror: %v", query, err)
+	}
 
-	if _, err := d.db.ExecContext(ctx, query, valueArgs...); err != nil {
-		return fmt.Errorf("StoreRepos:\nquery: %s\nerror: %v", query, err)
+	query = `
+UPDATE repos
+SET indexing_finished = NOW(), full_sync_finished = NOW()
+WHERE org_repo_name = $1;`
+	if _, err := tx.ExecContext(ctx, query, orgRepoName); err != nil {
+		return fmt.Errorf("StoreNoRepoTags:\nquery: %s\nerror: %v", query, err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("StoreNoRepoTags: %v", err)
+	}
 	return nil
 }
 
 // Store the given repo tags. It's permissable to give this function repo tags
-// for different repos.
+// for different repos. Rejected repo tags should be included: they are stored,
+// but not served.
+//
+// Repo tags that weren't already stored, or whose module path, version or
+// rejection changed, are marked as first seen now. Other repo tags keep their
+// first seen date, so that they aren't served again as new.
+//
+// Writers of repo tags take turns: see lockFeed. So repo tags are first seen in
+// the order they're committed, and a repo tag that a client hasn't seen yet is
+// never first seen before one that it has.
 //
 // WARNING: Timezones aren't retained. Always pass UTC timezones.
 //
 // WARNING: The given repo tags are treated as authoratative: for each repo that
 // tags are given, any stored tags not in the given list will be deleted. This
-// function SHOULD NOT be provided partial updates.
+// function SHOULD NOT be provided partial updates: see UpsertRepoTags.
 func (d *DB) StoreRepoTags(ctx context.Context, repoTags []*RepoTag) error {
 	if len(repoTags) == 0 {
 		return fmt.Errorf("StoreRepoTags called with 0 repo tags")
 	}
 
-	var valueStrings []string
-	var valueArgs []any
-	var conditionalStrings []string
-	var conditionalArgs []any
-
-	// Number of fields in the SQL query used to correctly number query
-	// placeholders.
-	const fieldCount = 4
-
+	// Passed as arrays rather than a parameter per value, since a statement
+	// can have at most 65535 parameters and repos can have many thousands of
+	// tags.
 	orgRepoNames := make(map[string]bool)
-	for i, rt := range repoTags {
-		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, $%d, $%d)", fieldCount*i+1, fieldCount*i+2, fieldCount*i+3, fieldCount*i+4))
-		valueArgs = append(valueArgs, rt.OrgRepoName)
-		valueArgs = append(valueArgs, rt.TagName)
-		valueArgs = append(valueArgs, rt.ModulePath)
-		valueArgs = append(valueArgs, rt.Created.Format(time.RFC3339))
+	var tagOrgRepoNames, tagNames []string
+	for _, rt := range repoTags {
 		orgRepoNames[rt.OrgRepoName] = true
+		tagOrgRepoNames = append(tagOrgRepoNames, rt.OrgRepoName)
+		tagNames = append(tagNames, rt.TagName)
 	}
-	i := 1
-	for orgRepoName := range orgRepoNames {
-		if len(conditionalStrings) == 0 {
-			conditionalStrings = append(conditionalStrings, fmt.Sprintf("WHERE org_repo_name = $%d", i))
-		} else {
-			conditionalStrings = append(conditionalStrings, fmt.Sprintf("OR org_repo_name = $%d", i))
-		}
-		conditionalArgs = append(conditionalArgs, orgRepoName)
-		i++
-	}
+	repoNames := pq.Array(slices.Collect(maps.Keys(orgRepoNames)))
 
 	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -200,23 +966,29 @@ func (d *DB) StoreRepoTags(ctx context.Context, repoTags []*RepoTag) error {
 	// Defer a rollback in case anything fails.
 	defer tx.Rollback()
 
-	query := "DELETE FROM repo_tags " + strings.Join(conditionalStrings, "\n")
-	if _, err := tx.ExecContext(ctx, query, conditionalArgs...); err != nil {
-		return fmt.Errorf("StoreRepoTags:\nquery: %s\nerror: %v", query, err)
+	if err := lockFeed(ctx, tx); err != nil {
+		return fmt.Errorf("StoreRepoTags: %v", err)
 	}
 
-	query = fmt.Sprintf(`
-INSERT INTO repo_tags (org_repo_name, tag_name, module_path, created)
-VALUES %s
-ON CONFLICT (org_repo_name, tag_name) DO UPDATE
-SET created = EXCLUDED.created;`, strings.Join(valueStrings, ",\n"))
-	if _, err := tx.ExecContext(ctx, query, valueArgs...); err != nil {
+	// Only delete the tags that are no longer present: re-inserting the rest
+	// would lose their first seen date.
+	query := `
+DELETE FROM repo_tags
+WHERE org_repo_name = ANY($1)
+AND (org_repo_name, tag_name) NOT IN (SELECT * FROM unnest($2::TEXT[], $3::TEXT[]));`
+	if _, err := tx.ExecContext(ctx, query, repoNames, pq.Array(tagOrgRepoNames), pq.Array(tagNames)); err != nil {
 		return fmt.Errorf("StoreRepoTags:\nquery: %s\nerror: %v", query, err)
 	}
 
-	query = `UPDATE repos
-SET indexing_finished = NOW()` + "\n" + strings.Join(conditionalStrings, "\n")
-	if _, err := tx.ExecContext(ctx, query, conditionalArgs...); err != nil {
+	if err := upsertRepoTags(ctx, tx, repoTags); err != nil {
+		return fmt.Errorf("StoreRepoTags: %v", err)
+	}
+
+	query = `
+UPDATE repos
+SET indexing_finished = NOW(), full_sync_finished = NOW()
+WHERE org_repo_name = ANY($1);`
+	if _, err := tx.ExecContext(ctx, query, repoNames); err != nil {
 		return fmt.Errorf("StoreRepoTags:\nquery: %s\nerror: %v", query, err)
 	}
 
@@ -226,3 +998,101 @@ SET indexing_finished = NOW()` + "\n" + strings.Join(conditionalStrings, "\n")
 
 	return nil
 }
+
+// Like StoreRepoTags, but only adds and updates the given repo tags of the given
+// repo: stored tags that aren't given are kept. Use for partial updates, ex
+// when only the newest tags were fetched. repoTags may be empty, in which case
+// the repo's tag indexing is just marked as finished.
+//
+// WARNING: Timezones aren't retained. Always pass UTC timezones.
+func (d *DB) UpsertRepoTags(ctx context.Context, orgRepoName string, repoTags []*RepoTag) error {
+	for _, rt := range repoTags {
+		if rt.OrgRepoName != orgRepoName {
+			return fmt.Errorf("UpsertRepoTags called for repo %s with a tag of repo %s", orgRepoName, rt.OrgRepoName)
+		}
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("UpsertRepoTags: %v", err)
+	}
+	// Defer a rollback in case anything fails.
+	defer tx.Rollback()
+
+	if err := lockFeed(ctx, tx); err != nil {
+		return fmt.Errorf("UpsertRepoTags: %v", err)
+	}
+
+	if len(repoTags) > 0 {
+		if err := upsertRepoTags(ctx, tx, repoTags); err != nil {
+			return fmt.Errorf("UpsertRepoTags: %v", err)
+		}
+	}
+
+	query := `
+UPDATE repos
+SET indexing_finished = NOW()
+WHERE org_repo_name = $1;`
+	if _, err := tx.ExecContext(ctx, query, orgRepoName); err != nil {
+		return fmt.Errorf("UpsertRepoTags:\nquery: %s\nerror: %v", query, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("UpsertRepoTags: %v", err)
+	}
+	return nil
+}
+
+// Identifies the lock taken by lockFeed. Arbitrary, but must not be used for
+// any other advisory lock.
+const feedLockKey = 7007
+
+// Waits for other writers of repo tags to commit, and keeps them waiting until
+// the given transaction ends.
+//
+// first_seen defaults to the time of the insert rather than the start of the
+// transaction. Without the lock, a transaction that started inserting before
+// another one committed could still commit afterwards, adding repo tags before
+// the position of a client that already read the other transaction's tags.
+// Taken before any rows are written, so that writers can't deadlock.
+func lockFeed(ctx context.Context, tx *sql.Tx) error {
+	query := `SELECT pg_advisory_xact_lock($1);`
+	if _, err := tx.ExecContext(ctx, query, feedLockKey); err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	return nil
+}
+
+// Inserts the given repo tags, or updates them if they're already stored. See
+// StoreRepoTags for how first_seen is set. The transaction must hold the lock
+// taken by lockFeed.
+func upsertRepoTags(ctx context.Context, tx *sql.Tx, repoTags []*RepoTag) error {
+	// A column per array, rather than a parameter per value: see
+	// StoreRepoTags.
+	var orgRepoNames, tagNames, modulePaths, versions, rejections, created, targetSHAs []string
+	for _, rt := range repoTags {
+		orgRepoNames = append(orgRepoNames, rt.OrgRepoName)
+		tagNames = append(tagNames, rt.TagName)
+		modulePaths = append(modulePaths, rt.ModulePath)
+		versions = append(versions, rt.Version)
+		rejections = append(rejections, rt.Rejection)
+		created = append(created, rt.Created.Format(time.RFC3339))
+		targetSHAs = append(targetSHAs, rt.TargetSHA)
+	}
+
+	// first_seen isn't inserted, so EXCLUDED.first_seen is its default: the
+	// time of the insert.
+	query := `
+INSERT INTO repo_tags (org_repo_name, tag_name, module_path, version, rejection, created, target_sha)
+SELECT * FROM unnest($1::TEXT[], $2::TEXT[], $3::TEXT[], $4::TEXT[], $5::TEXT[], $6::TIMESTAMP[], $7::TEXT[])
+ON CONFLICT (org_repo_name, tag_name) DO UPDATE
+SET module_path = EXCLUDED.module_path, version = EXCLUDED.version, rejection = EXCLUDED.rejection, created = EXCLUDED.created, target_sha = EXCLUDED.target_sha,
+first_seen = CASE
+    WHEN (repo_tags.module_path, repo_tags.version, repo_tags.rejection) IS DISTINCT FROM (EXCLUDED.module_path, EXCLUDED.version, EXCLUDED.rejection) THEN EXCLUDED.first_seen
+    ELSE repo_tags.first_seen
+END;`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(orgRepoNames), pq.Array(tagNames), pq.Array(modulePaths), pq.Array(versions), pq.Array(rejections), pq.Array(created), pq.Array(targetSHAs)); err != nil {
+		return fmt.Errorf("\nquery: %s\nerror: %v", query, err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"iter"
 	"os"
 	"strconv"
 	"testing"
@@ -76,6 +77,9 @@ func resetTables(t *testing.T, db *sql.DB) {
 	if _, err := db.ExecContext(t.Context(), "DROP TABLE IF EXISTS repos;"); err != nil {
 		t.Fatalf("resetTables: error dropping repos table: %v", err)
 	}
+	if _, err := db.ExecContext(t.Context(), "DROP TABLE IF EXISTS repo_moves;"); err != nil {
+		t.Fatalf("resetTables: error dropping repo_moves table: %v", err)
+	}
 	if _, err := db.ExecContext(t.Context(), "DROP TABLE IF EXISTS repo_indexing;"); err != nil {
 		t.Fatalf("resetTables: error dropping repo_indexing table: %v", err)
 	}
@@ -121,7 +125,7 @@ FROM repos`
 	}
 
 	query = `
-SELECT org_repo_name, tag_name, module_path, created
+SELECT org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha
 FROM repo_tags
 ORDER BY created DESC`
 	rows, err = sdb.QueryContext(t.Context(), query)
@@ -131,7 +135,7 @@ ORDER BY created DESC`
 	defer rows.Close()
 	for rows.Next() {
 		var rt db.RepoTag
-		if err := rows.Scan(&rt.OrgRepoName, &rt.TagName, &rt.ModulePath, &rt.Created); err != nil {
+		if err := rows.Scan(&rt.OrgRepoName, &rt.TagName, &rt.ModulePath, &rt.Version, &rt.Rejection, &rt.Created, &rt.FirstSeen, &rt.TargetSHA); err != nil {
 			t.Fatalf("repoTags: %v", err)
 		}
 		repoTags[rt.OrgRepoName] = append(repoTags[rt.OrgRepoName], &rt)
@@ -143,10 +147,17 @@ ORDER BY created DESC`
 	return repoTags
 }
 
+// Stores the given repo tags as-is. Repo tags without a FirstSeen are stored as
+// first seen when they were created.
 func populateRepoTags(t *testing.T, db *sql.DB, repoTags []*db.RepoTag) {
 	t.Helper()
 
 	for _, rt := range repoTags {
+		firstSeen := rt.FirstSeen
+		if firstSeen.IsZero() {
+			firstSeen = rt.Created
+		}
+
 		query := fmt.Sprintf(`
 INSERT INTO repos (org_repo_name)
 VALUES ('%s')
@@ -156,10 +167,10 @@ ON CONFLICT (org_repo_name) DO NOTHING;`, rt.OrgRepoName)
 		}
 
 		query = fmt.Sprintf(`
-INSERT INTO repo_tags (org_repo_name, tag_name, module_path, created)
-VALUES ('%s', '%s', '%s', TIMESTAMP WITH TIME ZONE '%s')
+INSERT INTO repo_tags (org_repo_name, tag_name, module_path, version, rejection, created, first_seen, target_sha)
+VALUES ('%s', '%s', '%s', '%s', '%s', TIMESTAMP WITH TIME ZONE '%s', TIMESTAMP WITH TIME ZONE '%s', '%s')
 ON CONFLICT (org_repo_name, tag_name) DO UPDATE
-SET created = EXCLUDED.created;`, rt.OrgRepoName, rt.TagName, rt.ModulePath, rt.Created.Format(time.RFC3339))
+SET created = EXCLUDED.created, first_seen = EXCLUDED.first_seen;`, rt.OrgRepoName, rt.TagName, rt.ModulePath, rt.Version, rt.Rejection, rt.Created.Format(time.RFC3339), firstSeen.Format(time.RFC3339), rt.TargetSHA)
 		if _, err := db.ExecContext(t.Context(), query); err != nil {
 			t.Fatalf("populateRepoTags: error inserting into repo_tags table:\nquery: %s\nerror:%v", query, err)
 		}
@@ -184,11 +195,60 @@ func setSingleRepoIndexing(t *testing.T, db *sql.DB, orgRepoName string, indexin
 
 	query := fmt.Sprintf(`
 UPDATE repos
-SET indexing_began = TIMESTAMP WITH TIME ZONE '%s', indexing_finished = TIMESTAMP WITH TIME ZONE '%s'
-WHERE org_repo_name = '%s'`,
+SET indexing_began = TIMESTAMP WITH TIME ZONE '%[1]s', indexing_finished = TIMESTAMP WITH TIME ZONE '%[2]s', full_sync_finished = TIMESTAMP WITH TIME ZONE '%[2]s'
+WHERE org_repo_name = '%[3]s'`,
 		indexingBegan.Format(time.RFC3339), indexingFinished.Format(time.RFC3339), orgRepoName)
 
 	if _, err := db.ExecContext(t.Context(), query); err != nil {
 		t.Fatalf("setSingleRepoIndexing: error updating repos table:\nquery: %s\nerror: %v", query, err)
 	}
 }
+
+// Collects the repo tags of a streamed fetch, stopping at the first error.
+func collect(repoTags iter.Seq2[*db.RepoTag, error]) ([]*db.RepoTag, error) {
+	var got []*db.RepoTag
+	for rt, err := range repoTags {
+		if err != nil {
+			return nil, err
+		}
+		got = append(got, rt)
+	}
+	return got, nil
+}
+
+// Returns the names of the soft-deleted repos, sorted.
+func deletedRepos(t *testing.T, sdb *sql.DB) []string {
+	t.Helper()
+
+	query := `
+SELECT org_repo_name
+FROM repos
+WHERE deleted_at IS NOT NULL
+ORDER BY org_repo_name`
+	rows, err := sdb.QueryContext(t.Context(), query)
+	if err != nil {
+		t.Fatalf("deletedRepos:\nquery: %s\nerror: %v", query, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("deletedRepos: %v", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("deletedRepos: %v", err)
+	}
+	return names
+}
+
+// Returns the repo name of each of the given repo tags, in order.
+func repoNames(repoTags []*db.RepoTag) []string {
+	var names []string
+	for _, rt := range repoTags {
+		names = append(names, rt.OrgRepoName)
+	}
+	return names
+}
@@ -1,12 +1,17 @@
 package db_test
 
 import (
+	"errors"
+	"fmt"
 	"maps"
 	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Netflix-Skunkworks/golang-index/internal/db"
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
@@ -16,15 +21,20 @@ func TestFetchRepoTags(t *testing.T) {
 	resetTables(t, sqlDB)
 
 	allTags := []*db.RepoTag{
-		// Ordered by Created ASC (ascending chronological order), which is how we expect it returned.
-		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now()},
-		{OrgRepoName: "foo/bar", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().Add(time.Second)},
-		{OrgRepoName: "foo/gaz", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gaz", Created: time.Now().Add(time.Minute)},
+		// Ordered by FirstSeen ASC (ascending chronological order), which is how we expect it returned.
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now(), FirstSeen: time.Now()},
+		{OrgRepoName: "foo/bar", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.2", Created: time.Now().Add(time.Second), FirstSeen: time.Now().Add(time.Second)},
+		// A tag pushed recently on an old commit.
+		{OrgRepoName: "foo/gaz", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour), FirstSeen: time.Now().Add(time.Minute)},
 	}
 	populateRepoTags(t, sqlDB, allTags)
+	// Rejected tags are never returned.
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "_gheMigrationPR-435", Rejection: "_gheMigrationPR-435 is not a semantic version", Created: time.Now().Add(time.Second), FirstSeen: time.Now().Add(time.Second)},
+	})
 
 	// Get all.
-	gotTags, err := sutDB.FetchRepoTags(t.Context(), time.Now().Add(-1*time.Hour), 1000)
+	gotTags, err := collect(sutDB.FetchRepoTags(t.Context(), time.Now().Add(-1*time.Hour), 1000))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -33,7 +43,7 @@ func TestFetchRepoTags(t *testing.T) {
 	}
 
 	// Get with limit.
-	gotTags, err = sutDB.FetchRepoTags(t.Context(), time.Now().Add(-1*time.Hour), 2)
+	gotTags, err = collect(sutDB.FetchRepoTags(t.Context(), time.Now().Add(-1*time.Hour), 2))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,7 +52,7 @@ func TestFetchRepoTags(t *testing.T) {
 	}
 
 	// Get with since.
-	gotTags, err = sutDB.FetchRepoTags(t.Context(), time.Now().Add(2*time.Second), 1)
+	gotTags, err = collect(sutDB.FetchRepoTags(t.Context(), time.Now().Add(2*time.Second), 1))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,11 +61,114 @@ func TestFetchRepoTags(t *testing.T) {
 	}
 }
 
+func TestFetchRepoTagsAfter(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	// More tags first seen at the same time than fit in one page.
+	created := time.Now().UTC().Truncate(time.Second)
+	allTags := []*db.RepoTag{
+		// Ordered by FirstSeen, then OrgRepoName, then TagName, which is how we expect it returned.
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: created, FirstSeen: created},
+		{OrgRepoName: "foo/bar", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.2", Created: created, FirstSeen: created},
+		{OrgRepoName: "foo/gaz", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.1", Created: created, FirstSeen: created},
+		{OrgRepoName: "foo/gaz", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.2", Created: created, FirstSeen: created},
+		{OrgRepoName: "foo/bar", TagName: "v0.0.3", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.3", Created: created.Add(time.Second), FirstSeen: created.Add(time.Second)},
+	}
+	populateRepoTags(t, sqlDB, allTags)
+
+	// Page through all tags, two at a time.
+	var gotTags []*db.RepoTag
+	page, err := collect(sutDB.FetchRepoTags(t.Context(), created.Add(-1*time.Hour), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(page) > 0 {
+		gotTags = append(gotTags, page...)
+		if page, err = collect(sutDB.FetchRepoTagsAfter(t.Context(), page[len(page)-1].FeedCursor(), 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff(allTags, gotTags, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("FetchRepoTagsAfter: -want,+got: %s", diff)
+	}
+}
+
+func TestFetchModuleVersions(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	moduleTags := []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now(), FirstSeen: time.Now()},
+		{OrgRepoName: "foo/bar", TagName: "v2.0.0", ModulePath: "github.somecompany.net/foo/bar", Version: "v2.0.0+incompatible", Created: time.Now().Add(time.Second), FirstSeen: time.Now().Add(time.Second)},
+	}
+	populateRepoTags(t, sqlDB, moduleTags)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		// Other modules in the same repo.
+		{OrgRepoName: "foo/bar", TagName: "cli/v0.0.1", ModulePath: "github.somecompany.net/foo/bar/cli", Version: "v0.0.1", Created: time.Now(), FirstSeen: time.Now()},
+		// Rejected tags are never returned.
+		{OrgRepoName: "foo/bar", TagName: "v3.0.0", ModulePath: "github.somecompany.net/foo/bar", Rejection: "some reason", Created: time.Now(), FirstSeen: time.Now()},
+	})
+
+	gotTags, err := sutDB.FetchModuleVersions(t.Context(), "github.somecompany.net/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(moduleTags, gotTags, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("FetchModuleVersions: -want,+got: %s", diff)
+	}
+}
+
+func TestFetchRejectedRepoTags(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	rejectedTags := []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "_gheMigrationPR-435", Rejection: "_gheMigrationPR-435 is not a semantic version", Created: time.Now(), FirstSeen: time.Now()},
+		{OrgRepoName: "foo/bar", TagName: "v1.2", Rejection: "v1.2 is not a canonical semantic version (should be v1.2.0)", Created: time.Now(), FirstSeen: time.Now()},
+	}
+	populateRepoTags(t, sqlDB, rejectedTags)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now(), FirstSeen: time.Now()},
+		{OrgRepoName: "foo/gaz", TagName: "v1.3", Rejection: "v1.3 is not a canonical semantic version (should be v1.3.0)", Created: time.Now(), FirstSeen: time.Now()},
+	})
+
+	gotTags, err := sutDB.FetchRejectedRepoTags(t.Context(), "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rejectedTags, gotTags, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("FetchRejectedRepoTags: -want,+got: %s", diff)
+	}
+}
+
+func TestFetchAllRepoTags(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	allTags := []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "_gheMigrationPR-435", Rejection: "_gheMigrationPR-435 is not a semantic version", Created: time.Now(), FirstSeen: time.Now()},
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now(), FirstSeen: time.Now(), TargetSHA: "abc123"},
+	}
+	populateRepoTags(t, sqlDB, allTags)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/gaz", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.1", Created: time.Now(), FirstSeen: time.Now()},
+	})
+
+	gotTags, err := sutDB.FetchAllRepoTags(t.Context(), "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(allTags, gotTags, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("FetchAllRepoTags: -want,+got: %s", diff)
+	}
+}
+
 func TestStoreRepos(t *testing.T) {
 	sutDB, sqlDB := setupDB(t)
 	resetTables(t, sqlDB)
 
-	if err := sutDB.StoreRepos(t.Context(), []string{"foo/bar", "gaz/urk"}); err != nil {
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}, {OrgRepoName: "gaz/urk"}}, 0); err != nil {
 		t.Fatal(err)
 	}
 
@@ -65,8 +178,8 @@ func TestStoreRepos(t *testing.T) {
 		t.Errorf("StoreRepos: -want,+got: %s", diff)
 	}
 
-	// Repeated storing same repo has no effect.
-	if err := sutDB.StoreRepos(t.Context(), []string{"foo/bar"}); err != nil {
+	// Repeated storing same repo doesn't duplicate it.
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar", PushedAt: time.Now().UTC(), TagCount: 1}, {OrgRepoName: "gaz/urk"}}, 0); err != nil {
 		t.Fatal(err)
 	}
 	gotRepos = slices.Sorted(maps.Keys(repoTags(t, sqlDB)))
@@ -75,37 +188,398 @@ func TestStoreRepos(t *testing.T) {
 	}
 }
 
+func TestStoreRepos_SoftDeletesMissingRepos(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	created := time.Now().Add(-1000 * time.Hour).UTC()
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: created},
+		{OrgRepoName: "foo/gone", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gone", Version: "v0.0.1", Created: created},
+	})
+
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}}, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	// The missing repo and its tags are kept, but no longer served or indexed.
+	if diff := cmp.Diff([]string{"foo/gone"}, deletedRepos(t, sqlDB)); diff != "" {
+		t.Errorf("StoreRepos: unexpected deleted repos: -want,+got: %s", diff)
+	}
+	got, err := collect(sutDB.FetchRepoTags(t.Context(), time.Time{}, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"foo/bar"}, repoNames(got)); diff != "" {
+		t.Errorf("FetchRepoTags: -want,+got: %s", diff)
+	}
+	if known, err := sutDB.RequestReindex(t.Context(), "foo/gone"); err != nil || known {
+		t.Errorf("RequestReindex: expected a deleted repo to be unknown, got %v (err: %v)", known, err)
+	}
+
+	// The repo and its tags are restored once it's listed again.
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}, {OrgRepoName: "foo/gone"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := deletedRepos(t, sqlDB); len(got) != 0 {
+		t.Errorf("StoreRepos: expected no deleted repos, got %v", got)
+	}
+	got, err = collect(sutDB.FetchRepoTags(t.Context(), time.Time{}, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"foo/bar", "foo/gone"}, repoNames(got)); diff != "" {
+		t.Errorf("FetchRepoTags: -want,+got: %s", diff)
+	}
+}
+
+func TestStoreRepos_MovesRenamedRepos(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar", NodeID: "R_1"}, {OrgRepoName: "foo/gaz", NodeID: "R_2"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now().Add(-time.Hour), TargetSHA: "sha1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(-time.Minute)
+
+	// foo/bar was transferred to bar/bar, then renamed to bar/baz. Neither move
+	// counts as a deletion.
+	for _, name := range []string{"bar/bar", "bar/baz"} {
+		if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: name, NodeID: "R_1"}, {OrgRepoName: "foo/gaz", NodeID: "R_2"}}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := repoTags(t, sqlDB)
+	if diff := cmp.Diff([]string{"bar/baz", "foo/gaz"}, slices.Sorted(maps.Keys(got))); diff != "" {
+		t.Errorf("StoreRepos: -want,+got: %s", diff)
+	}
+	if len(got["bar/baz"]) != 1 || got["bar/baz"][0].TagName != "v0.0.1" {
+		t.Errorf("StoreRepos: expected bar/baz to keep its tag, got %v", got["bar/baz"])
+	}
+
+	moves, err := sutDB.FetchRepoMoves(t.Context(), before, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotMoves []string
+	for _, m := range moves {
+		gotMoves = append(gotMoves, fmt.Sprintf("%s: %s -> %s", m.NodeID, m.FromOrgRepoName, m.ToOrgRepoName))
+	}
+	if diff := cmp.Diff([]string{"R_1: foo/bar -> bar/bar", "R_1: bar/bar -> bar/baz"}, gotMoves); diff != "" {
+		t.Errorf("FetchRepoMoves: -want,+got: %s", diff)
+	}
+	history, err := sutDB.FetchRepoPathHistory(t.Context(), "bar/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(moves, history); diff != "" {
+		t.Errorf("FetchRepoPathHistory: -want,+got: %s", diff)
+	}
+}
+
+func TestStoreRepos_TooManyDeletions(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/a"}, {OrgRepoName: "foo/b"}, {OrgRepoName: "foo/c"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting 2 of 3 repos is more than 50%.
+	err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/a"}, {OrgRepoName: "foo/new"}}, 50)
+	if !errors.Is(err, db.ErrTooManyDeletions) {
+		t.Fatalf("StoreRepos: expected ErrTooManyDeletions, got %v", err)
+	}
+
+	// Nothing was stored or deleted.
+	if diff := cmp.Diff([]string{"foo/a", "foo/b", "foo/c"}, slices.Sorted(maps.Keys(repoTags(t, sqlDB)))); diff != "" {
+		t.Errorf("StoreRepos: -want,+got: %s", diff)
+	}
+	if got := deletedRepos(t, sqlDB); len(got) != 0 {
+		t.Errorf("StoreRepos: expected no deleted repos, got %v", got)
+	}
+
+	// Upserting never deletes.
+	if err := sutDB.UpsertRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/new"}}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"foo/a", "foo/b", "foo/c", "foo/new"}, slices.Sorted(maps.Keys(repoTags(t, sqlDB)))); diff != "" {
+		t.Errorf("UpsertRepos: -want,+got: %s", diff)
+	}
+	if got := deletedRepos(t, sqlDB); len(got) != 0 {
+		t.Errorf("UpsertRepos: expected no deleted repos, got %v", got)
+	}
+}
+
+func TestStoreRepos_Rules(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "foo/bar", TagName: "_gheMigrationPR-1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.0", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "foo/old", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/old", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "sandbox/toy", TagName: "v0.0.1", ModulePath: "github.somecompany.net/sandbox/toy", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+	})
+	r, err := rules.Parse([]byte(`{"denyOrgs": ["sandbox"], "excludeArchived": true, "excludeForks": true, "excludeTags": ["_gheMigrationPR-*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sutDB.SetRules(r)
+
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{
+		{OrgRepoName: "foo/bar"},
+		{OrgRepoName: "foo/old", Archived: true},
+		{OrgRepoName: "foo/fork", Fork: true},
+		{OrgRepoName: "foo/new"},
+	}, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Excluded repos are removed along with their tags, even when they're no
+	// longer found, and excluded tags are rejected.
+	got := repoTags(t, sqlDB)
+	if diff := cmp.Diff([]string{"foo/bar", "foo/new"}, slices.Sorted(maps.Keys(got))); diff != "" {
+		t.Errorf("StoreRepos: -want,+got: %s", diff)
+	}
+	gotRejections := make(map[string]string)
+	for _, rt := range got["foo/bar"] {
+		gotRejections[rt.TagName] = rt.Rejection
+	}
+	wantRejections := map[string]string{"v0.0.1": "", "_gheMigrationPR-1": `excluded by tag pattern "_gheMigrationPR-*"`}
+	if diff := cmp.Diff(wantRejections, gotRejections); diff != "" {
+		t.Errorf("StoreRepos: unexpected rejections: -want,+got: %s", diff)
+	}
+}
+
+func TestStoreOwnedRepos(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	created := time.Now().Add(-1000 * time.Hour).UTC()
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: created},
+		{OrgRepoName: "gitlab.somecompany.net/group/kept", TagName: "v0.0.1", ModulePath: "gitlab.somecompany.net/group/kept", Version: "v0.0.1", Created: created},
+		{OrgRepoName: "gitlab.somecompany.net/group/gone", TagName: "v0.0.1", ModulePath: "gitlab.somecompany.net/group/gone", Version: "v0.0.1", Created: created},
+	})
+
+	// A listing of the GitLab repos only shows which GitLab repos are gone.
+	gitlab := func(orgRepoName string) bool { return strings.HasPrefix(orgRepoName, "gitlab.somecompany.net/") }
+	if err := sutDB.StoreOwnedRepos(t.Context(), []*db.Repo{{OrgRepoName: "gitlab.somecompany.net/group/kept"}}, gitlab, 50); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"gitlab.somecompany.net/group/gone"}, deletedRepos(t, sqlDB)); diff != "" {
+		t.Errorf("StoreOwnedRepos: unexpected deleted repos: -want,+got: %s", diff)
+	}
+
+	// The threshold is a percentage of the owned repos.
+	err := sutDB.StoreOwnedRepos(t.Context(), []*db.Repo{{OrgRepoName: "gitlab.somecompany.net/group/new"}}, gitlab, 50)
+	if !errors.Is(err, db.ErrTooManyDeletions) {
+		t.Errorf("StoreOwnedRepos: expected ErrTooManyDeletions, got %v", err)
+	}
+}
+
+func TestRenameRepo(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "gaz/urk", TagName: "v0.0.1", ModulePath: "github.somecompany.net/gaz/urk", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+	})
+	setAllReposIndexing(t, sqlDB, time.Now().Add(-time.Minute), time.Now().Add(-time.Minute))
+
+	if moved, err := sutDB.RenameRepo(t.Context(), "foo/bar", "foo/baz"); err != nil || !moved {
+		t.Fatalf("RenameRepo: expected foo/bar to be moved, got %v (err: %v)", moved, err)
+	}
+	// An unknown repo isn't stored under its new name, since it may not be a
+	// Go repo.
+	if moved, err := sutDB.RenameRepo(t.Context(), "foo/unknown", "foo/renamed"); err != nil || moved {
+		t.Fatalf("RenameRepo: expected foo/unknown not to be moved, got %v (err: %v)", moved, err)
+	}
+
+	// The tags move with the repo, and their module paths are determined again
+	// when it's re-indexed.
+	got := repoTags(t, sqlDB)
+	if diff := cmp.Diff([]string{"foo/baz", "gaz/urk"}, slices.Sorted(maps.Keys(got))); diff != "" {
+		t.Errorf("RenameRepo: -want,+got: %s", diff)
+	}
+	if len(got["foo/baz"]) != 1 || got["foo/baz"][0].TagName != "v0.0.1" || got["foo/baz"][0].TargetSHA != "" {
+		t.Errorf("RenameRepo: expected foo/baz to have v0.0.1 without a target SHA, got %v", got["foo/baz"])
+	}
+	history, err := sutDB.FetchRepoPathHistory(t.Context(), "foo/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].FromOrgRepoName != "foo/bar" || history[0].ToOrgRepoName != "foo/baz" {
+		t.Errorf("FetchRepoPathHistory: expected a move from foo/bar, got %v", history)
+	}
+
+	// The new name is re-indexed right away.
+	gotRepoToReindex, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotWork || gotRepoToReindex != "foo/baz" {
+		t.Errorf("NextReindexRepoTagsWork: expected foo/baz, got %q (gotWork=%v)", gotRepoToReindex, gotWork)
+	}
+}
+
+func TestSoftDeleteRepo(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "gaz/urk", TagName: "v0.0.1", ModulePath: "github.somecompany.net/gaz/urk", Version: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+	})
+
+	if err := sutDB.SoftDeleteRepo(t.Context(), "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting an unknown repo is not an error.
+	if err := sutDB.SoftDeleteRepo(t.Context(), "foo/unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The repo and its tags are kept, but no longer served.
+	if diff := cmp.Diff([]string{"foo/bar"}, deletedRepos(t, sqlDB)); diff != "" {
+		t.Errorf("SoftDeleteRepo: unexpected deleted repos: -want,+got: %s", diff)
+	}
+	got, err := collect(sutDB.FetchRepoTags(t.Context(), time.Time{}, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"gaz/urk"}, repoNames(got)); diff != "" {
+		t.Errorf("FetchRepoTags: -want,+got: %s", diff)
+	}
+
+	// The repo is restored once it's listed again.
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}, {OrgRepoName: "gaz/urk"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := deletedRepos(t, sqlDB); len(got) != 0 {
+		t.Errorf("StoreRepos: expected no deleted repos, got %v", got)
+	}
+}
+
 func TestStoreRepoTags(t *testing.T) {
-	// Whenever we store tags for a repo, all pre-existing tags are removed.
-	// Only new tags remain.
+	// Whenever we store tags for a repo, pre-existing tags that aren't given
+	// are removed. Only given tags remain.
 	sutDB, sqlDB := setupDB(t)
 	resetTables(t, sqlDB)
 
-	if err := sutDB.StoreRepos(t.Context(), []string{"foo/bar", "foo/gaz"}); err != nil {
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}, {OrgRepoName: "foo/gaz"}}, 0); err != nil {
 		t.Fatal(err)
 	}
-	preExistingTag1 := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gaz", Created: time.Now().UTC()}
-	preExistingTag2 := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/gaz", Created: time.Now().UTC()}
-	newTag := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.3", ModulePath: "github.somecompany.net/foo/gaz", Created: time.Now().UTC()}
-	preExistingTag3 := db.RepoTag{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().UTC()}
+	now := time.Now().UTC()
+	hourAgo := now.Add(-1 * time.Hour)
+	preExistingTag1 := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.1", Created: hourAgo, FirstSeen: hourAgo}
+	preExistingTag2 := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.2", Created: hourAgo, FirstSeen: hourAgo}
+	preExistingTag3 := db.RepoTag{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: hourAgo, FirstSeen: hourAgo}
+	preExistingTag4 := db.RepoTag{OrgRepoName: "foo/bar", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.2", Created: hourAgo, FirstSeen: hourAgo}
+
+	populateRepoTags(t, sqlDB, []*db.RepoTag{&preExistingTag1, &preExistingTag2, &preExistingTag3, &preExistingTag4})
 
-	populateRepoTags(t, sqlDB, []*db.RepoTag{&preExistingTag1, &preExistingTag2, &preExistingTag3})
+	newTag := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.3", ModulePath: "github.somecompany.net/foo/gaz", Version: "v0.0.3", Created: hourAgo, TargetSHA: "abc123"}
+	newRejectedTag := db.RepoTag{OrgRepoName: "foo/gaz", TagName: "v0.0.4-", Rejection: "v0.0.4- is not a semantic version", Created: now}
+	// preExistingTag4 now belongs to a different module.
+	changedTag := preExistingTag4
+	changedTag.ModulePath = "stash.somecompany.net/foo/bar"
+	changedTag.FirstSeen = time.Time{}
 
-	// newTag is new. preExistingTag2 is not included.
-	if err := sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{&preExistingTag1, &newTag, &preExistingTag3}); err != nil {
+	// newTag and newRejectedTag are new. preExistingTag2 is not included.
+	if err := sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{&preExistingTag1, &newTag, &newRejectedTag, &preExistingTag3, &changedTag}); err != nil {
 		t.Fatal(err)
 	}
 
+	// Pre-existing tags keep their first seen date. New and changed tags are
+	// first seen now, regardless of when they were created.
+	wantNewTag, wantNewRejectedTag, wantChangedTag := newTag, newRejectedTag, changedTag
+	wantNewTag.FirstSeen, wantNewRejectedTag.FirstSeen, wantChangedTag.FirstSeen = now, now, now
 	want := map[string][]*db.RepoTag{
-		"foo/gaz": {&preExistingTag1, &newTag},
-		"foo/bar": {&preExistingTag3},
+		"foo/gaz": {&preExistingTag1, &wantNewTag, &wantNewRejectedTag},
+		"foo/bar": {&preExistingTag3, &wantChangedTag},
 	}
 	gotRepoTags := repoTags(t, sqlDB)
-	if diff := cmp.Diff(want, gotRepoTags, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+	sortByTagName := cmpopts.SortSlices(func(a, b *db.RepoTag) bool { return a.TagName < b.TagName })
+	if diff := cmp.Diff(want, gotRepoTags, cmpopts.EquateApproxTime(5*time.Second), sortByTagName); diff != "" {
 		t.Errorf("StoreRepoTags: -want,+got: %s", diff)
 	}
 }
 
+func TestStoreRepoTags_ManyTags(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// More tags than a statement could have parameters for, with a parameter
+	// per value.
+	var tags []*db.RepoTag
+	for i := range 20000 {
+		tags = append(tags, &db.RepoTag{OrgRepoName: "foo/bar", TagName: fmt.Sprintf("v0.0.%d", i), ModulePath: "github.somecompany.net/foo/bar", Version: fmt.Sprintf("v0.0.%d", i), Created: time.Now().UTC()})
+	}
+	if err := sutDB.StoreRepoTags(t.Context(), tags); err != nil {
+		t.Fatal(err)
+	}
+	if err := sutDB.StoreRepoTags(t.Context(), tags[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sutDB.UpsertRepoTags(t.Context(), "foo/bar", tags); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(repoTags(t, sqlDB)["foo/bar"]); got != len(tags) {
+		t.Errorf("wanted %d tags, got %d", len(tags), got)
+	}
+}
+
+func TestStoreRepoTags_FirstSeenInCommitOrder(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for another writer, which holds the feed lock until it
+	// commits.
+	otherWriter, err := sqlDB.BeginTx(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherWriter.Rollback()
+	if _, err := otherWriter.ExecContext(t.Context(), `SELECT pg_advisory_xact_lock(7007);`); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := make(chan error)
+	go func() {
+		stored <- sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: time.Now().UTC()}})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	var committed time.Time
+	if err := otherWriter.QueryRowContext(t.Context(), `SELECT clock_timestamp() AT TIME ZONE 'UTC';`).Scan(&committed); err != nil {
+		t.Fatal(err)
+	}
+	if err := otherWriter.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-stored; err != nil {
+		t.Fatal(err)
+	}
+
+	// The tag was inserted after the other writer committed, so clients that
+	// read the other writer's tags can't have gone past it.
+	got := repoTags(t, sqlDB)["foo/bar"]
+	if len(got) != 1 || got[0].FirstSeen.Before(committed) {
+		t.Errorf("wanted a tag first seen after %v, got %+v", committed, got)
+	}
+}
+
 // Both the "All repos" and "Tags for one repo" reindexing work queues work the
 // same way. So, we can share a single set of test cases for both.
 type reindexWorkerTestCase struct {
@@ -219,7 +693,7 @@ func TestNextReindexRepoTagsWork_SingleRepo(t *testing.T) {
 			populateRepoTags(t, sqlDB, []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().Add(-1000 * time.Hour)}})
 			setSingleRepoIndexing(t, sqlDB, "foo/bar", tc.lastIndexingBegan, tc.lastIndexingFinished)
 
-			gotRepoToReindex, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), tc.reindexTTL, tc.reindexPeriod)
+			gotRepoToReindex, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), tc.reindexTTL, tc.reindexPeriod, tc.reindexPeriod)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -243,7 +717,7 @@ func TestNextReindexRepoTagsWork_SingleRepo(t *testing.T) {
 func TestNextReindexRepoTagsWork_NoRepos(t *testing.T) {
 	sutDB, sqlDB := setupDB(t)
 	resetTables(t, sqlDB)
-	_, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour)
+	_, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -262,7 +736,7 @@ func TestNextReindexRepoTagsWork_QuickSuccession(t *testing.T) {
 	setSingleRepoIndexing(t, sqlDB, "foo/bar", time.Now().Add(-24*time.Hour), time.Now().Add(-24*time.Hour))
 
 	// Take work for the first time: should return true.
-	_, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour)
+	_, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -271,7 +745,7 @@ func TestNextReindexRepoTagsWork_QuickSuccession(t *testing.T) {
 	}
 
 	// Try to take work the second time: should return false.
-	_, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour)
+	_, _, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -296,7 +770,7 @@ func TestNextReindexRepoTagsWork_MultipleRepo_TakeReindexNeeded(t *testing.T) {
 	// Needs re-indexing (based on reindex period specified a bit below).
 	setSingleRepoIndexing(t, sqlDB, "gaz/urk", time.Now().Add(-1*time.Hour), time.Now().Add(-1*time.Hour))
 
-	gotRepoToReindex, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 10*time.Minute, 10*time.Minute)
+	gotRepoToReindex, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 10*time.Minute, 10*time.Minute, 10*time.Minute)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -326,7 +800,7 @@ func TestNextReindexRepoTagsWork_MultipleRepo_TakeOldestNeedingReindexing(t *tes
 	setSingleRepoIndexing(t, sqlDB, "bee/doh", time.Now().Add(-70*time.Minute), time.Now().Add(-70*time.Minute))
 	setSingleRepoIndexing(t, sqlDB, "gaz/urk", time.Now().Add(-60*time.Minute), time.Now().Add(-60*time.Minute))
 
-	gotRepoToReindex, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 10*time.Minute, 10*time.Minute)
+	gotRepoToReindex, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 10*time.Minute, 10*time.Minute, 10*time.Minute)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -338,13 +812,92 @@ func TestNextReindexRepoTagsWork_MultipleRepo_TakeOldestNeedingReindexing(t *tes
 	}
 }
 
+func TestNextReindexRepoTagsWorkBatch(t *testing.T) {
+	// Take the oldest repos needing re-indexing, up to the batch size.
+
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "bee/doh", TagName: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "gaz/urk", TagName: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "new/repo", TagName: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)},
+	})
+	setSingleRepoIndexing(t, sqlDB, "foo/bar", time.Now().Add(-50*time.Minute), time.Now().Add(-50*time.Minute))
+	setSingleRepoIndexing(t, sqlDB, "bee/doh", time.Now().Add(-70*time.Minute), time.Now().Add(-70*time.Minute))
+	setSingleRepoIndexing(t, sqlDB, "gaz/urk", time.Now().Add(-60*time.Minute), time.Now().Add(-60*time.Minute))
+	// Doesn't need re-indexing.
+	setSingleRepoIndexing(t, sqlDB, "new/repo", time.Now().Add(-1*time.Minute), time.Now().Add(-1*time.Minute))
+
+	got, err := sutDB.NextReindexRepoTagsWorkBatch(t.Context(), 2, 10*time.Minute, 10*time.Minute, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*db.ReindexWork{{OrgRepoName: "bee/doh"}, {OrgRepoName: "gaz/urk"}}
+	sortByName := cmpopts.SortSlices(func(a, b *db.ReindexWork) bool { return a.OrgRepoName < b.OrgRepoName })
+	if diff := cmp.Diff(want, got, sortByName); diff != "" {
+		t.Errorf("NextReindexRepoTagsWorkBatch: -want,+got: %s", diff)
+	}
+
+	// The claimed repos aren't handed out again.
+	got, err = sutDB.NextReindexRepoTagsWorkBatch(t.Context(), 2, 10*time.Minute, 10*time.Minute, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []*db.ReindexWork{{OrgRepoName: "foo/bar"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NextReindexRepoTagsWorkBatch: -want,+got: %s", diff)
+	}
+}
+
+func TestNextReindexRepoTagsWorkBatch_ConcurrentWorkers(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	var tags []*db.RepoTag
+	for i := range 100 {
+		tags = append(tags, &db.RepoTag{OrgRepoName: fmt.Sprintf("foo/repo%d", i), TagName: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)})
+	}
+	populateRepoTags(t, sqlDB, tags)
+	setAllReposIndexing(t, sqlDB, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	// Workers claiming at the same time never claim the same repo.
+	var mu sync.Mutex
+	claimed := make(map[string]int)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work, err := sutDB.NextReindexRepoTagsWorkBatch(t.Context(), 20, 10*time.Minute, 10*time.Minute, 24*time.Hour)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, w := range work {
+				claimed[w.OrgRepoName]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	for orgRepoName, n := range claimed {
+		if n > 1 {
+			t.Errorf("%s was claimed %d times", orgRepoName, n)
+		}
+	}
+}
+
 func TestNextReindexRepoTags_Roundtrip(t *testing.T) {
 	sutDB, sqlDB := setupDB(t)
 	resetTables(t, sqlDB)
 	populateRepoTags(t, sqlDB, []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", Created: time.Now().Add(-1000 * time.Hour)}})
 
 	// First, get some work.
-	gotRepoToReindex, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), time.Hour, time.Hour) // Re-index TTL & period are unused here.
+	gotRepoToReindex, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), time.Hour, time.Hour, time.Hour) // Re-index TTL & period are unused here.
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -363,7 +916,7 @@ func TestNextReindexRepoTags_Roundtrip(t *testing.T) {
 
 	// We should not be able to get work, since we just finished (StoreRepoTags)
 	// work within the last 1h.
-	_, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), time.Hour, time.Hour)
+	_, _, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), time.Hour, time.Hour, time.Hour)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -376,7 +929,7 @@ func TestNextReindexRepoTags_Roundtrip(t *testing.T) {
 	// Note: We're only operating at the second granularity, so let's sleep 1s
 	// first.
 	time.Sleep(time.Second)
-	_, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), time.Second, time.Second)
+	_, _, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), time.Second, time.Second, time.Second)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -384,3 +937,275 @@ func TestNextReindexRepoTags_Roundtrip(t *testing.T) {
 		t.Fatalf("NextReindexRepoTagsWork: expected work but got none")
 	}
 }
+
+func TestRequestReindex(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{
+		{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().Add(-1000 * time.Hour)},
+		{OrgRepoName: "gaz/urk", TagName: "v0.0.1", ModulePath: "github.somecompany.net/gaz/urk", Created: time.Now().Add(-1000 * time.Hour)},
+	})
+	// foo/bar was indexed recently. gaz/urk is due for re-indexing, but
+	// requested re-indexes come first.
+	setSingleRepoIndexing(t, sqlDB, "foo/bar", time.Now().Add(-time.Minute), time.Now().Add(-time.Minute))
+	setSingleRepoIndexing(t, sqlDB, "gaz/urk", time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+
+	known, err := sutDB.RequestReindex(t.Context(), "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !known {
+		t.Errorf("RequestReindex: expected foo/bar to be known")
+	}
+	known, err = sutDB.RequestReindex(t.Context(), "foo/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known {
+		t.Errorf("RequestReindex: expected foo/unknown to be unknown")
+	}
+
+	gotRepoToReindex, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotWork || gotRepoToReindex != "foo/bar" {
+		t.Fatalf("NextReindexRepoTagsWork: expected foo/bar, got %q (gotWork=%v)", gotRepoToReindex, gotWork)
+	}
+
+	// A request while foo/bar is being indexed waits for the indexing to
+	// finish, then re-indexes it again.
+	time.Sleep(time.Second)
+	if _, err := sutDB.RequestReindex(t.Context(), "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	gotRepoToReindex, _, _, err = sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotRepoToReindex != "gaz/urk" {
+		t.Fatalf("NextReindexRepoTagsWork: expected gaz/urk while foo/bar is being indexed, got %q", gotRepoToReindex)
+	}
+	if err := sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().UTC()}}); err != nil {
+		t.Fatal(err)
+	}
+	gotRepoToReindex, _, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotWork || gotRepoToReindex != "foo/bar" {
+		t.Fatalf("NextReindexRepoTagsWork: expected foo/bar again, got %q (gotWork=%v)", gotRepoToReindex, gotWork)
+	}
+
+	// Once re-indexed, the request is done.
+	if err := sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().UTC()}}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, gotWork, err = sutDB.NextReindexRepoTagsWork(t.Context(), 5*time.Minute, 24*time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotWork {
+		t.Errorf("NextReindexRepoTagsWork: expected no work")
+	}
+}
+
+func TestNextReindexRepoTagsWork_SkipsUnchangedRepos(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	pushedAt := time.Now().Add(-48 * time.Hour).UTC()
+	storeRepo := func(pushedAt time.Time, tagCount int) {
+		t.Helper()
+		if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar", PushedAt: pushedAt, TagCount: tagCount}}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reindex := func(fullResyncPeriod time.Duration) bool {
+		t.Helper()
+		// Note: We're only operating at the second granularity, so let's sleep
+		// 1s first to get past the (artificially low) reindex period.
+		time.Sleep(time.Second)
+		_, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), time.Second, time.Second, fullResyncPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotWork {
+			if err := sutDB.StoreRepoTags(t.Context(), []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: pushedAt}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return gotWork
+	}
+
+	storeRepo(pushedAt, 1)
+	if !reindex(time.Hour) {
+		t.Fatalf("expected a new repo to be re-indexed")
+	}
+	storeRepo(pushedAt, 1)
+	if reindex(time.Hour) {
+		t.Errorf("expected an unchanged repo not to be re-indexed")
+	}
+	storeRepo(pushedAt.Add(time.Minute), 1)
+	if !reindex(time.Hour) {
+		t.Errorf("expected a pushed to repo to be re-indexed")
+	}
+	storeRepo(pushedAt.Add(time.Minute), 2)
+	if !reindex(time.Hour) {
+		t.Errorf("expected a repo with a new tag count to be re-indexed")
+	}
+	if !reindex(time.Second) {
+		t.Errorf("expected an unchanged repo to be re-indexed past the full resync period")
+	}
+}
+
+func TestNextReindexRepoTagsWork_ReindexesReposWithoutPushTimes(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	// Some hosts, like Bitbucket Server, don't say when repos were pushed to.
+	pushedAt := time.Now().Add(-48 * time.Hour).UTC()
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{
+		{OrgRepoName: "stash.somecompany.net/PROJ/bar"},
+		{OrgRepoName: "foo/bar", PushedAt: pushedAt, TagCount: 1},
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+	reindex := func() []string {
+		t.Helper()
+		// Note: We're only operating at the second granularity, so let's sleep
+		// 1s first to get past the (artificially low) reindex period.
+		time.Sleep(time.Second)
+		work, err := sutDB.NextReindexRepoTagsWorkBatch(t.Context(), 10, time.Second, time.Second, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, w := range work {
+			got = append(got, w.OrgRepoName)
+			if err := sutDB.StoreNoRepoTags(t.Context(), w.OrgRepoName); err != nil {
+				t.Fatal(err)
+			}
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	if diff := cmp.Diff([]string{"foo/bar", "stash.somecompany.net/PROJ/bar"}, reindex()); diff != "" {
+		t.Fatalf("NextReindexRepoTagsWorkBatch: expected new repos to be re-indexed: -want,+got: %s", diff)
+	}
+	// Unlike unchanged repos with a known push time, repos without one are
+	// re-indexed every reindex period.
+	for i := range 2 {
+		if diff := cmp.Diff([]string{"stash.somecompany.net/PROJ/bar"}, reindex()); diff != "" {
+			t.Errorf("NextReindexRepoTagsWorkBatch: attempt %d: -want,+got: %s", i+1, diff)
+		}
+	}
+}
+
+func TestStoreNoRepoTags(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+	populateRepoTags(t, sqlDB, []*db.RepoTag{{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Created: time.Now().Add(-1000 * time.Hour)}})
+	setSingleRepoIndexing(t, sqlDB, "foo/bar", time.Now().Add(-1*time.Minute), time.Now().Add(-24*time.Hour))
+
+	if err := sutDB.StoreNoRepoTags(t.Context(), "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := repoTags(t, sqlDB)
+	if tags, ok := got["foo/bar"]; !ok || len(tags) != 0 {
+		t.Errorf("StoreNoRepoTags: expected foo/bar with no tags, got %v", got)
+	}
+
+	// Indexing is finished, so foo/bar isn't due for re-indexing.
+	_, _, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), time.Second, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotWork {
+		t.Errorf("NextReindexRepoTagsWork: expected no work")
+	}
+}
+
+func TestUpsertRepoTags(t *testing.T) {
+	// Unlike StoreRepoTags, tags that aren't given are kept.
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	now := time.Now().UTC()
+	hourAgo := now.Add(-1 * time.Hour)
+	preExistingTag1 := db.RepoTag{OrgRepoName: "foo/bar", TagName: "v0.0.1", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.1", Created: hourAgo, FirstSeen: hourAgo, TargetSHA: "abc123"}
+	preExistingTag2 := db.RepoTag{OrgRepoName: "foo/bar", TagName: "v0.0.2", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.2", Created: hourAgo, FirstSeen: hourAgo, TargetSHA: "def456"}
+	populateRepoTags(t, sqlDB, []*db.RepoTag{&preExistingTag1, &preExistingTag2})
+
+	newTag := db.RepoTag{OrgRepoName: "foo/bar", TagName: "v0.0.3", ModulePath: "github.somecompany.net/foo/bar", Version: "v0.0.3", Created: now, TargetSHA: "789abc"}
+	if err := sutDB.UpsertRepoTags(t.Context(), "foo/bar", []*db.RepoTag{&newTag, &preExistingTag2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sutDB.UpsertRepoTags(t.Context(), "foo/bar", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNewTag := newTag
+	wantNewTag.FirstSeen = now
+	want := map[string][]*db.RepoTag{
+		"foo/bar": {&preExistingTag1, &preExistingTag2, &wantNewTag},
+	}
+	gotRepoTags := repoTags(t, sqlDB)
+	sortByTagName := cmpopts.SortSlices(func(a, b *db.RepoTag) bool { return a.TagName < b.TagName })
+	if diff := cmp.Diff(want, gotRepoTags, cmpopts.EquateApproxTime(5*time.Second), sortByTagName); diff != "" {
+		t.Errorf("UpsertRepoTags: -want,+got: %s", diff)
+	}
+
+	if err := sutDB.UpsertRepoTags(t.Context(), "foo/bar", []*db.RepoTag{{OrgRepoName: "foo/gaz", TagName: "v0.0.1"}}); err == nil {
+		t.Errorf("UpsertRepoTags: expected an error for a tag of another repo")
+	}
+}
+
+func TestNextReindexRepoTagsWork_FullSync(t *testing.T) {
+	sutDB, sqlDB := setupDB(t)
+	resetTables(t, sqlDB)
+
+	if err := sutDB.StoreRepos(t.Context(), []*db.Repo{{OrgRepoName: "foo/bar"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	reindex := func(fullResyncPeriod time.Duration) (fullSync bool) {
+		t.Helper()
+		// Note: We're only operating at the second granularity, so let's sleep
+		// 1s first to get past the (artificially low) reindex period.
+		time.Sleep(time.Second)
+		_, fullSync, gotWork, err := sutDB.NextReindexRepoTagsWork(t.Context(), time.Second, time.Second, fullResyncPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !gotWork {
+			t.Fatalf("NextReindexRepoTagsWork: expected work but got none")
+		}
+		return fullSync
+	}
+
+	if !reindex(time.Hour) {
+		t.Errorf("expected a repo that was never fully synced to be fully synced")
+	}
+	// Only the newest tags were stored, so the repo isn't fully synced yet.
+	if err := sutDB.UpsertRepoTags(t.Context(), "foo/bar", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sutDB.RequestReindex(t.Context(), "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if !reindex(time.Hour) {
+		t.Errorf("expected a repo that was only partially synced to be fully synced")
+	}
+
+	if err := sutDB.StoreNoRepoTags(t.Context(), "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sutDB.RequestReindex(t.Context(), "foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if reindex(time.Hour) {
+		t.Errorf("expected a repo fully synced within the full resync period not to be fully synced")
+	}
+}
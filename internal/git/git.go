@@ -0,0 +1,284 @@
+// Package git implements querying git repos over the smart HTTP protocol, for
+// hosts that have no API.
+package git
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+)
+
+// A handle for querying a configured list of repos on a git host, ex cgit or a
+// Gerrit mirror, with git protocol v2 over smart HTTP. Only the objects needed
+// are fetched.
+type GitSCM struct {
+	gitHostName string
+	useHTTPS    bool
+
+	// The URL path of each repo, keyed by repo name. See NewGitSCM.
+	repoPaths map[string]string
+
+	// Which tags to index. See SetRules.
+	rules *rules.Rules
+}
+
+// Creates a new git SCM for the repos at the given URL paths on the host, ex
+// "team/repo.git". Repos are named after their path without the ".git" suffix,
+// ex "team/repo".
+func NewGitSCM(gitHostName string, repoPaths []string, useHTTPS bool) *GitSCM {
+	scm := &GitSCM{gitHostName: gitHostName, useHTTPS: useHTTPS, repoPaths: make(map[string]string)}
+	for _, p := range repoPaths {
+		p = strings.Trim(p, "/")
+		scm.repoPaths[strings.TrimSuffix(p, ".git")] = p
+	}
+	return scm
+}
+
+// Sets the rules that decide which tags TagsForRepos excludes. A nil *Rules,
+// the default, excludes nothing.
+func (scm *GitSCM) SetRules(r *rules.Rules) {
+	scm.rules = r
+}
+
+// Returns the URL of the given repo, ex "https://git.somecompany.net/team/repo.git".
+func (scm *GitSCM) repoURL(orgRepoName string) (string, error) {
+	p, ok := scm.repoPaths[orgRepoName]
+	if !ok {
+		return "", fmt.Errorf("%s isn't one of the configured repos", orgRepoName)
+	}
+	protocol := "http://"
+	if scm.useHTTPS {
+		protocol = "https://"
+	}
+	return protocol + scm.gitHostName + "/" + p, nil
+}
+
+// Returns the configured repos. There's no telling whether they're Go repos,
+// or when they were pushed to, without fetching from each, so every one is
+// returned, with PushedAt left unknown. The results are always complete.
+func (scm *GitSCM) GoRepos(ctx context.Context) (_ []*vcs.Repo, complete bool, _ error) {
+	var results []*vcs.Repo
+	for _, name := range slices.Sorted(maps.Keys(scm.repoPaths)) {
+		results = append(results, &vcs.Repo{OrgRepoName: name})
+	}
+	return results, true, nil
+}
+
+// Retrieves the tags of each of the given repos, one by one. Tags that aren't
+// valid module versions, or that the rules exclude, are included with their
+// Rejection set.
+//
+// Every tag is listed with ls-refs, so the results are always complete. Tags
+// are dated like GitHub dates them: annotated tags by when they were created,
+// and lightweight tags by their commit. Dates of known tags that still point at
+// the same commit are reused, and the tag and commit objects of the rest are
+// fetched without their trees if the host supports filters.
+func (scm *GitSCM) TagsForRepos(ctx context.Context, requests []*vcs.TagsRequest) ([]*vcs.TagsResult, error) {
+	var results []*vcs.TagsResult
+	for _, r := range requests {
+		tags, err := scm.tagsForRepo(ctx, r)
+		results = append(results, &vcs.TagsResult{OrgRepoName: r.OrgRepoName, Tags: tags, Complete: err == nil, Err: err})
+	}
+	return results, nil
+}
+
+func (scm *GitSCM) tagsForRepo(ctx context.Context, r *vcs.TagsRequest) ([]*vcs.RepoTag, error) {
+	repoURL, err := scm.repoURL(r.OrgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("TagsForRepos: %v", err)
+	}
+	caps, err := scm.capabilities(ctx, repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %v", r.OrgRepoName, err)
+	}
+	refs, err := scm.lsTags(ctx, repoURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("error querying tags for %s: %v", r.OrgRepoName, err)
+	}
+
+	store := make(objectStore)
+	var undated []string
+	for _, ref := range refs {
+		if k, ok := r.Known[ref.name]; !ok || k.TargetSHA != ref.commit || k.TagDate.IsZero() {
+			undated = append(undated, ref.oid)
+		}
+	}
+	if len(undated) > 0 {
+		if err := scm.fetch(ctx, repoURL, caps, undated, fetchOptions{shallow: true, filter: "tree:0"}, store); err != nil {
+			return nil, fmt.Errorf("error fetching tags of %s: %v", r.OrgRepoName, err)
+		}
+	}
+
+	var tags []*vcs.RepoTag
+	commits := make(map[string]string)
+	for _, ref := range refs {
+		tag := &vcs.RepoTag{Tag: ref.name, TargetSHA: ref.commit}
+		if k, ok := r.Known[ref.name]; ok && k.TargetSHA == ref.commit && !k.TagDate.IsZero() {
+			tag.TagDate = k.TagDate
+		} else if o := store[ref.oid]; o != nil && (o.typ == objTag || o.typ == objCommit) {
+			if tag.TagDate, err = o.date(); err != nil {
+				return nil, fmt.Errorf("error dating %s tag %s: %v", r.OrgRepoName, ref.name, err)
+			}
+		} else {
+			// Tags of trees or blobs can't be module versions.
+			continue
+		}
+		tags = append(tags, tag)
+		commits[ref.name] = ref.commit
+	}
+
+	_, _, err = vcs.ResolveTags(ctx, r.OrgRepoName, scm.gitHostName+"/"+r.OrgRepoName, tags, r.Known, scm.rules, func(ctx context.Context, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+		return scm.goMods(ctx, repoURL, caps, store, commits, files), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Retrieves the given go.mod files, given the commit of each tag. Fetches the
+// trees of the tags' commits, without their blobs if the host supports
+// filters, then the go.mod blobs, all at once.
+func (scm *GitSCM) goMods(ctx context.Context, repoURL string, caps capabilities, store objectStore, commits map[string]string, files []vcs.GoModFile) []*vcs.GoModFetch {
+	results := make([]*vcs.GoModFetch, len(files))
+	var wants []string
+	for _, f := range files {
+		if _, err := rootTree(store, commits[f.Tag]); err != nil {
+			wants = append(wants, commits[f.Tag])
+		}
+	}
+	if len(wants) > 0 {
+		if err := scm.fetch(ctx, repoURL, caps, wants, fetchOptions{shallow: true, filter: "blob:none"}, store); err != nil {
+			for i := range results {
+				results[i] = &vcs.GoModFetch{Err: fmt.Errorf("error fetching trees: %v", err)}
+			}
+			return results
+		}
+	}
+
+	blobs := make([]string, len(files))
+	wants = nil
+	for i, f := range files {
+		blob, found, err := lookup(store, commits[f.Tag], path.Join(f.Dir, "go.mod"))
+		if err != nil || !found {
+			results[i] = &vcs.GoModFetch{Err: err}
+			continue
+		}
+		blobs[i] = blob
+		if _, ok := store[blob]; !ok {
+			wants = append(wants, blob)
+		}
+	}
+	if len(wants) > 0 {
+		if err := scm.fetch(ctx, repoURL, caps, wants, fetchOptions{}, store); err != nil {
+			for i := range results {
+				if results[i] == nil {
+					results[i] = &vcs.GoModFetch{Err: fmt.Errorf("error fetching go.mod files: %v", err)}
+				}
+			}
+			return results
+		}
+	}
+
+	for i := range results {
+		if results[i] != nil {
+			continue
+		}
+		o, err := get(store, blobs[i], objBlob)
+		if err != nil {
+			results[i] = &vcs.GoModFetch{Err: err}
+			continue
+		}
+		results[i] = &vcs.GoModFetch{Content: o.data, Found: true}
+	}
+	return results
+}
+
+// Returns the commit that the given tag points to.
+func (scm *GitSCM) tagCommit(ctx context.Context, repoURL, tag string) (string, error) {
+	refs, err := scm.lsTags(ctx, repoURL, tag)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range refs {
+		if r.name == tag {
+			return r.commit, nil
+		}
+	}
+	return "", fmt.Errorf("no tag %s", tag)
+}
+
+// Retrieves the contents of the go.mod file in the given repo subdirectory at
+// the given tag. found is false if there is no such go.mod file.
+func (scm *GitSCM) GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error) {
+	repoURL, err := scm.repoURL(orgRepoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("GoMod: %v", err)
+	}
+	caps, err := scm.capabilities(ctx, repoURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying %s: %v", orgRepoName, err)
+	}
+	commit, err := scm.tagCommit(ctx, repoURL, tag)
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying %s tag %s: %v", orgRepoName, tag, err)
+	}
+	result := scm.goMods(ctx, repoURL, caps, make(objectStore), map[string]string{tag: commit}, []vcs.GoModFile{{Tag: tag, Dir: dir}})[0]
+	return result.Content, result.Found, result.Err
+}
+
+// Builds a zip archive of the repo contents at the given tag, from a shallow
+// fetch of the tag's commit. All files in the archive are inside a single
+// top-level directory. Symlinks and submodules are left out, as they are from
+// module zips.
+func (scm *GitSCM) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	repoURL, err := scm.repoURL(orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("Zipball: %v", err)
+	}
+	caps, err := scm.capabilities(ctx, repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %v", orgRepoName, err)
+	}
+	commit, err := scm.tagCommit(ctx, repoURL, tag)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s tag %s: %v", orgRepoName, tag, err)
+	}
+	store := make(objectStore)
+	if err := scm.fetch(ctx, repoURL, caps, []string{commit}, fetchOptions{shallow: true}, store); err != nil {
+		return nil, fmt.Errorf("error fetching %s (tag: %s): %v", orgRepoName, tag, err)
+	}
+	return zipball(store, orgRepoName, tag, commit)
+}
+
+// Builds a zip archive of the given commit of the repo, whose objects must all
+// be available, inside a single top-level directory named after the repo and
+// tag.
+func zipball(objs objects, orgRepoName, tag, commit string) ([]byte, error) {
+	tree, err := rootTree(objs, commit)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s (tag: %s): %v", orgRepoName, tag, err)
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	prefix := path.Base(orgRepoName) + "-" + strings.ReplaceAll(tag, "/", "-")
+	if err := addToZip(objs, zw, tree, prefix); err != nil {
+		return nil, fmt.Errorf("error archiving %s (tag: %s): %v", orgRepoName, tag, err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error archiving %s (tag: %s): %v", orgRepoName, tag, err)
+	}
+	if archive.Len() > vcs.MaxZipballSize {
+		return nil, fmt.Errorf("archive of %s (tag: %s) is larger than %d bytes", orgRepoName, tag, vcs.MaxZipballSize)
+	}
+	return archive.Bytes(), nil
+}
@@ -0,0 +1,274 @@
+package git
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Runs git in dir, with commits and tags dated at the given time.
+func runGit(t *testing.T, dir string, date time.Time, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=A U Thor", "GIT_AUTHOR_EMAIL=author@example.com", "GIT_AUTHOR_DATE="+date.Format(time.RFC3339),
+		"GIT_COMMITTER_NAME=A U Thor", "GIT_COMMITTER_EMAIL=author@example.com", "GIT_COMMITTER_DATE="+date.Format(time.RFC3339),
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Commits the given files, keyed by path, in the work tree at dir.
+func commitFiles(t *testing.T, dir string, date time.Time, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, dir, date, "add", "-A")
+	runGit(t, dir, date, "commit", "-q", "-m", "commit")
+}
+
+// Creates the bare repo team/repo.git in root, with tags:
+//
+//   - v1.0.0, lightweight, at a commit of 2025-01-01 with a go.mod file.
+//   - v1.0 and _gheMigrationPR-1 at the same commit.
+//   - v1.1.0, annotated on 2025-02-01, and tools/cli/v0.1.0, lightweight, at a
+//     commit of 2025-01-02 that adds a nested module.
+//
+// Returns the path of the bare repo.
+func createTestRepo(t *testing.T, root string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	bare := filepath.Join(root, "team", "repo.git")
+	work := t.TempDir()
+	jan1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	jan2 := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	runGit(t, root, jan1, "init", "-q", "--bare", bare)
+	runGit(t, root, jan1, "init", "-q", work)
+
+	commitFiles(t, work, jan1, map[string]string{
+		"go.mod":  "module git.somecompany.net/team/repo\n",
+		"repo.go": "package repo\n",
+	})
+	runGit(t, work, jan1, "tag", "v1.0.0")
+	runGit(t, work, jan1, "tag", "v1.0")
+	runGit(t, work, jan1, "tag", "_gheMigrationPR-1")
+	commitFiles(t, work, jan2, map[string]string{
+		"tools/cli/go.mod":  "module git.somecompany.net/team/cli\n",
+		"tools/cli/main.go": "package main\n",
+	})
+	runGit(t, work, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), "tag", "-a", "-m", "v1.1.0", "v1.1.0")
+	runGit(t, work, jan2, "tag", "tools/cli/v0.1.0")
+	runGit(t, work, jan2, "push", "-q", bare, "HEAD:refs/heads/main", "--tags")
+	runGit(t, bare, jan2, "symbolic-ref", "HEAD", "refs/heads/main")
+	return bare
+}
+
+// The tags of the repo made by createTestRepo, given the tags got, which the
+// target SHAs are taken from. Tags are in name order, like ls-refs lists them.
+func wantTestTags(t *testing.T, got []*vcs.RepoTag) []*vcs.RepoTag {
+	t.Helper()
+	if len(got) != 5 {
+		t.Fatalf("expected 5 tags, got %d", len(got))
+	}
+	v1SHA, v11SHA := got[0].TargetSHA, got[4].TargetSHA
+	if v1SHA == v11SHA || len(v1SHA) != 40 {
+		t.Errorf("unexpected target SHAs %q and %q", v1SHA, v11SHA)
+	}
+
+	jan1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	jan2 := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	return []*vcs.RepoTag{
+		{Tag: "_gheMigrationPR-1", TagDate: jan1, Rejection: `excluded by tag pattern "_gheMigrationPR-*"`, TargetSHA: v1SHA},
+		{Tag: "tools/cli/v0.1.0", TagDate: jan2, ModulePath: "git.somecompany.net/team/cli", Version: "v0.1.0", TargetSHA: v11SHA},
+		{Tag: "v1.0", TagDate: jan1, Rejection: "v1.0 is not a canonical semantic version (should be v1.0.0)", TargetSHA: v1SHA},
+		{Tag: "v1.0.0", TagDate: jan1, ModulePath: "git.somecompany.net/team/repo", Version: "v1.0.0", TargetSHA: v1SHA},
+		// Annotated tags are dated by when they were created.
+		{Tag: "v1.1.0", TagDate: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), ModulePath: "git.somecompany.net/team/repo", Version: "v1.1.0", TargetSHA: v11SHA},
+	}
+}
+
+// The rules that createTestRepo's tags are checked against.
+func testRules(t *testing.T) *rules.Rules {
+	t.Helper()
+	r, err := rules.Parse([]byte(`{"excludeTags": ["_gheMigrationPR-*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// Serves the repo made by createTestRepo with git http-backend, the way a
+// smart HTTP host would, with filters allowed if allowFilter is true. Returns
+// the host name and the paths of the requests made, ex
+// "/team/repo.git/git-upload-pack".
+func createTestGitServer(t *testing.T, allowFilter bool) (string, *[]string) {
+	t.Helper()
+	root := t.TempDir()
+	bare := createTestRepo(t, root)
+	runGit(t, bare, time.Now(), "config", "uploadpack.allowFilter", strconv.FormatBool(allowFilter))
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1", "GIT_CONFIG_NOSYSTEM=1"},
+	}
+	var gotRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequests = append(gotRequests, r.URL.Path)
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://"), &gotRequests
+}
+
+func TestGoRepos(t *testing.T) {
+	sut := NewGitSCM("git.somecompany.net", []string{"team/repo.git", "/other/repo/"}, true)
+
+	got, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+	want := []*vcs.Repo{{OrgRepoName: "other/repo"}, {OrgRepoName: "team/repo"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepos(t *testing.T) {
+	for _, allowFilter := range []bool{true, false} {
+		t.Run(map[bool]string{true: "filter", false: "no filter"}[allowFilter], func(t *testing.T) {
+			hostName, _ := createTestGitServer(t, allowFilter)
+			sut := NewGitSCM(hostName, []string{"team/repo.git"}, false)
+			sut.SetRules(testRules(t))
+
+			got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "team/repo"}, {OrgRepoName: "team/missing"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("TagsForRepos: expected 2 results, got %d", len(got))
+			}
+			if got[0].Err != nil {
+				t.Fatal(got[0].Err)
+			}
+
+			want := &vcs.TagsResult{OrgRepoName: "team/repo", Complete: true, Tags: wantTestTags(t, got[0].Tags)}
+			if diff := cmp.Diff(want, got[0]); diff != "" {
+				t.Errorf("TagsForRepos: -want, +got: %s", diff)
+			}
+			// Other repos are fetched despite the missing one.
+			if got[1].Err == nil {
+				t.Errorf("TagsForRepos: expected an error for team/missing")
+			}
+		})
+	}
+}
+
+func TestTagsForRepos_ReusesKnownTags(t *testing.T) {
+	hostName, gotRequests := createTestGitServer(t, true)
+	sut := NewGitSCM(hostName, []string{"team/repo.git"}, false)
+
+	first, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "team/repo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first[0].Err != nil {
+		t.Fatal(first[0].Err)
+	}
+	known := make(map[string]*vcs.RepoTag)
+	for _, tag := range first[0].Tags {
+		known[tag.Tag] = tag
+	}
+	*gotRequests = nil
+
+	got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "team/repo", Known: known}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(first, got); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+	// Only the tags are listed: nothing is fetched.
+	want := []string{"/team/repo.git/info/refs", "/team/repo.git/git-upload-pack"}
+	if diff := cmp.Diff(want, *gotRequests); diff != "" {
+		t.Errorf("unexpected requests: -want, +got: %s", diff)
+	}
+}
+
+func TestGoMod(t *testing.T) {
+	hostName, _ := createTestGitServer(t, false)
+	sut := NewGitSCM(hostName, []string{"team/repo.git"}, false)
+
+	got, found, err := sut.GoMod(t.Context(), "team/repo", "tools/cli/v0.1.0", "tools/cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(got) != "module git.somecompany.net/team/cli\n" {
+		t.Errorf("GoMod: unexpected go.mod file %q (found: %v)", got, found)
+	}
+
+	if _, found, err := sut.GoMod(t.Context(), "team/repo", "v1.0.0", "tools/cli"); err != nil || found {
+		t.Errorf("GoMod: expected no go.mod file, got found=%v (err: %v)", found, err)
+	}
+}
+
+func TestZipball(t *testing.T) {
+	hostName, _ := createTestGitServer(t, true)
+	sut := NewGitSCM(hostName, []string{"team/repo.git"}, false)
+
+	got, err := sut.Zipball(t.Context(), "team/repo", "v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(got), int64(len(got)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotNames []string
+	for _, f := range zr.File {
+		gotNames = append(gotNames, f.Name)
+	}
+	wantNames := []string{"repo-v1.1.0/go.mod", "repo-v1.1.0/repo.go", "repo-v1.1.0/tools/cli/go.mod", "repo-v1.1.0/tools/cli/main.go"}
+	if diff := cmp.Diff(wantNames, gotNames); diff != "" {
+		t.Errorf("Zipball: -want, +got: %s", diff)
+	}
+
+	if _, err := sut.Zipball(t.Context(), "team/repo", "v2.0.0"); err == nil {
+		t.Errorf("Zipball: expected an error for a missing tag")
+	}
+}
@@ -0,0 +1,582 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+)
+
+// A handle for reading a directory of bare git repos, ex a mirror, as an SCM.
+// Each org/repo.git directory is a repo, named "org/repo". Tags and go.mod
+// files are read straight from the repos' object stores, so no host is needed.
+type LocalSCM struct {
+	reposDir string
+	// The host the repos are mirrored from, ex "github.mycompany.net", which
+	// the module paths implied by repo names start with.
+	hostName string
+
+	// Which tags to index. See SetRules.
+	rules *rules.Rules
+}
+
+// Creates a new local SCM for the bare repos in reposDir, whose module paths
+// start with hostName unless their go.mod files say otherwise.
+func NewLocalSCM(reposDir, hostName string) *LocalSCM {
+	return &LocalSCM{reposDir: reposDir, hostName: hostName}
+}
+
+// Sets the rules that decide which tags TagsForRepos excludes. A nil *Rules,
+// the default, excludes nothing.
+func (scm *LocalSCM) SetRules(r *rules.Rules) {
+	scm.rules = r
+}
+
+// Opens the given repo. The caller must close it.
+func (scm *LocalSCM) open(orgRepoName string) (*localRepo, error) {
+	org, repo, ok := strings.Cut(orgRepoName, "/")
+	if !ok || !fs.ValidPath(orgRepoName) || strings.Contains(repo, "/") {
+		return nil, fmt.Errorf("expected org/repo format, but got %s", orgRepoName)
+	}
+	return openLocalRepo(filepath.Join(scm.reposDir, org, repo+".git"))
+}
+
+// Retrieves the repos with a go.mod file at their root, or one directory down,
+// at HEAD. Repos are dated by when their tags last changed, and their tags
+// counted, so that repos that haven't changed needn't be re-indexed. complete
+// is false if some repos couldn't be read.
+func (scm *LocalSCM) GoRepos(ctx context.Context) (_ []*vcs.Repo, complete bool, _ error) {
+	orgs, err := os.ReadDir(scm.reposDir)
+	if err != nil {
+		return nil, false, fmt.Errorf("GoRepos: %v", err)
+	}
+
+	var results []*vcs.Repo
+	complete = true
+	for _, org := range orgs {
+		if !org.IsDir() {
+			continue
+		}
+		repos, err := os.ReadDir(filepath.Join(scm.reposDir, org.Name()))
+		if err != nil {
+			return nil, false, fmt.Errorf("GoRepos: %v", err)
+		}
+		for _, r := range repos {
+			name, ok := strings.CutSuffix(r.Name(), ".git")
+			if !ok || !r.IsDir() {
+				continue
+			}
+			orgRepoName := org.Name() + "/" + name
+			repo, err := scm.goRepo(orgRepoName)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("error reading %s: %v. Leaving it out", orgRepoName, err))
+				complete = false
+				continue
+			}
+			if repo != nil {
+				results = append(results, repo)
+			}
+		}
+	}
+	return results, complete, nil
+}
+
+// Returns the given repo if it's a Go repo, else nil.
+func (scm *LocalSCM) goRepo(orgRepoName string) (*vcs.Repo, error) {
+	repo, err := scm.open(orgRepoName)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.close()
+
+	head, err := repo.resolveRef("HEAD")
+	if errors.Is(err, fs.ErrNotExist) {
+		// An empty repo.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isGo, err := hasGoMod(repo, head); err != nil || !isGo {
+		return nil, err
+	}
+	tags, changed, err := repo.tags()
+	if err != nil {
+		return nil, err
+	}
+	return &vcs.Repo{OrgRepoName: orgRepoName, PushedAt: changed, TagCount: len(tags)}, nil
+}
+
+// Whether the commit has a go.mod file at its root or one directory down.
+func hasGoMod(objs objects, commit string) (bool, error) {
+	tree, err := rootTree(objs, commit)
+	if err != nil {
+		return false, err
+	}
+	entries, err := tree.entries()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.name == "go.mod" && (e.mode == modeFile || e.mode == modeExecutable) {
+			return true, nil
+		}
+	}
+	for _, e := range entries {
+		if e.mode != modeTree {
+			continue
+		}
+		if _, found, err := lookup(objs, commit, e.name+"/go.mod"); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// Retrieves the tags of each of the given repos, one by one. Tags that aren't
+// valid module versions, or that the rules exclude, are included with their
+// Rejection set.
+//
+// Every tag is read, so the results are always complete. Tags are dated like
+// GitHub dates them: annotated tags by when they were created, and lightweight
+// tags by their commit.
+func (scm *LocalSCM) TagsForRepos(ctx context.Context, requests []*vcs.TagsRequest) ([]*vcs.TagsResult, error) {
+	var results []*vcs.TagsResult
+	for _, r := range requests {
+		tags, err := scm.tagsForRepo(ctx, r)
+		results = append(results, &vcs.TagsResult{OrgRepoName: r.OrgRepoName, Tags: tags, Complete: err == nil, Err: err})
+	}
+	return results, nil
+}
+
+func (scm *LocalSCM) tagsForRepo(ctx context.Context, r *vcs.TagsRequest) ([]*vcs.RepoTag, error) {
+	repo, err := scm.open(r.OrgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("TagsForRepos: %v", err)
+	}
+	defer repo.close()
+
+	refs, _, err := repo.tags()
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags of %s: %v", r.OrgRepoName, err)
+	}
+	var tags []*vcs.RepoTag
+	commits := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(refs)) {
+		commit, date, err := peel(repo, refs[name])
+		if errors.Is(err, errNotCommit) {
+			// Tags of trees or blobs can't be module versions.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s tag %s: %v", r.OrgRepoName, name, err)
+		}
+		tags = append(tags, &vcs.RepoTag{Tag: name, TagDate: date, TargetSHA: commit})
+		commits[name] = commit
+	}
+
+	_, _, err = vcs.ResolveTags(ctx, r.OrgRepoName, scm.hostName+"/"+r.OrgRepoName, tags, r.Known, scm.rules, func(ctx context.Context, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+		var results []*vcs.GoModFetch
+		for _, f := range files {
+			content, found, err := readGoMod(repo, commits[f.Tag], f.Dir)
+			results = append(results, &vcs.GoModFetch{Content: content, Found: found, Err: err})
+		}
+		return results, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Returns the commit that the given tag points to, from the given repo.
+func tagCommit(repo *localRepo, tag string) (string, error) {
+	refs, _, err := repo.tags()
+	if err != nil {
+		return "", err
+	}
+	oid, ok := refs[tag]
+	if !ok {
+		return "", fmt.Errorf("no tag %s", tag)
+	}
+	commit, _, err := peel(repo, oid)
+	return commit, err
+}
+
+// Retrieves the contents of the go.mod file in the given repo subdirectory at
+// the given tag. found is false if there is no such go.mod file.
+func (scm *LocalSCM) GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error) {
+	repo, err := scm.open(orgRepoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("GoMod: %v", err)
+	}
+	defer repo.close()
+
+	commit, err := tagCommit(repo, tag)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading %s tag %s: %v", orgRepoName, tag, err)
+	}
+	return readGoMod(repo, commit, dir)
+}
+
+// Builds a zip archive of the repo contents at the given tag. All files in the
+// archive are inside a single top-level directory.
+func (scm *LocalSCM) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	repo, err := scm.open(orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("Zipball: %v", err)
+	}
+	defer repo.close()
+
+	commit, err := tagCommit(repo, tag)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s tag %s: %v", orgRepoName, tag, err)
+	}
+	return zipball(repo, orgRepoName, tag, commit)
+}
+
+// Returned by peel for tags that don't point to a commit.
+var errNotCommit = errors.New("not a commit")
+
+// Follows the given object, through any tag objects, to a commit. Returns the
+// date of the given object: when it was tagged if it's a tag object, else when
+// the commit was committed.
+func peel(objs objects, oid string) (commit string, date time.Time, _ error) {
+	for depth := 0; ; depth++ {
+		o, err := objs.read(oid)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if o.typ != objTag && o.typ != objCommit {
+			return "", time.Time{}, errNotCommit
+		}
+		if depth == 0 {
+			if date, err = o.date(); err != nil {
+				return "", time.Time{}, err
+			}
+		}
+		if o.typ == objCommit {
+			return oid, date, nil
+		}
+		// Tags can point to tags, but not forever.
+		if depth == 10 {
+			return "", time.Time{}, fmt.Errorf("tag %s is nested too deeply", oid)
+		}
+		target, ok := o.header("object")
+		if !ok {
+			return "", time.Time{}, fmt.Errorf("tag %s has no object", oid)
+		}
+		oid = target
+	}
+}
+
+// A bare repo on disk.
+type localRepo struct {
+	dir   string
+	packs []*pack
+}
+
+// A packfile and its index.
+type pack struct {
+	file *os.File
+	// The index, in version 2 format. See
+	// https://git-scm.com/docs/gitformat-pack#_version_2_pack_idx_files_support_packs_larger_than_4_gib_and.
+	idx   []byte
+	count int
+}
+
+// Opens the bare repo at dir. The caller must close it.
+func openLocalRepo(dir string) (*localRepo, error) {
+	if _, err := os.Stat(filepath.Join(dir, "objects")); err != nil {
+		return nil, fmt.Errorf("%s isn't a bare git repo: %v", dir, err)
+	}
+	repo := &localRepo{dir: dir}
+	idxPaths, err := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idxPath := range idxPaths {
+		p, err := openPack(idxPath)
+		if err != nil {
+			repo.close()
+			return nil, err
+		}
+		repo.packs = append(repo.packs, p)
+	}
+	return repo, nil
+}
+
+func (r *localRepo) close() {
+	for _, p := range r.packs {
+		p.file.Close()
+	}
+}
+
+// Opens the packfile of the given index.
+func openPack(idxPath string) (*pack, error) {
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 8+256*4 || string(idx[:4]) != "\xfftOc" || binary.BigEndian.Uint32(idx[4:8]) != 2 {
+		return nil, fmt.Errorf("%s isn't a version 2 pack index", idxPath)
+	}
+	count := int(binary.BigEndian.Uint32(idx[8+255*4:]))
+	if len(idx) < 8+256*4+count*(sha1.Size+4+4)+2*sha1.Size {
+		return nil, fmt.Errorf("%s is truncated", idxPath)
+	}
+	file, err := os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	return &pack{file: file, idx: idx, count: count}, nil
+}
+
+// Returns the offset of the given object in the packfile.
+func (p *pack) find(oid []byte) (int64, bool) {
+	fanout := p.idx[8:]
+	lo := 0
+	if oid[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(fanout[(int(oid[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(fanout[int(oid[0])*4:]))
+	names := p.idx[8+256*4:]
+	// Object IDs are sorted, so binary search them.
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch bytes.Compare(names[mid*sha1.Size:(mid+1)*sha1.Size], oid) {
+		case 0:
+			offsets := names[p.count*(sha1.Size+4):]
+			offset := int64(binary.BigEndian.Uint32(offsets[mid*4:]))
+			if offset&0x80000000 != 0 {
+				// The offset is in the table of large offsets.
+				large := offsets[p.count*4:]
+				offset = int64(binary.BigEndian.Uint64(large[(offset&0x7fffffff)*8:]))
+			}
+			return offset, true
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// Reads the given object, from a packfile or a loose object file.
+func (r *localRepo) read(oid string) (*object, error) {
+	raw, err := hex.DecodeString(oid)
+	if err != nil || len(raw) != sha1.Size {
+		return nil, fmt.Errorf("invalid object ID %q", oid)
+	}
+	for _, p := range r.packs {
+		if offset, ok := p.find(raw); ok {
+			return r.readPacked(p, offset)
+		}
+	}
+
+	compressed, err := os.ReadFile(filepath.Join(r.dir, "objects", oid[:2], oid[2:]))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no object %s", oid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s: %v", oid, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s: %v", oid, err)
+	}
+	// Ex "blob 12\x00<contents>".
+	header, content, _ := bytes.Cut(data, []byte{0})
+	typeName, size, _ := strings.Cut(string(header), " ")
+	for typ, name := range objTypeNames {
+		if name == typeName && size == strconv.Itoa(len(content)) {
+			return &object{typ: typ, data: content}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid object %s", oid)
+}
+
+// Reads the object at the given offset of the packfile.
+func (r *localRepo) readPacked(p *pack, offset int64) (*object, error) {
+	br := bufio.NewReader(io.NewSectionReader(p.file, offset, 1<<62))
+	typ, size, err := readObjectHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var base *object
+	switch typ {
+	case objOfsDelta:
+		distance, err := readOffset(br)
+		if err != nil {
+			return nil, err
+		}
+		if base, err = r.readPacked(p, offset-int64(distance)); err != nil {
+			return nil, err
+		}
+	case objRefDelta:
+		var oid [sha1.Size]byte
+		if _, err := io.ReadFull(br, oid[:]); err != nil {
+			return nil, err
+		}
+		if base, err = r.read(hex.EncodeToString(oid[:])); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := inflate(br, size)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return &object{typ: typ, data: data}, nil
+	}
+	if data, err = applyDelta(base.data, data); err != nil {
+		return nil, err
+	}
+	return &object{typ: base.typ, data: data}, nil
+}
+
+// Reads the refs in the packed-refs file, keyed by name, ex "refs/tags/v1.0.0".
+func (r *localRepo) packedRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(r.dir, "packed-refs"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for line := range strings.Lines(string(data)) {
+		// Skip the header, and the commits that the preceding tags peel to.
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		if oid, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			refs[name] = oid
+		}
+	}
+	return refs, nil
+}
+
+// Returns the object ID that the given ref, ex "HEAD" or "refs/heads/main",
+// points to, following symbolic refs. Returns an fs.ErrNotExist error if the
+// ref doesn't exist.
+func (r *localRepo) resolveRef(name string) (string, error) {
+	for range 10 {
+		data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(name)))
+		if errors.Is(err, fs.ErrNotExist) {
+			refs, err := r.packedRefs()
+			if err != nil {
+				return "", err
+			}
+			oid, ok := refs[name]
+			if !ok {
+				return "", fmt.Errorf("ref %s: %w", name, fs.ErrNotExist)
+			}
+			return oid, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(data))
+		target, ok := strings.CutPrefix(value, "ref: ")
+		if !ok {
+			return value, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("symbolic ref %s is nested too deeply", name)
+}
+
+// Returns the object ID of each tag, keyed by tag name, ex "v1.0.0", and when
+// the tags last changed: when a tag was last created, updated or deleted.
+func (r *localRepo) tags() (_ map[string]string, changed time.Time, _ error) {
+	packed, err := r.packedRefs()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	tags := make(map[string]string)
+	for name, oid := range packed {
+		if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok {
+			tags[tag] = oid
+		}
+	}
+	if info, err := os.Stat(filepath.Join(r.dir, "packed-refs")); err == nil {
+		changed = info.ModTime()
+	}
+
+	// Loose refs take precedence over packed ones. Directories are modified
+	// when tags in them are deleted.
+	tagsDir := filepath.Join(r.dir, "refs", "tags")
+	err = filepath.WalkDir(tagsDir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == tagsDir {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(changed) {
+			changed = info.ModTime()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(tagsDir, p)
+		if err != nil {
+			return err
+		}
+		tags[filepath.ToSlash(rel)] = strings.TrimSpace(string(data))
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return tags, changed.UTC(), nil
+}
+
+// Reads the go.mod file in the given directory of the commit. found is false if
+// there is no such go.mod file.
+func readGoMod(objs objects, commit, dir string) (_ []byte, found bool, _ error) {
+	oid, found, err := lookup(objs, commit, path.Join(dir, "go.mod"))
+	if err != nil || !found {
+		return nil, false, err
+	}
+	blob, err := get(objs, oid, objBlob)
+	if err != nil {
+		return nil, false, err
+	}
+	return blob.data, true, nil
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Creates a directory of bare repos, with the repo made by createTestRepo, a
+// repo with no go.mod file and an empty repo. If packed is true, the objects
+// and refs of team/repo are packed, like git gc leaves them. Returns the
+// directory.
+func createTestReposDir(t *testing.T, packed bool) string {
+	t.Helper()
+	root := t.TempDir()
+	bare := createTestRepo(t, root)
+	if packed {
+		runGit(t, bare, time.Now(), "gc", "-q")
+	}
+
+	jan1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	notGo := filepath.Join(root, "team", "notgo.git")
+	work := t.TempDir()
+	runGit(t, root, jan1, "init", "-q", "--bare", notGo)
+	runGit(t, root, jan1, "init", "-q", work)
+	commitFiles(t, work, jan1, map[string]string{"README.md": "Not Go.\n"})
+	runGit(t, work, jan1, "tag", "v1.0.0")
+	runGit(t, work, jan1, "push", "-q", notGo, "HEAD:refs/heads/main", "--tags")
+	runGit(t, notGo, jan1, "symbolic-ref", "HEAD", "refs/heads/main")
+
+	runGit(t, root, jan1, "init", "-q", "--bare", filepath.Join(root, "other", "empty.git"))
+	return root
+}
+
+func TestLocalGoRepos(t *testing.T) {
+	sut := NewLocalSCM(createTestReposDir(t, false), "git.somecompany.net")
+
+	got, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+	if len(got) != 1 {
+		t.Fatalf("GoRepos: expected 1 repo, got %d", len(got))
+	}
+	if got[0].PushedAt.IsZero() {
+		t.Errorf("GoRepos: expected PushedAt to be set")
+	}
+	want := &vcs.Repo{OrgRepoName: "team/repo", PushedAt: got[0].PushedAt, TagCount: 5}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+}
+
+func TestLocalTagsForRepos(t *testing.T) {
+	for _, packed := range []bool{false, true} {
+		t.Run(map[bool]string{false: "loose", true: "packed"}[packed], func(t *testing.T) {
+			sut := NewLocalSCM(createTestReposDir(t, packed), "git.somecompany.net")
+			sut.SetRules(testRules(t))
+
+			got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "team/repo"}, {OrgRepoName: "team/missing"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("TagsForRepos: expected 2 results, got %d", len(got))
+			}
+			if got[0].Err != nil {
+				t.Fatal(got[0].Err)
+			}
+
+			want := &vcs.TagsResult{OrgRepoName: "team/repo", Complete: true, Tags: wantTestTags(t, got[0].Tags)}
+			if diff := cmp.Diff(want, got[0]); diff != "" {
+				t.Errorf("TagsForRepos: -want, +got: %s", diff)
+			}
+			// Other repos are read despite the missing one.
+			if got[1].Err == nil {
+				t.Errorf("TagsForRepos: expected an error for team/missing")
+			}
+		})
+	}
+}
+
+func TestLocalGoMod(t *testing.T) {
+	sut := NewLocalSCM(createTestReposDir(t, true), "git.somecompany.net")
+
+	got, found, err := sut.GoMod(t.Context(), "team/repo", "tools/cli/v0.1.0", "tools/cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(got) != "module git.somecompany.net/team/cli\n" {
+		t.Errorf("GoMod: unexpected go.mod file %q (found: %v)", got, found)
+	}
+
+	if _, found, err := sut.GoMod(t.Context(), "team/repo", "v1.0.0", "tools/cli"); err != nil || found {
+		t.Errorf("GoMod: expected no go.mod file, got found=%v (err: %v)", found, err)
+	}
+	if _, _, err := sut.GoMod(t.Context(), "../team/repo", "v1.0.0", ""); err == nil {
+		t.Errorf("GoMod: expected an error for a repo outside the directory")
+	}
+}
+
+func TestLocalZipball(t *testing.T) {
+	sut := NewLocalSCM(createTestReposDir(t, false), "git.somecompany.net")
+
+	got, err := sut.Zipball(t.Context(), "team/repo", "v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(got), int64(len(got)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotNames []string
+	for _, f := range zr.File {
+		gotNames = append(gotNames, f.Name)
+	}
+	wantNames := []string{"repo-v1.1.0/go.mod", "repo-v1.1.0/repo.go", "repo-v1.1.0/tools/cli/go.mod", "repo-v1.1.0/tools/cli/main.go"}
+	if diff := cmp.Diff(wantNames, gotNames); diff != "" {
+		t.Errorf("Zipball: -want, +got: %s", diff)
+	}
+
+	if _, err := sut.Zipball(t.Context(), "team/repo", "v2.0.0"); err == nil {
+		t.Errorf("Zipball: expected an error for a missing tag")
+	}
+}
@@ -0,0 +1,406 @@
+package git
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Git object types, as numbered in packfiles.
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var objTypeNames = map[int]string{objCommit: "commit", objTree: "tree", objBlob: "blob", objTag: "tag"}
+
+// A git object.
+type object struct {
+	typ  int
+	data []byte
+}
+
+// Fetched objects, keyed by their hex object ID.
+type objectStore map[string]*object
+
+// Adds the objects of the given packfile to the store. See
+// https://git-scm.com/docs/gitformat-pack.
+func (s objectStore) addPack(pack []byte) error {
+	if len(pack) < 12+sha1.Size || string(pack[:4]) != "PACK" {
+		return errors.New("not a packfile")
+	}
+	if version := binary.BigEndian.Uint32(pack[4:8]); version != 2 && version != 3 {
+		return fmt.Errorf("unsupported packfile version %d", version)
+	}
+	count := binary.BigEndian.Uint32(pack[8:12])
+
+	// Objects by their offset in the packfile, for offset deltas.
+	byOffset := make(map[int]*object)
+	r := bytes.NewReader(pack[:len(pack)-sha1.Size])
+	// Skip the header.
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return err
+	}
+	for range count {
+		offset := int(r.Size()) - r.Len()
+		typ, size, err := readObjectHeader(r)
+		if err != nil {
+			return err
+		}
+
+		var base *object
+		switch typ {
+		case objOfsDelta:
+			distance, err := readOffset(r)
+			if err != nil {
+				return err
+			}
+			if base = byOffset[offset-distance]; base == nil {
+				return fmt.Errorf("no delta base at offset %d", offset-distance)
+			}
+		case objRefDelta:
+			var oid [sha1.Size]byte
+			if _, err := io.ReadFull(r, oid[:]); err != nil {
+				return err
+			}
+			// Packfiles aren't thin unless asked to be, so the base comes
+			// first.
+			if base = s[hex.EncodeToString(oid[:])]; base == nil {
+				return fmt.Errorf("no delta base %x", oid)
+			}
+		}
+
+		data, err := inflate(r, size)
+		if err != nil {
+			return err
+		}
+		obj := &object{typ: typ, data: data}
+		if base != nil {
+			if obj.data, err = applyDelta(base.data, data); err != nil {
+				return err
+			}
+			obj.typ = base.typ
+		}
+		if objTypeNames[obj.typ] == "" {
+			return fmt.Errorf("unexpected object type %d", obj.typ)
+		}
+		byOffset[offset] = obj
+		s[obj.oid()] = obj
+	}
+	return nil
+}
+
+// Returns the object's hex object ID.
+func (o *object) oid() string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objTypeNames[o.typ], len(o.data))
+	h.Write(o.data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Reads the type and inflated size of a packed object.
+func readObjectHeader(r io.ByteReader) (typ int, size int, _ error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ = int(b>>4) & 7
+	size = int(b & 0x0f)
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		size |= int(b&0x7f) << shift
+	}
+	return typ, size, nil
+}
+
+// Reads the distance back to the base of an offset delta.
+func readOffset(r io.ByteReader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	offset := int(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		offset = ((offset + 1) << 7) | int(b&0x7f)
+	}
+	return offset, nil
+}
+
+// Inflates the zlib stream at the reader's position. Readers that are
+// io.ByteReaders, like bytes.Reader, are left just past the stream: the
+// inflater doesn't read ahead of them.
+func inflate(r io.Reader, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("error inflating object: %v", err)
+	}
+	// Reads the checksum.
+	n, err := zr.Read(make([]byte, 1))
+	if n != 0 {
+		return nil, fmt.Errorf("object larger than its size %d", size)
+	}
+	if err != io.EOF {
+		return nil, fmt.Errorf("error inflating object: %v", err)
+	}
+	return data, nil
+}
+
+// Applies a delta to its base object's data.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta base size mismatch: %v", err)
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, size)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		if op&0x80 == 0 {
+			// Insert the next op bytes.
+			if op == 0 {
+				return nil, errors.New("invalid delta instruction")
+			}
+			insert := make([]byte, op)
+			if _, err := io.ReadFull(r, insert); err != nil {
+				return nil, err
+			}
+			result = append(result, insert...)
+			continue
+		}
+
+		// Copy from the base. The low bits say which offset and size bytes
+		// follow.
+		var offset, n int
+		for i := range 7 {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if i < 4 {
+				offset |= int(b) << (8 * i)
+			} else {
+				n |= int(b) << (8 * (i - 4))
+			}
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+		if offset+n > len(base) {
+			return nil, errors.New("delta copies past the end of its base")
+		}
+		result = append(result, base[offset:offset+n]...)
+	}
+	if uint64(len(result)) != size {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return result, nil
+}
+
+// A source of git objects: fetched ones, or a repo's object store.
+type objects interface {
+	// Returns the given object, or an error if there's no such object.
+	read(oid string) (*object, error)
+}
+
+// Returns the object of the given type.
+func get(objs objects, oid string, typ int) (*object, error) {
+	o, err := objs.read(oid)
+	if err != nil {
+		return nil, err
+	}
+	if o.typ != typ {
+		return nil, fmt.Errorf("object %s is a %s, not a %s", oid, objTypeNames[o.typ], objTypeNames[typ])
+	}
+	return o, nil
+}
+
+// Returns the given object, or an error if it wasn't fetched.
+func (s objectStore) read(oid string) (*object, error) {
+	o, ok := s[oid]
+	if !ok {
+		return nil, fmt.Errorf("object %s wasn't fetched", oid)
+	}
+	return o, nil
+}
+
+// Returns the value of the given header of a commit or tag object, ex the
+// "tree" of a commit.
+func (o *object) header(name string) (string, bool) {
+	for line := range strings.Lines(string(o.data)) {
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			// The end of the headers.
+			break
+		}
+		if value, ok := strings.CutPrefix(line, name+" "); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Returns the date of a commit or tag object: when it was committed or tagged.
+func (o *object) date() (time.Time, error) {
+	name := "committer"
+	if o.typ == objTag {
+		name = "tagger"
+	}
+	value, ok := o.header(name)
+	if !ok {
+		return time.Time{}, fmt.Errorf("no %s in %s", name, objTypeNames[o.typ])
+	}
+	// Ex "A U Thor <author@example.com> 1735689600 +0000".
+	fields := strings.Fields(value[strings.LastIndex(value, ">")+1:])
+	if len(fields) != 2 {
+		return time.Time{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// An entry of a tree object.
+type treeEntry struct {
+	mode string
+	name string
+	oid  string
+}
+
+// Tree entry modes.
+const (
+	modeTree       = "40000"
+	modeFile       = "100644"
+	modeExecutable = "100755"
+)
+
+// Parses a tree object's entries.
+func (o *object) entries() ([]*treeEntry, error) {
+	var entries []*treeEntry
+	for data := o.data; len(data) > 0; {
+		// Ex "100644 go.mod\x00<20 byte object ID>".
+		modeName, rest, ok := bytes.Cut(data, []byte{0})
+		mode, name, hasName := strings.Cut(string(modeName), " ")
+		if !ok || !hasName || len(rest) < sha1.Size {
+			return nil, errors.New("invalid tree")
+		}
+		entries = append(entries, &treeEntry{mode: mode, name: name, oid: hex.EncodeToString(rest[:sha1.Size])})
+		data = rest[sha1.Size:]
+	}
+	return entries, nil
+}
+
+// Returns the root tree of the given commit.
+func rootTree(objs objects, commit string) (*object, error) {
+	c, err := get(objs, commit, objCommit)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := c.header("tree")
+	if !ok {
+		return nil, fmt.Errorf("commit %s has no tree", commit)
+	}
+	return get(objs, tree, objTree)
+}
+
+// Returns the object ID of the file at the given path in the given commit.
+// found is false if there is no such file.
+func lookup(objs objects, commit, filePath string) (oid string, found bool, _ error) {
+	tree, err := rootTree(objs, commit)
+	if err != nil {
+		return "", false, err
+	}
+	names := strings.Split(filePath, "/")
+	for i, name := range names {
+		entries, err := tree.entries()
+		if err != nil {
+			return "", false, err
+		}
+		j := slices.IndexFunc(entries, func(e *treeEntry) bool { return e.name == name })
+		if j < 0 {
+			return "", false, nil
+		}
+		e := entries[j]
+		if i == len(names)-1 {
+			return e.oid, e.mode == modeFile || e.mode == modeExecutable, nil
+		}
+		if e.mode != modeTree {
+			return "", false, nil
+		}
+		if tree, err = get(objs, e.oid, objTree); err != nil {
+			return "", false, err
+		}
+	}
+	return "", false, nil
+}
+
+// Adds the files of the given tree to the zip, under dir.
+func addToZip(objs objects, zw *zip.Writer, tree *object, dir string) error {
+	entries, err := tree.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := dir + "/" + e.name
+		switch e.mode {
+		case modeTree:
+			subtree, err := get(objs, e.oid, objTree)
+			if err != nil {
+				return err
+			}
+			if err := addToZip(objs, zw, subtree, name); err != nil {
+				return err
+			}
+		case modeFile, modeExecutable:
+			blob, err := get(objs, e.oid, objBlob)
+			if err != nil {
+				return err
+			}
+			header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+			header.SetMode(0o644)
+			if e.mode == modeExecutable {
+				header.SetMode(0o755)
+			}
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(blob.data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	delta := []byte{
+		// The base and result sizes.
+		11, 17,
+		// Copy 6 bytes from offset 0.
+		0x90, 6,
+		// Insert 6 bytes.
+		6, 't', 'h', 'e', 'r', 'e', ' ',
+		// Copy 5 bytes from offset 6.
+		0x91, 6, 5,
+	}
+
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello there world" {
+		t.Errorf("applyDelta: got %q", got)
+	}
+
+	if _, err := applyDelta([]byte("hello"), delta); err == nil {
+		t.Errorf("applyDelta: expected an error for a base of the wrong size")
+	}
+}
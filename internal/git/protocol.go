@@ -0,0 +1,268 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The special pkt-lines of git protocol v2. See
+// https://git-scm.com/docs/gitprotocol-v2.
+const (
+	flushPkt = "0000"
+	delimPkt = "0001"
+)
+
+// Returned by readPkt for flush and delim pkt-lines, whose contents are nil.
+var (
+	errFlush = errors.New("flush-pkt")
+	errDelim = errors.New("delim-pkt")
+)
+
+// Appends a pkt-line holding s to buf.
+func writePkt(buf *bytes.Buffer, s string) {
+	fmt.Fprintf(buf, "%04x%s", len(s)+4, s)
+}
+
+// Reads the next pkt-line. Returns errFlush or errDelim for flush and delim
+// pkt-lines.
+func readPkt(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("error reading pkt-line length: %w", err)
+	}
+	n, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pkt-line length %q", header)
+	}
+	switch n {
+	case 0:
+		return nil, errFlush
+	case 1:
+		return nil, errDelim
+	case 2, 3:
+		return nil, fmt.Errorf("unexpected pkt-line length %d", n)
+	}
+	data := make([]byte, n-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading pkt-line: %w", err)
+	}
+	return data, nil
+}
+
+// Reads pkt-lines as text lines up to the next flush or delim pkt-line, which
+// is returned as errFlush or errDelim.
+func readPktLines(r io.Reader) ([]string, error) {
+	var lines []string
+	for {
+		data, err := readPkt(r)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, strings.TrimSuffix(string(data), "\n"))
+	}
+}
+
+// The git protocol v2 capabilities that a repo's server advertises, ex
+// "fetch" -> "shallow filter".
+type capabilities map[string]string
+
+// Whether the fetch command supports the given feature, ex "filter".
+func (c capabilities) fetchSupports(feature string) bool {
+	for f := range strings.FieldsSeq(c["fetch"]) {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes a smart HTTP request to the git server. Returns an error unless the
+// response is a 200 of the given content type. The caller must close the
+// response body.
+func (scm *GitSCM) do(ctx context.Context, method, url, contentType string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error building git request: %v", err)
+	}
+	request.Header.Set("Git-Protocol", "version=2")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	if got := resp.Header.Get("Content-Type"); got != contentType {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected content type %q from %s: is it a smart HTTP git server?", got, url)
+	}
+	return resp, nil
+}
+
+// Retrieves the capabilities of the repo's server, which must speak git
+// protocol v2.
+func (scm *GitSCM) capabilities(ctx context.Context, repoURL string) (capabilities, error) {
+	url := repoURL + "/info/refs?service=git-upload-pack"
+	resp, err := scm.do(ctx, http.MethodGet, url, "application/x-git-upload-pack-advertisement", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	lines, err := readPktLines(resp.Body)
+	if err != errFlush {
+		return nil, fmt.Errorf("error reading advertisement from %s: %v", url, err)
+	}
+	// Some servers start with a "# service=git-upload-pack" section, as they do
+	// for protocol v0.
+	if len(lines) == 1 && lines[0] == "# service=git-upload-pack" {
+		if lines, err = readPktLines(resp.Body); err != errFlush {
+			return nil, fmt.Errorf("error reading advertisement from %s: %v", url, err)
+		}
+	}
+	if len(lines) == 0 || lines[0] != "version 2" {
+		return nil, fmt.Errorf("%s doesn't speak git protocol v2", url)
+	}
+	caps := make(capabilities)
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, "=")
+		caps[key] = value
+	}
+	return caps, nil
+}
+
+// A tag listed by lsTags.
+type ref struct {
+	name string
+	// The object the tag points to: a tag object for annotated tags, else a
+	// commit.
+	oid string
+	// The commit the tag points to, through any tag objects.
+	commit string
+}
+
+// Lists the repo's tags whose name starts with prefix with the ls-refs command.
+func (scm *GitSCM) lsTags(ctx context.Context, repoURL, prefix string) ([]*ref, error) {
+	var request bytes.Buffer
+	writePkt(&request, "command=ls-refs\n")
+	request.WriteString(delimPkt)
+	writePkt(&request, "peel\n")
+	writePkt(&request, "ref-prefix refs/tags/"+prefix+"\n")
+	request.WriteString(flushPkt)
+
+	resp, err := scm.do(ctx, http.MethodPost, repoURL+"/git-upload-pack", "application/x-git-upload-pack-result", request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	lines, err := readPktLines(resp.Body)
+	if err != errFlush {
+		return nil, fmt.Errorf("error reading ls-refs response from %s: %v", repoURL, err)
+	}
+	var refs []*ref
+	for _, line := range lines {
+		// Ex "<oid> refs/tags/v1.0.0 peeled:<oid>".
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("unexpected ls-refs line from %s: %q", repoURL, line)
+		}
+		r := &ref{name: strings.TrimPrefix(fields[1], "refs/tags/"), oid: fields[0], commit: fields[0]}
+		for _, attr := range fields[2:] {
+			if peeled, ok := strings.CutPrefix(attr, "peeled:"); ok {
+				r.commit = peeled
+			}
+		}
+		refs = append(refs, r)
+	}
+	return refs, nil
+}
+
+// Options of a fetch.
+type fetchOptions struct {
+	// Fetch only the wanted commits, not their ancestors.
+	shallow bool
+	// A filter, ex "blob:none", to leave objects out. Only used if the server
+	// supports filters.
+	filter string
+}
+
+// Fetches the wanted objects, and the objects they reference, with the fetch
+// command, adding them to store.
+func (scm *GitSCM) fetch(ctx context.Context, repoURL string, caps capabilities, wants []string, opts fetchOptions, store objectStore) error {
+	var request bytes.Buffer
+	writePkt(&request, "command=fetch\n")
+	request.WriteString(delimPkt)
+	for _, oid := range wants {
+		writePkt(&request, "want "+oid+"\n")
+	}
+	if opts.shallow {
+		writePkt(&request, "deepen 1\n")
+	}
+	if opts.filter != "" && caps.fetchSupports("filter") {
+		writePkt(&request, "filter "+opts.filter+"\n")
+	}
+	writePkt(&request, "ofs-delta\n")
+	writePkt(&request, "no-progress\n")
+	writePkt(&request, "done\n")
+	request.WriteString(flushPkt)
+
+	resp, err := scm.do(ctx, http.MethodPost, repoURL+"/git-upload-pack", "application/x-git-upload-pack-result", request.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Skip sections before the packfile, ex "shallow-info".
+	for {
+		header, err := readPkt(resp.Body)
+		if err == errFlush {
+			return fmt.Errorf("no packfile in fetch response from %s", repoURL)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading fetch response from %s: %v", repoURL, err)
+		}
+		if string(header) == "packfile\n" {
+			break
+		}
+		if _, err := readPktLines(resp.Body); err != errDelim {
+			return fmt.Errorf("no packfile in fetch response from %s: %v", repoURL, err)
+		}
+	}
+
+	// The packfile is multiplexed with progress and error messages.
+	var pack bytes.Buffer
+	for {
+		data, err := readPkt(resp.Body)
+		if err == errFlush {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading packfile from %s: %v", repoURL, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case 1:
+			pack.Write(data[1:])
+		case 3:
+			return fmt.Errorf("error fetching from %s: %s", repoURL, bytes.TrimSpace(data[1:]))
+		}
+	}
+	if err := store.addPack(pack.Bytes()); err != nil {
+		return fmt.Errorf("error reading packfile from %s: %v", repoURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// A GitHub App, which the indexer can authenticate as instead of with a personal
+// token. See
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/about-authentication-with-a-github-app.
+type App struct {
+	id  int64
+	key *rsa.PrivateKey
+	// Something like "https://github.mycompany.net".
+	baseURL string
+}
+
+// Creates a new GitHub App with the given ID and PEM encoded private key,
+// installed on the given GitHub host. useHTTPS should be true outside of tests.
+func NewApp(appID int64, privateKeyPEM []byte, githubHostName string, useHTTPS bool) (*App, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("NewApp: no PEM data found in the private key")
+	}
+	// GitHub hands out PKCS#1 keys, but PKCS#8 ones work too.
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("NewApp: error parsing private key: %v", err)
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("NewApp: private key is a %T, not an RSA key", parsed)
+		}
+	}
+
+	scheme := "http"
+	if useHTTPS {
+		scheme = "https"
+	}
+	return &App{id: appID, key: key, baseURL: fmt.Sprintf("%s://%s", scheme, githubHostName)}, nil
+}
+
+func (a *App) apiURL() string {
+	return a.baseURL + "/api/v3"
+}
+
+func (a *App) graphqlURL() string {
+	return a.baseURL + "/api/graphql"
+}
+
+// Returns a JWT that authenticates as the app itself, for listing its
+// installations and minting their tokens. See
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app.
+func (a *App) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		// Backdated to allow for clock drift.
+		"iat": now.Add(-time.Minute).Unix(),
+		// GitHub rejects JWTs that expire more than 10 minutes out.
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// An installation of the app on an org or user account.
+type Installation struct {
+	ID int64
+
+	// The login of the org or user that the app is installed on.
+	Account string
+
+	// Whether the account is a user rather than an org.
+	User bool
+}
+
+// Matches the next page URL in a Link header.
+var nextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Lists the app's installations.
+func (a *App) Installations(ctx context.Context) ([]*Installation, error) {
+	var installations []*Installation
+	for url := a.apiURL() + "/app/installations?per_page=100"; url != ""; {
+		var page []struct {
+			ID      int64 `json:"id"`
+			Account struct {
+				Login string `json:"login"`
+				Type  string `json:"type"`
+			} `json:"account"`
+		}
+		header, err := a.do(ctx, http.MethodGet, url, http.StatusOK, &page)
+		if err != nil {
+			return nil, fmt.Errorf("Installations: %v", err)
+		}
+		for _, i := range page {
+			installations = append(installations, &Installation{ID: i.ID, Account: i.Account.Login, User: i.Account.Type == "User"})
+		}
+
+		url = ""
+		if m := nextLinkRegexp.FindStringSubmatch(header.Get("Link")); m != nil {
+			url = m[1]
+		}
+	}
+	return installations, nil
+}
+
+// Returns a token source for the given installation. Tokens are minted as
+// needed, and reused until shortly before they expire, an hour after they're
+// minted.
+func (a *App) InstallationTokenSource(installationID int64) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &installationTokenSource{app: a, installationID: installationID})
+}
+
+type installationTokenSource struct {
+	app            *App
+	installationID int64
+}
+
+// How long minting an installation token may take.
+const installationTokenTimeout = 30 * time.Second
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	// oauth2.TokenSource doesn't take a context.
+	ctx, cancel := context.WithTimeout(context.Background(), installationTokenTimeout)
+	defer cancel()
+
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", s.app.apiURL(), s.installationID)
+	if _, err := s.app.do(ctx, http.MethodPost, url, http.StatusCreated, &token); err != nil {
+		return nil, fmt.Errorf("error minting token for installation %d: %v", s.installationID, err)
+	}
+	return &oauth2.Token{AccessToken: token.Token, TokenType: "token", Expiry: token.ExpiresAt}, nil
+}
+
+// Makes a request to the GitHub REST API authenticated as the app, and decodes
+// the JSON response into out. Returns an error unless the response has the
+// given status code.
+func (a *App) do(ctx context.Context, method, url string, wantStatusCode int, out any) (http.Header, error) {
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building request: %v", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := httpRateLimitError(resp); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %v", url, err)
+	}
+	if resp.StatusCode != wantStatusCode {
+		return nil, fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, url, bytes.TrimSpace(body))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response from %s: %v", url, err)
+	}
+	return resp.Header, nil
+}
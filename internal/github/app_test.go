@@ -0,0 +1,220 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testAppID = 42
+
+func generateAppKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// Returns an error unless the given Authorization header holds a JWT for the
+// test app, signed with the given key, that's valid now.
+func verifyAppJWT(key *rsa.PublicKey, authorization string) error {
+	jwt, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return fmt.Errorf("not a bearer token: %q", authorization)
+	}
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected 3 JWT parts, got %d", len(parts))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		IAT int64 `json:"iat"`
+		EXP int64 `json:"exp"`
+		ISS int64 `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if claims.ISS != testAppID || claims.IAT > now || claims.EXP < now || claims.EXP-claims.IAT > 10*60 {
+		return fmt.Errorf("invalid claims: %+v", claims)
+	}
+	return nil
+}
+
+// Stands up a GitHub host with the test app installed on someorg and someuser.
+// Records the org and token of each GraphQL query, and counts the tokens
+// minted.
+type fakeAppHost struct {
+	t      *testing.T
+	key    *rsa.PublicKey
+	server *httptest.Server
+
+	mu         sync.Mutex
+	minted     map[string]int
+	gotQueries []string
+}
+
+func newFakeAppHost(t *testing.T, key *rsa.PublicKey) *fakeAppHost {
+	h := &fakeAppHost{t: t, key: key, minted: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/app/installations", h.handleInstallations)
+	mux.HandleFunc("POST /api/v3/app/installations/{id}/access_tokens", h.handleAccessTokens)
+	mux.HandleFunc("POST /api/graphql", h.handleGraphQL)
+	h.server = httptest.NewServer(mux)
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *fakeAppHost) hostName() string {
+	return strings.TrimPrefix(h.server.URL, "http://")
+}
+
+func (h *fakeAppHost) handleInstallations(w http.ResponseWriter, r *http.Request) {
+	if err := verifyAppJWT(h.key, r.Header.Get("Authorization")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// One installation per page, to check that pages are followed.
+	if r.URL.Query().Get("page") == "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/app/installations?per_page=100&page=2>; rel="next", <%[1]s/api/v3/app/installations?per_page=100&page=2>; rel="last"`, h.server.URL))
+		fmt.Fprint(w, `[{"id": 1, "account": {"login": "someorg", "type": "Organization"}}]`)
+		return
+	}
+	fmt.Fprint(w, `[{"id": 2, "account": {"login": "someuser", "type": "User"}}]`)
+}
+
+func (h *fakeAppHost) handleAccessTokens(w http.ResponseWriter, r *http.Request) {
+	if err := verifyAppJWT(h.key, r.Header.Get("Authorization")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	token := "token-" + r.PathValue("id")
+	h.mu.Lock()
+	h.minted[token]++
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token": %q, "expires_at": %q}`, token, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+}
+
+func (h *fakeAppHost) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.t.Error(err)
+	}
+	h.mu.Lock()
+	if search, ok := body.Variables["query"]; ok {
+		h.gotQueries = append(h.gotQueries, fmt.Sprintf("%s: search %s", r.Header.Get("Authorization"), strings.Fields(search)[0]))
+	} else {
+		h.gotQueries = append(h.gotQueries, fmt.Sprintf("%s: tags of %s", r.Header.Get("Authorization"), body.Variables["repoOrg"]))
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if search, ok := body.Variables["query"]; ok {
+		owner := strings.SplitN(strings.Fields(search)[0], ":", 2)[1]
+		fmt.Fprintf(w, `{"data": {"search": {"repositoryCount": 1, "edges": [{"node": {"url": "https://%s/%s/repo1", "pushedAt": "2025-01-02T03:04:05Z", "refs": {"totalCount": 0}}}], "pageInfo": {"hasNextPage": false}}}}`, h.hostName(), owner)
+		return
+	}
+	fmt.Fprint(w, `{"data": {"repository": {"refs": {"totalCount": 0, "edges": [], "pageInfo": {"hasNextPage": false}}}}}`)
+}
+
+func TestGithubAppSCM(t *testing.T) {
+	key, keyPEM := generateAppKey(t)
+	host := newFakeAppHost(t, &key.PublicKey)
+
+	app, err := NewApp(testAppID, keyPEM, host.hostName(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut := NewGithubAppSCM(app, host.hostName(), false)
+
+	gotRepos, _, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotNames []string
+	for _, r := range gotRepos {
+		gotNames = append(gotNames, r.OrgRepoName)
+	}
+	if diff := cmp.Diff([]string{"someorg/repo1", "someuser/repo1"}, gotNames); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+
+	for range 2 {
+		for _, orgRepoName := range []string{"someorg/repo1", "SomeUser/repo1"} {
+			if _, _, err := sut.TagsForRepo(t.Context(), orgRepoName, nil, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, _, err := sut.TagsForRepo(t.Context(), "otherorg/repo1", nil, false); err == nil {
+		t.Errorf("TagsForRepo: expected an error for an org without an installation")
+	}
+
+	// Each org is queried with its own installation's token.
+	wantQueries := []string{
+		"token token-1: search org:someorg",
+		"token token-2: search user:someuser",
+		"token token-1: tags of someorg",
+		"token token-2: tags of SomeUser",
+		"token token-1: tags of someorg",
+		"token token-2: tags of SomeUser",
+	}
+	if diff := cmp.Diff(wantQueries, host.gotQueries); diff != "" {
+		t.Errorf("unexpected queries: -want, +got: %s", diff)
+	}
+	// Tokens are reused until they expire.
+	if diff := cmp.Diff(map[string]int{"token-1": 1, "token-2": 1}, host.minted); diff != "" {
+		t.Errorf("unexpected tokens minted: -want, +got: %s", diff)
+	}
+}
+
+func TestNewApp_InvalidKey(t *testing.T) {
+	_, keyPEM := generateAppKey(t)
+	for _, tc := range []struct {
+		name string
+		pem  []byte
+	}{
+		{name: "not PEM", pem: []byte("not a key")},
+		{name: "not a key", pem: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewApp(testAppID, tc.pem, testGithubHostname, true); err == nil {
+				t.Errorf("NewApp: expected an error")
+			}
+		})
+	}
+	if _, err := NewApp(testAppID, keyPEM, testGithubHostname, true); err != nil {
+		t.Errorf("NewApp: %v", err)
+	}
+}
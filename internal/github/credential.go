@@ -0,0 +1,328 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+	"golang.org/x/oauth2"
+)
+
+// A way of authenticating with GitHub: a GraphQL client, and the token source
+// that it and raw requests are authenticated with. Each credential has its own
+// rate limit.
+type credential struct {
+	// Identifies the credential in logs, ex "installation 123 (someorg)".
+	name          string
+	graphqlClient githubClient
+	tokenSource   oauth2.TokenSource
+
+	// The GitHub App installation that the credential belongs to, if any.
+	installation *Installation
+
+	// Guards rateLimit, benched and usage.
+	mu sync.Mutex
+	// The GraphQL API rate limit as of the last query.
+	rateLimit RateLimit
+	// Set when GitHub last rate limited the credential. It isn't used again
+	// until benchedUntil.
+	benched      *RateLimitError
+	benchedUntil time.Time
+	// Requests made since usage was last logged.
+	usage credentialUsage
+}
+
+// The requests made with a credential. See GithubSCM.LogUsage.
+type credentialUsage struct {
+	queries     int
+	points      int
+	rawRequests int
+	rateLimited int
+}
+
+// Creates a credential that authenticates with the given personal token.
+func newTokenCredential(name string, client githubClient, token string) *credential {
+	return &credential{
+		name:          name,
+		graphqlClient: client,
+		tokenSource:   oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token, TokenType: "token"}),
+	}
+}
+
+// Names the i-th token of a pool in logs without giving it away, ex
+// "token 2 (ending 3f9a)".
+func tokenName(i int, token string) string {
+	return fmt.Sprintf("token %d (ending %s)", i+1, token[max(len(token)-4, 0):])
+}
+
+// Returns the GraphQL API rate limit as of the last query.
+func (c *credential) RateLimit() RateLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rateLimit
+}
+
+// Returns a *RateLimitError if the credential shouldn't be used until its rate
+// limit resets. Otherwise, returns the number of points that it has left, which
+// is math.MaxInt if that isn't known or the limit has reset since.
+func (c *credential) headroom(now time.Time) (int, *RateLimitError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.benched != nil && now.Before(c.benchedUntil) {
+		return 0, c.benched
+	}
+	rl := c.rateLimit
+	if !rl.ResetAt.After(now) {
+		return math.MaxInt, nil
+	}
+	if rl.Remaining < max(rl.Cost, 1) {
+		return 0, &RateLimitError{ResetAt: rl.ResetAt, Err: fmt.Errorf("%d points remaining, last query cost %d", rl.Remaining, rl.Cost)}
+	}
+	return rl.Remaining, nil
+}
+
+// Stops the credential being used until the given rate limit resets.
+func (c *credential) bench(err *RateLimitError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.benched = err
+	// At least a moment, so that a request isn't retried with the same
+	// credential straight away.
+	c.benchedUntil = err.ResetAt
+	if soon := time.Now().Add(time.Second); c.benchedUntil.Before(soon) {
+		c.benchedUntil = soon
+	}
+	c.usage.rateLimited++
+	slog.Warn(fmt.Sprintf("github rate limited %s: %v", c.name, err))
+}
+
+func (c *credential) countRawRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage.rawRequests++
+}
+
+// Creates a new Github SCM that spreads its requests across the given personal
+// tokens, each of which has its own rate limit. Every GraphQL query and raw
+// request is made with the token that has the most points left, and tokens
+// that GitHub rate limits are benched until their limit resets.
+func NewGithubTokenPoolSCM(githubHostName string, tokens []string, useHTTPS bool) *GithubSCM {
+	scheme := "http"
+	if useHTTPS {
+		scheme = "https"
+	}
+	scm := &GithubSCM{githubHostName: githubHostName, useRawHTTPS: useHTTPS}
+	for i, token := range tokens {
+		src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+		client := githubv4.NewEnterpriseClient(fmt.Sprintf("%s://%s/api/graphql", scheme, githubHostName), oauth2.NewClient(context.Background(), src))
+		scm.pool = append(scm.pool, newTokenCredential(tokenName(i, token), client, token))
+	}
+	return scm
+}
+
+// Returned by pickCredential when there are no credentials at all, ex because
+// the GitHub App isn't installed anywhere.
+var errNoCredentials = errors.New("no github credentials")
+
+// Returns the given credential with the most headroom. Returns the
+// *RateLimitError that resets soonest if none of them can be used, or
+// errNoCredentials if there are none.
+func pickCredential(creds []*credential) (*credential, error) {
+	now := time.Now()
+	var best *credential
+	bestHeadroom := -1
+	var soonest *RateLimitError
+	for _, c := range creds {
+		headroom, err := c.headroom(now)
+		if err != nil {
+			if soonest == nil || err.ResetAt.Before(soonest.ResetAt) {
+				soonest = err
+			}
+			continue
+		}
+		if headroom > bestHeadroom {
+			best, bestHeadroom = c, headroom
+		}
+	}
+	if best == nil && soonest != nil {
+		return nil, soonest
+	}
+	if best == nil {
+		return nil, errNoCredentials
+	}
+	return best, nil
+}
+
+// Installations aren't listed again more often than this when an org without
+// one is queried, in case the app was just installed on it.
+const installationsRefreshPeriod = time.Minute
+
+// Creates a new Github SCM that authenticates as the given GitHub App: each org
+// is queried with the token of the app's installation on it. Orgs that the app
+// isn't installed on can't be queried.
+func NewGithubAppSCM(app *App, githubHostName string, useRawHTTPS bool) *GithubSCM {
+	return &GithubSCM{
+		githubHostName: githubHostName,
+		useRawHTTPS:    useRawHTTPS,
+		app:            app,
+	}
+}
+
+// Returns the credential to make the next request about the given org with.
+// Returns a *RateLimitError if every credential that could make it is out of
+// budget.
+func (scm *GithubSCM) credentialFor(ctx context.Context, org string) (*credential, error) {
+	if scm.app == nil {
+		return pickCredential(scm.pool)
+	}
+
+	scm.mu.Lock()
+	c, ok := scm.installations[strings.ToLower(org)]
+	stale := time.Since(scm.installationsListed) > installationsRefreshPeriod
+	scm.mu.Unlock()
+	if !ok && stale {
+		if err := scm.refreshInstallations(ctx); err != nil {
+			return nil, err
+		}
+		scm.mu.Lock()
+		c, ok = scm.installations[strings.ToLower(org)]
+		scm.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("the GitHub App isn't installed on %s", org)
+	}
+	return pickCredential([]*credential{c})
+}
+
+// Returns a key that's the same for orgs that are queried with the same
+// credentials: requests about them can be batched together.
+func (scm *GithubSCM) credentialGroup(org string) string {
+	if scm.app == nil {
+		return ""
+	}
+	return strings.ToLower(org)
+}
+
+// Lists the app's installations again, keeping the credentials, and so the
+// tokens and rate limits, of installations that are still there.
+func (scm *GithubSCM) refreshInstallations(ctx context.Context) error {
+	installations, err := scm.app.Installations(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing GitHub App installations: %w", err)
+	}
+
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	byID := make(map[int64]*credential)
+	for _, c := range scm.installations {
+		byID[c.installation.ID] = c
+	}
+	refreshed := make(map[string]*credential)
+	for _, i := range installations {
+		c, ok := byID[i.ID]
+		if !ok {
+			tokenSource := scm.app.InstallationTokenSource(i.ID)
+			c = &credential{
+				name:          fmt.Sprintf("installation %d (%s)", i.ID, i.Account),
+				graphqlClient: githubv4.NewEnterpriseClient(scm.app.graphqlURL(), oauth2.NewClient(context.Background(), tokenSource)),
+				tokenSource:   tokenSource,
+				installation:  i,
+			}
+			slog.Info(fmt.Sprintf("found GitHub App %s", c.name))
+		}
+		c.installation = i
+		refreshed[strings.ToLower(i.Account)] = c
+	}
+	scm.installations = refreshed
+	scm.installationsListed = time.Now()
+	return nil
+}
+
+// Returns the search qualifiers that together find all of the repos to index,
+// ex "org:someorg" for each installation, and the org to search with each
+// one's credential. The only qualifier is empty when not authenticating as a
+// GitHub App.
+func (scm *GithubSCM) searchQualifiers(ctx context.Context) (map[string]string, error) {
+	if scm.app == nil {
+		return map[string]string{"": ""}, nil
+	}
+
+	if err := scm.refreshInstallations(ctx); err != nil {
+		return nil, err
+	}
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	orgs := make(map[string]string)
+	for _, c := range scm.installations {
+		qualifier := "org:"
+		if c.installation.User {
+			qualifier = "user:"
+		}
+		orgs[qualifier+c.installation.Account] = c.installation.Account
+	}
+	return orgs, nil
+}
+
+// Returns all of the credentials in use, keyed by name.
+func (scm *GithubSCM) credentials() map[string]*credential {
+	creds := make(map[string]*credential)
+	if scm.app == nil {
+		for _, c := range scm.pool {
+			creds[c.name] = c
+		}
+		return creds
+	}
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	for _, c := range scm.installations {
+		creds[c.name] = c
+	}
+	return creds
+}
+
+// Returns the GraphQL API rate limit, as of the last query, of the credential
+// with the fewest points remaining.
+func (scm *GithubSCM) RateLimit() RateLimit {
+	var lowest RateLimit
+	creds := scm.credentials()
+	for _, name := range slices.Sorted(maps.Keys(creds)) {
+		rl := creds[name].RateLimit()
+		if lowest.ResetAt.IsZero() || (!rl.ResetAt.IsZero() && rl.Remaining < lowest.Remaining) {
+			lowest = rl
+		}
+	}
+	return lowest
+}
+
+// Returns the GraphQL API rate limit of each credential as of its last query,
+// keyed by credential name.
+func (scm *GithubSCM) RateLimits() map[string]RateLimit {
+	rateLimits := make(map[string]RateLimit)
+	for name, c := range scm.credentials() {
+		rateLimits[name] = c.RateLimit()
+	}
+	return rateLimits
+}
+
+// Logs the requests made with each credential since the last call, and how
+// much of its budget is left.
+func (scm *GithubSCM) LogUsage() {
+	creds := scm.credentials()
+	for _, name := range slices.Sorted(maps.Keys(creds)) {
+		c := creds[name]
+		c.mu.Lock()
+		usage, rl := c.usage, c.rateLimit
+		c.usage = credentialUsage{}
+		c.mu.Unlock()
+		slog.Info(fmt.Sprintf("github usage of %s: %d GraphQL queries costing %d points, %d raw requests, rate limited %d times. %d of %d points remaining, resets at %s",
+			name, usage.queries, usage.points, usage.rawRequests, usage.rateLimited, rl.Remaining, rl.Limit, rl.ResetAt.Format(time.RFC3339)))
+	}
+}
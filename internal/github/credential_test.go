@@ -0,0 +1,164 @@
+package github
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Stands up a GitHub host that answers tag queries with the rate limit of the
+// token that made them. Records the token of each query.
+type fakePoolHost struct {
+	t       *testing.T
+	server  *httptest.Server
+	resetAt time.Time
+
+	mu sync.Mutex
+	// The points that each token has left. Queries cost a point.
+	remaining map[string]int
+	// Tokens that get rate limit errors.
+	limited   map[string]bool
+	gotTokens []string
+}
+
+func newFakePoolHost(t *testing.T, remaining map[string]int) *fakePoolHost {
+	h := &fakePoolHost{t: t, resetAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second), remaining: remaining, limited: make(map[string]bool)}
+	h.server = httptest.NewServer(http.HandlerFunc(h.handleGraphQL))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *fakePoolHost) hostName() string {
+	return strings.TrimPrefix(h.server.URL, "http://")
+}
+
+func (h *fakePoolHost) limit(token string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limited[token] = true
+}
+
+func (h *fakePoolHost) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.gotTokens = append(h.gotTokens, token)
+
+	w.Header().Set("Content-Type", "application/json")
+	if h.limited[token] {
+		fmt.Fprint(w, `{"errors": [{"type": "RATE_LIMITED", "message": "API rate limit exceeded for user ID 1."}]}`)
+		return
+	}
+	h.remaining[token]--
+	fmt.Fprintf(w, `{"data": {"repository": {"refs": {"totalCount": 0, "edges": [], "pageInfo": {"hasNextPage": false}}}, "rateLimit": {"limit": 5000, "remaining": %d, "cost": 1, "resetAt": %q}}}`,
+		h.remaining[token], h.resetAt.Format(time.RFC3339))
+}
+
+func TestGithubTokenPoolSCM(t *testing.T) {
+	host := newFakePoolHost(t, map[string]int{"token-a": 101, "token-b": 4001})
+	sut := NewGithubTokenPoolSCM(host.hostName(), []string{"token-a", "token-b"}, false)
+
+	query := func() error {
+		_, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+		return err
+	}
+	for range 3 {
+		if err := query(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Tokens whose budget isn't known yet are tried first, then the one with
+	// the most points left.
+	if diff := cmp.Diff([]string{"token-a", "token-b", "token-b"}, host.gotTokens); diff != "" {
+		t.Errorf("unexpected tokens: -want, +got: %s", diff)
+	}
+
+	// A rate limited token is benched, and the query retried with another.
+	host.gotTokens = nil
+	host.limit("token-b")
+	for range 2 {
+		if err := query(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff([]string{"token-b", "token-a", "token-a"}, host.gotTokens); diff != "" {
+		t.Errorf("unexpected tokens after rate limiting: -want, +got: %s", diff)
+	}
+
+	// Once every token is benched, queries fail without being made until the
+	// soonest reset.
+	host.gotTokens = nil
+	host.limit("token-a")
+	err := query()
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a *RateLimitError, got %v", err)
+	}
+	if !rateLimitErr.ResetAt.Equal(host.resetAt) {
+		t.Errorf("expected reset at %v, got %v", host.resetAt, rateLimitErr.ResetAt)
+	}
+	if err := query(); !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a *RateLimitError, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"token-a"}, host.gotTokens); diff != "" {
+		t.Errorf("unexpected tokens once all are benched: -want, +got: %s", diff)
+	}
+}
+
+func TestLogUsage(t *testing.T) {
+	host := newFakePoolHost(t, map[string]int{"token-a": 101, "token-b": 4001})
+	sut := NewGithubTokenPoolSCM(host.hostName(), []string{"token-a", "token-b"}, false)
+	for range 3 {
+		if _, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	sut.LogUsage()
+	got := buf.String()
+	for _, want := range []string{
+		"github usage of token 1 (ending en-a): 1 GraphQL queries costing 1 points, 0 raw requests, rate limited 0 times. 100 of 5000 points remaining",
+		"github usage of token 2 (ending en-b): 2 GraphQL queries costing 2 points, 0 raw requests, rate limited 0 times. 3999 of 5000 points remaining",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the log to contain %q, got %s", want, got)
+		}
+	}
+	if strings.Contains(got, "token-a") || strings.Contains(got, "token-b") {
+		t.Errorf("expected the log not to contain the tokens, got %s", got)
+	}
+
+	// Usage is counted afresh after each log.
+	buf.Reset()
+	sut.LogUsage()
+	if want := "github usage of token 1 (ending en-a): 0 GraphQL queries"; !strings.Contains(buf.String(), want) {
+		t.Errorf("expected the log to contain %q, got %s", want, buf.String())
+	}
+}
+
+func TestPickCredential_NoCredentials(t *testing.T) {
+	_, err := pickCredential(nil)
+	if !errors.Is(err, errNoCredentials) {
+		t.Errorf("pickCredential: expected errNoCredentials, got %v", err)
+	}
+	// Callers pause until rate limits reset, which there's no telling for a
+	// typed nil.
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		t.Errorf("pickCredential: expected no *RateLimitError, got %#v", err)
+	}
+}
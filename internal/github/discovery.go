@@ -0,0 +1,151 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+type ownerReposQueryResult struct {
+	RepositoryOwner struct {
+		Repositories struct {
+			Nodes    []ownerRepoNode
+			PageInfo queryPageInfo
+		} `graphql:"repositories(first: 50, after: $reposCursor)"`
+	} `graphql:"repositoryOwner(login: $owner)"`
+	RateLimit rateLimitQuery
+}
+
+type ownerRepoNode struct {
+	ID         string
+	URL        githubv4.URI
+	PushedAt   githubv4.DateTime
+	IsArchived bool
+	IsFork     bool
+	Refs       struct {
+		TotalCount int
+	} `graphql:"refs(refPrefix: \"refs/tags/\")"`
+	// The repo's root directory at HEAD. Empty if the repo has no commits.
+	Root struct {
+		Tree goModProbeTree `graphql:"... on Tree"`
+	} `graphql:"root: object(expression: \"HEAD:\")"`
+}
+
+type goModProbeTree struct {
+	Entries []goModProbeEntry
+}
+
+type goModProbeEntry struct {
+	Name   string
+	Type   string
+	Object struct {
+		Tree struct {
+			Entries []struct {
+				Name string
+				Type string
+			}
+		} `graphql:"... on Tree"`
+	}
+}
+
+// Whether the tree has a go.mod file at its root, or in one of its immediate
+// subdirectories.
+func (t *goModProbeTree) hasGoMod() bool {
+	for _, e := range t.Entries {
+		if e.Type == "blob" && e.Name == "go.mod" {
+			return true
+		}
+		for _, sub := range e.Object.Tree.Entries {
+			if sub.Type == "blob" && sub.Name == "go.mod" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Makes GoRepos also find repos with a go.mod file, at their root or one
+// directory down, whatever language GitHub classifies them as, ex repos that
+// are mostly protobuf or Terraform. Off by default.
+//
+// Finding them means listing every repo of each org: the org of every
+// installation when authenticating as a GitHub App, or else the rules'
+// AllowOrgs. Orgs excluded by the rules aren't listed.
+func (scm *GithubSCM) SetGoModDiscovery(enabled bool) {
+	scm.goModDiscovery = enabled
+}
+
+// Returns the orgs to list for go.mod discovery. See SetGoModDiscovery.
+func (scm *GithubSCM) goModDiscoveryOrgs(searchOrgs map[string]string) []string {
+	var orgs []string
+	if scm.app != nil {
+		for _, org := range searchOrgs {
+			orgs = append(orgs, org)
+		}
+	} else if scm.rules != nil {
+		orgs = append(orgs, scm.rules.AllowOrgs...)
+	}
+	if len(orgs) == 0 {
+		slog.Warn("go.mod discovery is enabled, but there are no orgs to list: set allowOrgs in the rules")
+	}
+
+	var kept []string
+	for _, org := range orgs {
+		if scm.rules.OrgExclusion(org) == "" {
+			kept = append(kept, org)
+		}
+	}
+	return kept
+}
+
+// Retrieves the repos of the given org that have a go.mod file, by listing all
+// of the org's repos and looking for one in each repo's tree at HEAD.
+func (scm *GithubSCM) goReposWithGoMod(ctx context.Context, org string) ([]*Repo, error) {
+	if scm.rest {
+		return scm.restGoReposWithGoMod(ctx, org)
+	}
+
+	var results []*Repo
+	variables := map[string]any{
+		"owner":       githubv4.String(org),
+		"reposCursor": (*githubv4.String)(nil),
+	}
+
+	listed := 0
+	var q ownerReposQueryResult
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		if err := scm.query(queryCtx, org, &q, variables); err != nil {
+			return nil, fmt.Errorf("error listing repositories of %s: %w", org, err)
+		}
+
+		for _, node := range q.RepositoryOwner.Repositories.Nodes {
+			listed++
+			if !node.Root.Tree.hasGoMod() {
+				continue
+			}
+			results = append(results, &Repo{
+				OrgRepoName: strings.TrimPrefix(node.URL.String(), fmt.Sprintf("https://%s/", scm.githubHostName)),
+				PushedAt:    node.PushedAt.UTC(),
+				TagCount:    node.Refs.TotalCount,
+				Archived:    node.IsArchived,
+				Fork:        node.IsFork,
+				NodeID:      node.ID,
+			})
+		}
+
+		if !q.RepositoryOwner.Repositories.PageInfo.HasNextPage {
+			break
+		}
+		variables["reposCursor"] = githubv4.NewString(q.RepositoryOwner.Repositories.PageInfo.EndCursor)
+	}
+
+	slog.Info(fmt.Sprintf("found %d repos with a go.mod file among the %d repos of %s", len(results), listed, org))
+	return results, nil
+}
@@ -0,0 +1,105 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Stands up a GitHub host where the language search finds someorg/repo1, and
+// listing someorg's repos finds repo1 to repo4 over two pages. Records the
+// owner of each listing.
+func newFakeDiscoveryHost(t *testing.T) (*httptest.Server, *[]string) {
+	var gotListings []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query     string         `json:"query"`
+			Variables map[string]any `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		hostName := strings.TrimPrefix(server.URL, "http://")
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, ok := body.Variables["query"]; ok {
+			fmt.Fprintf(w, `{"data": {"search": {"repositoryCount": 1, "edges": [{"node": {"id": "R_1", "url": "https://%s/someorg/repo1", "refs": {"totalCount": 1}}}], "pageInfo": {"hasNextPage": false}}}}`, hostName)
+			return
+		}
+
+		if !strings.Contains(body.Query, `root: object(expression: "HEAD:")`) {
+			t.Errorf("expected the listing to probe HEAD, got %s", body.Query)
+		}
+		gotListings = append(gotListings, fmt.Sprint(body.Variables["owner"]))
+		if body.Variables["reposCursor"] == nil {
+			fmt.Fprintf(w, `{"data": {"repositoryOwner": {"repositories": {"nodes": [
+				{"id": "R_1", "url": "https://%[1]s/someorg/repo1", "refs": {"totalCount": 1}, "root": {"entries": [{"name": "go.mod", "type": "blob", "object": {}}]}},
+				{"id": "R_2", "url": "https://%[1]s/someorg/repo2", "refs": {"totalCount": 2}, "root": {"entries": [{"name": "api.proto", "type": "blob", "object": {}}, {"name": "tools", "type": "tree", "object": {"entries": [{"name": "go.mod", "type": "blob"}]}}]}}
+			], "pageInfo": {"endCursor": "c1", "hasNextPage": true}}}}}`, hostName)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"repositoryOwner": {"repositories": {"nodes": [
+			{"id": "R_3", "url": "https://%[1]s/someorg/repo3", "refs": {"totalCount": 0}, "root": {"entries": [{"name": "main.tf", "type": "blob", "object": {}}, {"name": "go.mod", "type": "tree", "object": {"entries": []}}]}},
+			{"id": "R_4", "url": "https://%[1]s/someorg/repo4", "refs": {"totalCount": 0}, "root": null}
+		], "pageInfo": {"hasNextPage": false}}}}}`, hostName)
+	}))
+	t.Cleanup(server.Close)
+	return server, &gotListings
+}
+
+func TestGoRepos_GoModDiscovery(t *testing.T) {
+	server, gotListings := newFakeDiscoveryHost(t)
+	sut := NewGithubTokenPoolSCM(strings.TrimPrefix(server.URL, "http://"), []string{"token"}, false)
+	r, err := rules.Parse([]byte(`{"allowOrgs": ["someorg"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut.SetRules(r)
+	sut.SetGoModDiscovery(true)
+
+	got, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+
+	// repo1 is found both ways, but returned once. repo3's go.mod is a
+	// directory, and repo4 is empty.
+	want := []*Repo{
+		{OrgRepoName: "someorg/repo1", TagCount: 1, NodeID: "R_1"},
+		{OrgRepoName: "someorg/repo2", TagCount: 2, NodeID: "R_2"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"someorg", "someorg"}, *gotListings); diff != "" {
+		t.Errorf("unexpected listings: -want, +got: %s", diff)
+	}
+}
+
+func TestGoRepos_GoModDiscoveryOff(t *testing.T) {
+	server, gotListings := newFakeDiscoveryHost(t)
+	sut := NewGithubTokenPoolSCM(strings.TrimPrefix(server.URL, "http://"), []string{"token"}, false)
+	r, err := rules.Parse([]byte(`{"allowOrgs": ["someorg"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut.SetRules(r)
+
+	got, _, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || len(*gotListings) != 0 {
+		t.Errorf("GoRepos: expected only the language search's repo and no listings, got %d repos and listings %v", len(got), *gotListings)
+	}
+}
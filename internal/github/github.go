@@ -3,16 +3,21 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
+	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
 	"github.com/shurcooL/githubv4"
-	"golang.org/x/mod/modfile"
-	"golang.org/x/mod/module"
 )
 
 // githubClient wraps query interface from the shurcooL/githubv4 package so
@@ -24,61 +29,249 @@ type githubClient interface {
 
 // A handle for specialised github querying.
 type GithubSCM struct {
-	graphqlClient   githubClient
-	githubHostName  string
-	githubAuthToken string
-	useRawHTTPS     bool
+	githubHostName string
+	useRawHTTPS    bool
+
+	// Used to query every org, unless authenticating as a GitHub App. Each
+	// request is made with the credential with the most headroom. See
+	// NewGithubTokenPoolSCM.
+	pool []*credential
+
+	// Set when authenticating as a GitHub App. See NewGithubAppSCM.
+	app *App
+
+	// Which orgs and tags to index. See SetRules.
+	rules *rules.Rules
+
+	// Whether GoRepos also finds repos by their go.mod files. See
+	// SetGoModDiscovery.
+	goModDiscovery bool
+
+	// Whether to use the REST API rather than the GraphQL API. See SetREST.
+	rest bool
+
+	// Guards installations and installationsListed.
+	mu sync.Mutex
+	// The app's installations, keyed by lowercased account login.
+	installations       map[string]*credential
+	installationsListed time.Time
 }
 
-// Creates a new Github SCM.
+// Creates a new Github SCM that authenticates with the given client, and the
+// given token for raw requests.
 func NewGithubSCM(client githubClient, githubHostName, githubAuthToken string, useRawHTTPS bool) *GithubSCM {
-	return &GithubSCM{graphqlClient: client,
-		githubHostName:  githubHostName,
-		githubAuthToken: githubAuthToken,
-		useRawHTTPS:     useRawHTTPS,
+	return &GithubSCM{
+		githubHostName: githubHostName,
+		useRawHTTPS:    useRawHTTPS,
+		pool:           []*credential{newTokenCredential(tokenName(0, githubAuthToken), client, githubAuthToken)},
 	}
 }
 
+// Sets the rules that decide which orgs are searched by GoRepos, and which tags
+// TagsForRepo excludes. Must be called before either is. A nil *Rules, the
+// default, excludes nothing.
+func (scm *GithubSCM) SetRules(r *rules.Rules) {
+	scm.rules = r
+}
+
+// GitHub search returns at most this many results for any one query, no matter
+// how many pages are requested.
+const searchResultsCap = 1000
+
+// The earliest creation date to search from. Predates any GitHub repository.
+var searchEpoch = time.Date(2007, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// The date format accepted by the search created: qualifier.
+const searchDateFormat = "2006-01-02T15:04:05-07:00"
+
 type repoQueryResult struct {
 	Search struct {
-		Edges    []repoQueryEdge
-		PageInfo queryPageInfo
+		RepositoryCount int
+		Edges           []repoQueryEdge
+		PageInfo        queryPageInfo
 	} `graphql:"search(query: $query, type: REPOSITORY, first: 100, after: $tagsCursor)"`
+	RateLimit rateLimitQuery
 }
 
 type repoQueryEdge struct {
 	Node struct {
 		Repo struct {
-			URL githubv4.URI
+			ID         string
+			URL        githubv4.URI
+			PushedAt   githubv4.DateTime
+			IsArchived bool
+			IsFork     bool
+			Refs       struct {
+				TotalCount int
+			} `graphql:"refs(refPrefix: \"refs/tags/\")"`
 		} `graphql:"... on Repository"`
 	}
 }
 
+// The types GithubSCM deals in, shared with the other vcs.SCMs.
+type (
+	Repo        = vcs.Repo
+	RepoTag     = vcs.RepoTag
+	TagsRequest = vcs.TagsRequest
+	TagsResult  = vcs.TagsResult
+)
+
 type queryPageInfo struct {
 	EndCursor   githubv4.String
 	HasNextPage bool
 }
 
-// Retrieves all golang repos. Returns results as slice of "orgname/reponame".
-func (scm *GithubSCM) GoRepos(ctx context.Context) ([]string, error) {
-	var results []string
+// Retrieves all golang repos, along with when each was last pushed to and how
+// many tags it has, so that repos that haven't changed needn't be re-indexed.
+//
+// GitHub search stops returning results after searchResultsCap hits, so the
+// search is sliced by repo creation date: any slice that matches more than
+// searchResultsCap repos is split in half until each slice fits under the cap.
+// Slices of a single second can't be split any further: they're truncated, and
+// logged as a warning, in which case complete is false: repos missing from the
+// results may still exist.
+//
+// With go.mod discovery on, repos with a go.mod file are found too, whatever
+// their language: see SetGoModDiscovery. Repos found both ways are returned once.
+//
+// When authenticating as a GitHub App, each installation's org is searched
+// separately, with its own token. Orgs that the rules exclude aren't searched.
+// Other repos that the rules exclude are still returned, so that they can be
+// removed from the index: see db.DB.StoreRepos.
+func (scm *GithubSCM) GoRepos(ctx context.Context) (_ []*Repo, complete bool, _ error) {
+	orgs, err := scm.searchQualifiers(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var results []*Repo
+	seen := make(map[string]bool)
+	complete = true
+	for _, qualifier := range slices.Sorted(maps.Keys(orgs)) {
+		if qualifier != "" && scm.rules.OrgExclusion(orgs[qualifier]) != "" {
+			continue
+		}
+		repos, qualifierComplete, err := scm.goRepos(ctx, orgs[qualifier], qualifier)
+		if err != nil {
+			return nil, false, err
+		}
+		complete = complete && qualifierComplete
+		for _, repo := range repos {
+			if seen[repo.OrgRepoName] {
+				continue
+			}
+			seen[repo.OrgRepoName] = true
+			results = append(results, repo)
+		}
+	}
+
+	if scm.goModDiscovery {
+		for _, org := range slices.Sorted(slices.Values(scm.goModDiscoveryOrgs(orgs))) {
+			repos, err := scm.goReposWithGoMod(ctx, org)
+			if err != nil {
+				return nil, false, err
+			}
+			for _, repo := range repos {
+				if seen[repo.OrgRepoName] {
+					continue
+				}
+				seen[repo.OrgRepoName] = true
+				results = append(results, repo)
+			}
+		}
+	}
+	return results, complete, nil
+}
+
+// Retrieves the golang repos matching the given search qualifier, which may be
+// empty, with the credential for the given org. See GoRepos.
+func (scm *GithubSCM) goRepos(ctx context.Context, org, qualifier string) (_ []*Repo, complete bool, _ error) {
+	var results []*Repo
+	complete = true
+
+	// Slices still to be searched, as inclusive [from, to] creation date
+	// ranges.
+	ranges := [][2]time.Time{{searchEpoch, time.Now().UTC().Truncate(time.Second)}}
+	for len(ranges) > 0 {
+		from, to := ranges[0][0], ranges[0][1]
+		ranges = ranges[1:]
+
+		slice, count, truncated, err := scm.goReposCreatedBetween(ctx, org, qualifier, from, to)
+		if err != nil {
+			return nil, false, err
+		}
+		if count > searchResultsCap && !truncated {
+			// Split the slice in half and search each half separately.
+			mid := from.Add(to.Sub(from) / 2).Truncate(time.Second)
+			ranges = append(ranges, [2]time.Time{from, mid}, [2]time.Time{mid.Add(time.Second), to})
+			continue
+		}
+		if truncated {
+			// Easy to miss otherwise: the repos left out aren't indexed, and
+			// no repos are deleted while the listing is incomplete.
+			complete = false
+			slog.Warn(fmt.Sprintf("repo search %q for repos created between %s and %s was truncated: matched %d repos but only %d could be retrieved. The rest won't be indexed, and missing repos won't be deleted, until fewer repos match", qualifier, from.Format(time.RFC3339), to.Format(time.RFC3339), count, len(slice)))
+		}
+
+		results = append(results, slice...)
+	}
+
+	return results, complete, nil
+}
+
+// Retrieves golang repos created in the inclusive range [from, to]. count is
+// the total number of repos matched by the search.
+//
+// If the search matches more than searchResultsCap repos, no repos are returned
+// so that the caller can split the range, unless the range is already too
+// small to split: in that case, as many repos as GitHub returns are retrieved
+// and truncated is true.
+func (scm *GithubSCM) goReposCreatedBetween(ctx context.Context, org, qualifier string, from, to time.Time) (_ []*Repo, count int, truncated bool, _ error) {
+	if scm.rest {
+		return scm.restGoReposCreatedBetween(ctx, org, qualifier, from, to)
+	}
+
+	var results []*Repo
+	query := fmt.Sprintf("language:golang created:%s..%s", from.Format(searchDateFormat), to.Format(searchDateFormat))
+	if qualifier != "" {
+		query = qualifier + " " + query
+	}
 	variables := map[string]any{
-		"query":      githubv4.String("language:golang"),
+		"query":      githubv4.String(query),
 		"tagsCursor": (*githubv4.String)(nil),
 	}
 
 	var q repoQueryResult
-	for {
+	for firstPage := true; ; firstPage = false {
 		queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 		defer cancel()
 
-		if err := scm.graphqlClient.Query(queryCtx, &q, variables); err != nil {
-			return nil, fmt.Errorf("error querying repositories: %w", err)
+		if err := scm.query(queryCtx, org, &q, variables); err != nil {
+			return nil, 0, false, fmt.Errorf("error querying repositories: %w", err)
+		}
+
+		if firstPage {
+			count = q.Search.RepositoryCount
+			if count > searchResultsCap {
+				// Ranges are searched to the second, so only a single
+				// second can't be split.
+				if to.After(from) {
+					return nil, count, false, nil
+				}
+				truncated = true
+			}
 		}
 
 		for _, edge := range q.Search.Edges {
 			corpName := strings.TrimPrefix(string(edge.Node.Repo.URL.String()), fmt.Sprintf("https://%s/", scm.githubHostName))
-			results = append(results, string(corpName))
+			results = append(results, &Repo{
+				OrgRepoName: corpName,
+				PushedAt:    edge.Node.Repo.PushedAt.UTC(),
+				TagCount:    edge.Node.Repo.Refs.TotalCount,
+				Archived:    edge.Node.Repo.IsArchived,
+				Fork:        edge.Node.Repo.IsFork,
+				NodeID:      edge.Node.Repo.ID,
+			})
 		}
 
 		if !q.Search.PageInfo.HasNextPage {
@@ -88,16 +281,22 @@ func (scm *GithubSCM) GoRepos(ctx context.Context) ([]string, error) {
 		variables["tagsCursor"] = githubv4.NewString(q.Search.PageInfo.EndCursor)
 	}
 
-	return results, nil
+	return results, count, truncated, nil
 }
 
 type tagQueryResponse struct {
-	Repository struct {
-		Refs struct {
-			Edges    []tagQueryEdge
-			PageInfo queryPageInfo
-		} `graphql:"refs(refPrefix: \"refs/tags/\", orderBy: {field: TAG_COMMIT_DATE, direction: DESC}, first: 100, after: $tagsCursor)"`
-	} `graphql:"repository(owner: $repoOrg, name: $repoName)"`
+	Repository tagQueryRepository `graphql:"repository(owner: $repoOrg, name: $repoName)"`
+	RateLimit  rateLimitQuery
+}
+
+type tagQueryRepository struct {
+	Refs tagQueryRefs `graphql:"refs(refPrefix: \"refs/tags/\", orderBy: {field: TAG_COMMIT_DATE, direction: DESC}, first: 100, after: $tagsCursor)"`
+}
+
+type tagQueryRefs struct {
+	TotalCount int
+	Edges      []tagQueryEdge
+	PageInfo   queryPageInfo
 }
 
 type tagQueryEdge struct {
@@ -105,122 +304,166 @@ type tagQueryEdge struct {
 		Name   githubv4.String
 		Target struct {
 			Commit struct {
+				Oid           githubv4.GitObjectID
 				CommittedDate githubv4.DateTime
 			} `graphql:"... on Commit"`
 			Tag struct {
 				Tagger struct {
 					Date githubv4.DateTime
 				}
+				Target struct {
+					Oid githubv4.GitObjectID
+				}
 			} `graphql:"... on Tag"`
 		}
 	}
 }
 
-// A repo tag and its creation date.
-type RepoTag struct {
-	Tag        string
-	TagDate    time.Time
-	ModulePath string
-}
-
-// Retrieves all tags for a given repo.
-func (scm *GithubSCM) TagsForRepo(ctx context.Context, orgRepoName string) ([]*RepoTag, error) {
-	var q tagQueryResponse
-
+// Retrieves all tags for a given repo. Tags that aren't valid module versions,
+// or that the rules exclude, are included with their Rejection set.
+//
+// known holds the results of a previous call, keyed by tag name. Tags in known
+// that still point at the same commit keep their module path, version and
+// rejection, rather than fetching their go.mod file again. known may be nil.
+//
+// If incremental is true, paging stops after the first page with a tag in known
+// that still points at the same commit: since tags are ordered newest commit
+// first, the remaining tags are most likely known already. Paging continues if
+// tags seem to have been deleted, since only a complete list shows which ones.
+// complete is false if paging stopped early, in which case the results should
+// be merged with known rather than replace it.
+func (scm *GithubSCM) TagsForRepo(ctx context.Context, orgRepoName string, known map[string]*RepoTag, incremental bool) (_ []*RepoTag, complete bool, _ error) {
 	repo, err := newRepo(scm.githubHostName, orgRepoName)
 	if err != nil {
-		return nil, fmt.Errorf("TagsForRepo: %v", err)
+		return nil, false, fmt.Errorf("TagsForRepo: %v", err)
+	}
+
+	if scm.rest {
+		tags, err := scm.restTagsForRepo(ctx, repo, known)
+		return tags, err == nil, err
+	}
+
+	f := &tagsFetch{repo: repo, known: known, incremental: incremental}
+	if err := scm.fetchTagPages(ctx, f, nil); err != nil {
+		return nil, false, err
 	}
+	return f.results, f.complete, nil
+}
 
+// The state of fetching one repo's tags, page by page.
+type tagsFetch struct {
+	repo        repo
+	known       map[string]*RepoTag
+	incremental bool
+
+	results []*RepoTag
+	// The number of tags seen that aren't in known.
+	unknown int
+	// Whether all of the repo's tags were fetched.
+	complete bool
+	// Set by TagsForRepos if the repo's tags couldn't be fetched.
+	err error
+}
+
+// Queries the given repo's tag pages, starting after cursor, until f is done.
+// A nil cursor starts at the first page.
+func (scm *GithubSCM) fetchTagPages(ctx context.Context, f *tagsFetch, cursor *githubv4.String) error {
+	var q tagQueryResponse
 	variables := map[string]any{
-		"repoOrg":    githubv4.String(repo.org),
-		"repoName":   githubv4.String(repo.name),
-		"tagsCursor": (*githubv4.String)(nil),
+		"repoOrg":    githubv4.String(f.repo.org),
+		"repoName":   githubv4.String(f.repo.name),
+		"tagsCursor": cursor,
 	}
 
-	var results []*RepoTag
 	// Page through all the results.
 	for {
 		queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 		defer cancel()
 
-		if err := scm.graphqlClient.Query(queryCtx, &q, variables); err != nil {
-			return nil, fmt.Errorf("error querying tags for %s: %w", repo.fullName(), err)
+		if err := scm.query(queryCtx, f.repo.org, &q, variables); err != nil {
+			return fmt.Errorf("error querying tags for %s: %w", f.repo.fullName(), err)
 		}
 
-		for _, t := range q.Repository.Refs.Edges {
-			var tag RepoTag
-			tag.Tag = string(t.Node.Name)
-
-			// leightweight tags point directly to commits and have
-			// `committedDate` timestamp stored on them directly. annotated
-			// tags do not have a committedDate and instead store their
-			// creation timestamp in the `tag.tagger.date` field. This logic is
-			// needed so we correctly set tag date for both type of tags.
-			if !t.Node.Target.Commit.CommittedDate.IsZero() {
-				tag.TagDate = t.Node.Target.Commit.CommittedDate.UTC()
-			} else if !t.Node.Target.Tag.Tagger.Date.IsZero() {
-				tag.TagDate = t.Node.Target.Tag.Tagger.Date.UTC()
-			}
-
-			modulePath := repo.asModulePath()
-
-			goModModulePath, found, err := scm.modulePathFromGoMod(ctx, repo, tag.Tag)
-			if err != nil {
-				// if go.mod file was found but turned out to be invalid, we want to skip the tag entirely
-				if found {
-					slog.Error(fmt.Sprintf("found go.mod file for %s but it's invalid: %v. Skipping the tag", repo.fullName(), err))
-					continue
-				}
-
-				slog.Error(fmt.Sprintf("error getting go.mod file for %s: %v. Defaulting to github url for module path", repo.fullName(), err))
-			}
+		more, err := scm.addTagPage(ctx, f, &q.Repository.Refs)
+		if err != nil || !more {
+			return err
+		}
 
-			if found {
-				modulePath = goModModulePath
-			} else {
-				slog.Info(fmt.Sprintf("unable to find go.mod file in the root of the project for %s. Defaulting to github url for module path", repo.fullName()))
-			}
+		variables["tagsCursor"] = githubv4.NewString(q.Repository.Refs.PageInfo.EndCursor)
+	}
+}
 
-			tag.ModulePath = modulePath
-			results = append(results, &tag)
+// Adds a page of the repo's tags to f's results. more is true if the next page
+// should be fetched.
+//
+// The go.mod files of all of the page's tags that need one are fetched at once.
+func (scm *GithubSCM) addTagPage(ctx context.Context, f *tagsFetch, refs *tagQueryRefs) (more bool, _ error) {
+	repo := f.repo
+	var tags []*RepoTag
+	for _, t := range refs.Edges {
+		var tag RepoTag
+		tag.Tag = string(t.Node.Name)
+
+		// leightweight tags point directly to commits and have
+		// `committedDate` timestamp stored on them directly. annotated
+		// tags do not have a committedDate and instead store their
+		// creation timestamp in the `tag.tagger.date` field. This logic is
+		// needed so we correctly set tag date for both type of tags.
+		if !t.Node.Target.Commit.CommittedDate.IsZero() {
+			tag.TagDate = t.Node.Target.Commit.CommittedDate.UTC()
+		} else if !t.Node.Target.Tag.Tagger.Date.IsZero() {
+			tag.TagDate = t.Node.Target.Tag.Tagger.Date.UTC()
 		}
-
-		if !q.Repository.Refs.PageInfo.HasNextPage {
-			break
+		// Likewise for the commit that the tag points to.
+		if t.Node.Target.Commit.Oid != "" {
+			tag.TargetSHA = string(t.Node.Target.Commit.Oid)
+		} else {
+			tag.TargetSHA = string(t.Node.Target.Tag.Target.Oid)
 		}
+		tags = append(tags, &tag)
+	}
+	f.results = append(f.results, tags...)
 
-		variables["tagsCursor"] = githubv4.NewString(q.Repository.Refs.PageInfo.EndCursor)
+	reachedKnown, unknown, err := vcs.ResolveTags(ctx, repo.fullName(), repo.asModulePath(), tags, f.known, scm.rules, func(ctx context.Context, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+		return scm.goMods(ctx, repo, files)
+	})
+	if err != nil {
+		return false, err
 	}
+	f.unknown += unknown
 
-	return results, nil
+	if !refs.PageInfo.HasNextPage {
+		f.complete = true
+		return false, nil
+	}
+	// With no tags deleted, the repo has every known tag plus the unknown ones
+	// seen so far.
+	if f.incremental && reachedKnown && refs.TotalCount == len(f.known)+f.unknown {
+		return false, nil
+	}
+	return true, nil
 }
 
-// goModForRepo retrieves go.mod file for the repository so that we can inspect
-// its content and determine if the module path matches the repo URL or if the
-// module path is different and needs to be updated in the index. The latter
-// commonly occurs when a module has been migrated from one vcs to another
-// without changing the module path.
-func (scm *GithubSCM) modulePathFromGoMod(ctx context.Context, repo repo, tag string) (string, bool, error) {
-	protocol := "http://"
-	if scm.useRawHTTPS {
-		protocol = "https://"
+// Retrieves the contents of the go.mod file in the given repo subdirectory at
+// the given tag. found is false if there is no such go.mod file.
+func (scm *GithubSCM) GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error) {
+	repo, err := newRepo(scm.githubHostName, orgRepoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("GoMod: %v", err)
 	}
-
-	request, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		fmt.Sprintf("%s%s/raw/%s/%s/%s/go.mod", protocol, scm.githubHostName, repo.org, repo.name, tag),
-		nil,
-	)
+	goMods, err := scm.goMods(ctx, repo, []vcs.GoModFile{{Tag: tag, Dir: dir}})
 	if err != nil {
-		return "", false, fmt.Errorf("error building raw github API request: %v", err)
+		return nil, false, err
 	}
-	request.Header.Set("Authorization", fmt.Sprintf("token %s", scm.githubAuthToken))
+	return goMods[0].Content, goMods[0].Found, goMods[0].Err
+}
 
-	resp, err := http.DefaultClient.Do(request)
+// Retrieves the go.mod file like GoMod, but from the raw endpoint rather than
+// the GraphQL API.
+func (scm *GithubSCM) goMod(ctx context.Context, repo repo, tag, dir string) ([]byte, bool, error) {
+	resp, err := scm.get(ctx, repo.org, fmt.Sprintf("%s/raw/%s/%s/%s/%s", scm.githubHostName, repo.org, repo.name, tag, path.Join(dir, "go.mod")))
 	if err != nil {
-		return "", false, fmt.Errorf("error querying raw github API for go.mod contents: %v", err)
+		return nil, false, fmt.Errorf("error querying raw github API for go.mod contents: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -228,31 +471,100 @@ func (scm *GithubSCM) modulePathFromGoMod(ctx context.Context, repo repo, tag st
 	// file in the root of the directory. This avoid extra noise in logs by not
 	// logging such case as an error.
 	if resp.StatusCode == 404 {
-		return "", false, nil
+		return nil, false, nil
 	}
 
 	if resp.StatusCode != 200 {
-		return "", false, fmt.Errorf("unexpected status code from raw github API. Status code: %d", resp.StatusCode)
+		return nil, false, fmt.Errorf("unexpected status code from raw github API. Status code: %d", resp.StatusCode)
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", false, fmt.Errorf("error reading raw github API response: %v", err)
+		return nil, false, fmt.Errorf("error reading raw github API response: %v", err)
 	}
+	return bodyBytes, true, nil
+}
 
-	file, err := modfile.Parse("go.mod", bodyBytes, nil)
+// Retrieves a zip archive of the repo contents at the given tag. All files in
+// the archive are inside a single top-level directory.
+func (scm *GithubSCM) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	repo, err := newRepo(scm.githubHostName, orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("Zipball: %v", err)
+	}
+
+	resp, err := scm.get(ctx, repo.org, fmt.Sprintf("%s/api/v3/repos/%s/%s/zipball/%s", scm.githubHostName, repo.org, repo.name, tag))
+	if err != nil {
+		return nil, fmt.Errorf("error querying github API for zipball of %s (tag: %s): %w", repo.fullName(), tag, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code from github API for zipball of %s (tag: %s). Status code: %d", repo.fullName(), tag, resp.StatusCode)
+	}
+
+	bodyBytes, err := vcs.ReadZipball(resp.Body)
 	if err != nil {
-		return "", false, fmt.Errorf("error parsing go.mod file for %s (tag: %s): %v", repo.fullName(), tag, err)
+		return nil, fmt.Errorf("error reading zipball of %s (tag: %s): %v", repo.fullName(), tag, err)
 	}
+	return bodyBytes, nil
+}
 
-	if file.Module != nil {
-		err := module.CheckPath(file.Module.Mod.Path)
+// Makes a GET request to the given URL, which should not include the protocol,
+// authenticated with the credential for the given org. Returns a
+// *RateLimitError if the request was rate limited, and no other credential
+// could retry it.
+func (scm *GithubSCM) get(ctx context.Context, org, url string) (*http.Response, error) {
+	for {
+		cred, err := scm.credentialFor(ctx, org)
 		if err != nil {
-			return "", true, fmt.Errorf("invalid module path found for %s (tag: %s): %v", repo.fullName(), tag, err)
+			return nil, err
+		}
+		resp, err := scm.getWith(ctx, cred, url)
+		var rateLimitErr *RateLimitError
+		if !errors.As(err, &rateLimitErr) {
+			return resp, err
 		}
+		cred.bench(rateLimitErr)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// Makes a GET request with the given credential. See get.
+func (scm *GithubSCM) getWith(ctx context.Context, cred *credential, url string) (*http.Response, error) {
+	return scm.doWith(ctx, cred, http.MethodGet, url, nil)
+}
+
+// Makes a request with the given credential. See get.
+func (scm *GithubSCM) doWith(ctx context.Context, cred *credential, method, url string, body io.Reader) (*http.Response, error) {
+	protocol := "http://"
+	if scm.useRawHTTPS {
+		protocol = "https://"
+	}
 
-		return file.Module.Mod.Path, true, nil
+	request, err := http.NewRequestWithContext(ctx, method, protocol+url, body)
+	if err != nil {
+		return nil, fmt.Errorf("error building raw github API request: %v", err)
+	}
+	token, err := cred.tokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("error getting github token: %v", err)
 	}
+	request.Header.Set("Authorization", fmt.Sprintf("token %s", token.AccessToken))
+	cred.countRawRequest()
 
-	return "", false, nil
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if scm.rest {
+		cred.recordRESTRateLimit(resp.Header)
+	}
+	if err := httpRateLimitError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
 }
@@ -2,15 +2,19 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
 	"github.com/google/go-cmp/cmp"
 	"github.com/shurcooL/githubv4"
 )
@@ -23,9 +27,27 @@ type mockGithubClient struct {
 
 	// stubbed results for queries
 	stubbedResults []any
+
+	// variables of each query made, in order, except go.mod queries
+	gotVariables []map[string]any
+
+	// go.mod file contents served to go.mod queries, keyed by expression, ex
+	// "refs/tags/v1.0.0:go.mod". If nil, go.mod queries fail, so that go.mod
+	// files are fetched from the raw endpoint instead.
+	goMods map[string]string
+
+	// expressions of the go.mod files queried, in order
+	gotGoModExpressions []string
 }
 
+var errGoModsNotStubbed = errors.New("go.mod queries aren't stubbed")
+
 func (m *mockGithubClient) Query(ctx context.Context, query any, variables map[string]any) error {
+	if _, ok := variables["goModExpr0"]; ok {
+		return m.queryGoMods(query, variables)
+	}
+
+	m.gotVariables = append(m.gotVariables, maps.Clone(variables))
 	if len(m.stubbedResults) == 0 {
 		return nil
 	}
@@ -44,10 +66,30 @@ func (m *mockGithubClient) Query(ctx context.Context, query any, variables map[s
 	return nil
 }
 
+// Populates a goModQueryType query from m.goMods.
+func (m *mockGithubClient) queryGoMods(query any, variables map[string]any) error {
+	if m.goMods == nil {
+		return errGoModsNotStubbed
+	}
+	objects := reflect.ValueOf(query).Elem().Field(0)
+	for i := range objects.NumField() {
+		expr := string(variables[fmt.Sprintf("goModExpr%d", i)].(githubv4.String))
+		m.gotGoModExpressions = append(m.gotGoModExpressions, expr)
+		content, ok := m.goMods[expr]
+		if !ok {
+			continue
+		}
+		var object goModQueryObject
+		object.Blob.Text = githubv4.String(content)
+		objects.Field(i).Set(reflect.ValueOf(&object))
+	}
+	return nil
+}
+
 func TestGoRepos_EmptyResponse(t *testing.T) {
 	sut := NewGithubSCM(&mockGithubClient{}, testGithubHostname, "", false)
 	resultsChan := make(chan string)
-	got, err := sut.GoRepos(t.Context())
+	got, _, err := sut.GoRepos(t.Context())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -80,26 +122,31 @@ func TestGoRepos_MultiplePages(t *testing.T) {
 		},
 	}
 
+	pushedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
 	var stubbedResponses []any
-	for _, response := range responses {
+	for i, response := range responses {
 		response := buildRepoQueryResult(t, response.reposURLs, response.endCursor, response.hasNextPage)
+		for j := range response.Search.Edges {
+			response.Search.Edges[j].Node.Repo.PushedAt = githubv4.DateTime{Time: pushedAt.Add(time.Duration(i*3+j) * time.Hour)}
+			response.Search.Edges[j].Node.Repo.Refs.TotalCount = i*3 + j
+		}
 		stubbedResponses = append(stubbedResponses, response)
 	}
 
 	sut := NewGithubSCM(&mockGithubClient{stubbedResults: stubbedResponses}, testGithubHostname, "", false)
 
-	gotResults, err := sut.GoRepos(t.Context())
+	gotResults, _, err := sut.GoRepos(t.Context())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wantResults := []string{
-		"someorg/ftl-proxy",
-		"someorg/cloudgaming-ocgactl",
-		"someorg/cloudgaming-moby-fork",
-		"someorg/cloudgaming-tdd-grafana",
-		"someorg/cloudgaming-game-input-go",
-		"someorg/cpie-proxyd",
+	wantResults := []*Repo{
+		{OrgRepoName: "someorg/ftl-proxy", PushedAt: pushedAt, TagCount: 0},
+		{OrgRepoName: "someorg/cloudgaming-ocgactl", PushedAt: pushedAt.Add(1 * time.Hour), TagCount: 1},
+		{OrgRepoName: "someorg/cloudgaming-moby-fork", PushedAt: pushedAt.Add(2 * time.Hour), TagCount: 2},
+		{OrgRepoName: "someorg/cloudgaming-tdd-grafana", PushedAt: pushedAt.Add(3 * time.Hour), TagCount: 3},
+		{OrgRepoName: "someorg/cloudgaming-game-input-go", PushedAt: pushedAt.Add(4 * time.Hour), TagCount: 4},
+		{OrgRepoName: "someorg/cpie-proxyd", PushedAt: pushedAt.Add(5 * time.Hour), TagCount: 5},
 	}
 
 	if diff := cmp.Diff(wantResults, gotResults); diff != "" {
@@ -107,9 +154,110 @@ func TestGoRepos_MultiplePages(t *testing.T) {
 	}
 }
 
+func TestGoRepos_SplitsSearchOverResultsCap(t *testing.T) {
+	// The first search matches too many repos, so it is split in half. Each
+	// half is then under the cap.
+	tooMany := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/ignored"}, "", true)
+	tooMany.Search.RepositoryCount = searchResultsCap + 1
+	firstHalf := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/repo1"}, "", false)
+	firstHalf.Search.RepositoryCount = 1
+	secondHalf := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/repo2"}, "", false)
+	secondHalf.Search.RepositoryCount = 1
+
+	client := &mockGithubClient{stubbedResults: []any{tooMany, firstHalf, secondHalf}}
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+
+	gotResults, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantResults := []string{"someorg/repo1", "someorg/repo2"}
+	if diff := cmp.Diff(wantResults, orgRepoNames(gotResults)); diff != "" {
+		t.Errorf("unexpected results from repos: -want +got: %s", diff)
+	}
+	if !complete {
+		t.Errorf("expected complete results")
+	}
+
+	if len(client.gotVariables) != 3 {
+		t.Fatalf("expected 3 queries, got %d", len(client.gotVariables))
+	}
+	var gotQueries []string
+	for _, v := range client.gotVariables {
+		gotQueries = append(gotQueries, string(v["query"].(githubv4.String)))
+	}
+	// The two halves must exactly cover the original range without overlap.
+	from, to, ok := strings.Cut(strings.TrimPrefix(gotQueries[0], "language:golang created:"), "..")
+	if !ok {
+		t.Fatalf("unexpected query %q", gotQueries[0])
+	}
+	firstFrom, firstTo, _ := strings.Cut(strings.TrimPrefix(gotQueries[1], "language:golang created:"), "..")
+	secondFrom, secondTo, _ := strings.Cut(strings.TrimPrefix(gotQueries[2], "language:golang created:"), "..")
+	if firstFrom != from || secondTo != to {
+		t.Errorf("halves %q and %q do not cover %q", gotQueries[1], gotQueries[2], gotQueries[0])
+	}
+	firstToTime, err := time.Parse(searchDateFormat, firstTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondFromTime, err := time.Parse(searchDateFormat, secondFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := secondFromTime.Sub(firstToTime), time.Second; got != want {
+		t.Errorf("expected halves to be %v apart, got %v", want, got)
+	}
+}
+
+func TestGoRepos_TruncatedWhenRangeCannotBeSplit(t *testing.T) {
+	client := &mockGithubClient{}
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	page1 := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/repo1"}, "somecursor", true)
+	page1.Search.RepositoryCount = searchResultsCap + 1
+	page2 := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/repo2"}, "", false)
+	page2.Search.RepositoryCount = searchResultsCap + 1
+	client.stubbedResults = []any{page1, page2}
+
+	got, count, truncated, err := sut.goReposCreatedBetween(t.Context(), "", "", now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated {
+		t.Errorf("expected results to be truncated")
+	}
+	if count != searchResultsCap+1 {
+		t.Errorf("expected count %d, got %d", searchResultsCap+1, count)
+	}
+	if diff := cmp.Diff([]string{"someorg/repo1", "someorg/repo2"}, orgRepoNames(got)); diff != "" {
+		t.Errorf("unexpected results from repos: -want +got: %s", diff)
+	}
+}
+
+func TestGoRepos_SplitsTwoSecondRanges(t *testing.T) {
+	client := &mockGithubClient{}
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+
+	// Repos created in different seconds can still be searched apart.
+	now := time.Now().UTC().Truncate(time.Second)
+	tooMany := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/ignored"}, "", false)
+	tooMany.Search.RepositoryCount = searchResultsCap + 1
+	client.stubbedResults = []any{tooMany}
+
+	got, count, truncated, err := sut.goReposCreatedBetween(t.Context(), "", "", now.Add(-time.Second), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(got) != 0 || count != searchResultsCap+1 {
+		t.Errorf("expected no results, for the range to be split, got %d of %d repos (truncated: %v)", len(got), count, truncated)
+	}
+}
+
 func TestTagsForRepo_EmptyResponse(t *testing.T) {
 	sut := NewGithubSCM(&mockGithubClient{}, testGithubHostname, "", false)
-	got, err := sut.TagsForRepo(t.Context(), "someorg/repo1")
+	got, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,18 +275,18 @@ func TestTagsForRepo_MultiplePages(t *testing.T) {
 	}{
 		{
 			tags: []tagResponse{
-				{tag: "_gheMigrationPR-435", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
-				{tag: "_gheMigrationPR-436", committedDate: date},
-				{tag: "_gheMigrationPR-437", committedDate: date},
+				{tag: "v0.0.5", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+				{tag: "v0.0.4", committedDate: date},
+				{tag: "v0.0.3", committedDate: date},
 			},
 			endCursor:   "somecursor",
 			hasNextPage: true,
 		},
 		{
 			tags: []tagResponse{
-				{tag: "_gheMigrationPR-438", committedDate: date},
-				{tag: "_gheMigrationPR-439", committedDate: date, goModContent: "module invalid/module/path"},
-				{tag: "_gheMigrationPR-430", committedDate: date},
+				{tag: "v0.0.2", committedDate: date},
+				{tag: "v0.0.1", committedDate: date, goModContent: "module invalid/module/path"},
+				{tag: "v0.0.0", committedDate: date},
 			},
 		},
 	}
@@ -157,16 +305,17 @@ func TestTagsForRepo_MultiplePages(t *testing.T) {
 	}
 
 	wantTags := []*RepoTag{
-		{Tag: "_gheMigrationPR-435", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1"},
-		{Tag: "_gheMigrationPR-436", TagDate: date, ModulePath: hostPort + "/someorg/repo1"},
-		{Tag: "_gheMigrationPR-437", TagDate: date, ModulePath: hostPort + "/someorg/repo1"},
-		{Tag: "_gheMigrationPR-438", TagDate: date, ModulePath: hostPort + "/someorg/repo1"},
-		// {Tag: "_gheMigrationPR-439"}:  tags with invalid go.mod content are skipped entirely.
-		{Tag: "_gheMigrationPR-430", TagDate: date, ModulePath: hostPort + "/someorg/repo1"},
+		{Tag: "v0.0.5", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Version: "v0.0.5"},
+		{Tag: "v0.0.4", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v0.0.4"},
+		{Tag: "v0.0.3", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v0.0.3"},
+		{Tag: "v0.0.2", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v0.0.2"},
+		// Tags with invalid go.mod content are rejected.
+		{Tag: "v0.0.1", TagDate: date, Rejection: "invalid go.mod: invalid module path found for someorg/repo1 (tag: v0.0.1): malformed module path \"invalid/module/path\": missing dot in first path element"},
+		{Tag: "v0.0.0", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v0.0.0"},
 	}
 
 	sut := NewGithubSCM(&mockGithubClient{stubbedResults: stubbedResponses}, hostPort, authToken, false)
-	gotTags, err := sut.TagsForRepo(t.Context(), "someorg/repo1")
+	gotTags, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -184,9 +333,9 @@ func TestTagsForRepo_HandlesCommitsAndAnnotatedTags(t *testing.T) {
 	}{
 		{
 			tags: []tagResponse{
-				{tag: "_gheMigrationPR-435", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
-				{tag: "_gheMigrationPR-436", taggerDate: date},
-				{tag: "_gheMigrationPR-437", taggerDate: date},
+				{tag: "v0.0.3", committedDate: date, sha: "sha3", goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+				{tag: "v0.0.2", taggerDate: date, sha: "sha2"},
+				{tag: "v0.0.1", taggerDate: date, sha: "sha1"},
 			},
 		},
 	}
@@ -205,20 +354,221 @@ func TestTagsForRepo_HandlesCommitsAndAnnotatedTags(t *testing.T) {
 	}
 
 	wantTags := []*RepoTag{
-		{Tag: "_gheMigrationPR-435", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1"},
-		{Tag: "_gheMigrationPR-436", TagDate: date, ModulePath: hostPort + "/someorg/repo1"},
-		{Tag: "_gheMigrationPR-437", TagDate: date, ModulePath: hostPort + "/someorg/repo1"},
+		{Tag: "v0.0.3", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Version: "v0.0.3", TargetSHA: "sha3"},
+		{Tag: "v0.0.2", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v0.0.2", TargetSHA: "sha2"},
+		{Tag: "v0.0.1", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v0.0.1", TargetSHA: "sha1"},
 	}
 
 	sut := NewGithubSCM(&mockGithubClient{stubbedResults: stubbedResponses}, hostPort, authToken, false)
-	gotTags, err := sut.TagsForRepo(t.Context(), "someorg/repo1")
+	gotTags, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(wantTags, gotTags); diff != "" {
+		t.Errorf("unexpected tags: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepo_ReusesKnownTags(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	tags := []tagResponse{
+		{tag: "v0.0.3", committedDate: date, sha: "new", goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+		{tag: "v0.0.2", committedDate: date, sha: "moved", goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+		{tag: "v0.0.1", committedDate: date, sha: "same", goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+	}
+
+	authToken := "test-token"
+	var gotPaths []string
+	goModServer, hostPort := createTestGoModServer(t, authToken, tags)
+	defer goModServer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPaths = append(gotPaths, r.URL.Path)
+		goModServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	hostPort = strings.TrimPrefix(server.URL, "http://")
+
+	known := map[string]*RepoTag{
+		"v0.0.2": {Tag: "v0.0.2", ModulePath: "old.example.com/repo1", Version: "v0.0.2", TargetSHA: "before"},
+		"v0.0.1": {Tag: "v0.0.1", ModulePath: "old.example.com/repo1", Version: "v0.0.1", TargetSHA: "same"},
+	}
+
+	client := &mockGithubClient{stubbedResults: []any{buildTagQueryResponses(t, tags, "", false)}}
+	sut := NewGithubSCM(client, hostPort, authToken, false)
+	gotTags, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", known, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	wantTags := []*RepoTag{
+		{Tag: "v0.0.3", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Version: "v0.0.3", TargetSHA: "new"},
+		{Tag: "v0.0.2", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Version: "v0.0.2", TargetSHA: "moved"},
+		// Unchanged tags keep their previous results.
+		{Tag: "v0.0.1", TagDate: date, ModulePath: "old.example.com/repo1", Version: "v0.0.1", TargetSHA: "same"},
+	}
 	if diff := cmp.Diff(wantTags, gotTags); diff != "" {
 		t.Errorf("unexpected tags: -want, +got: %s", diff)
 	}
+
+	wantPaths := []string{"/raw/someorg/repo1/v0.0.3/go.mod", "/raw/someorg/repo1/v0.0.2/go.mod"}
+	if diff := cmp.Diff(wantPaths, gotPaths); diff != "" {
+		t.Errorf("unexpected go.mod requests: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepo_Incremental(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	page1 := []tagResponse{
+		{tag: "v0.0.4", committedDate: date, sha: "sha4"},
+		{tag: "v0.0.3", committedDate: date, sha: "sha3"},
+	}
+	page2 := []tagResponse{
+		{tag: "v0.0.2", committedDate: date, sha: "sha2"},
+		{tag: "v0.0.1", committedDate: date, sha: "sha1"},
+	}
+	known := map[string]*RepoTag{
+		"v0.0.3": {Tag: "v0.0.3", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.3", TargetSHA: "sha3"},
+		"v0.0.2": {Tag: "v0.0.2", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.2", TargetSHA: "sha2"},
+		"v0.0.1": {Tag: "v0.0.1", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.1", TargetSHA: "sha1"},
+	}
+
+	for _, tc := range []struct {
+		name         string
+		incremental  bool
+		totalCount   int
+		wantTags     []string
+		wantComplete bool
+	}{
+		{
+			name:         "stops at known tags",
+			incremental:  true,
+			totalCount:   4,
+			wantTags:     []string{"v0.0.4", "v0.0.3"},
+			wantComplete: false,
+		},
+		{
+			name:         "continues when tags were deleted",
+			incremental:  true,
+			totalCount:   3,
+			wantTags:     []string{"v0.0.4", "v0.0.3", "v0.0.2", "v0.0.1"},
+			wantComplete: true,
+		},
+		{
+			name:         "not incremental",
+			totalCount:   4,
+			wantTags:     []string{"v0.0.4", "v0.0.3", "v0.0.2", "v0.0.1"},
+			wantComplete: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, hostPort := createTestGoModServer(t, "", nil)
+			defer server.Close()
+
+			first := buildTagQueryResponses(t, page1, "somecursor", true)
+			first.Repository.Refs.TotalCount = tc.totalCount
+			second := buildTagQueryResponses(t, page2, "", false)
+			second.Repository.Refs.TotalCount = tc.totalCount
+
+			sut := NewGithubSCM(&mockGithubClient{stubbedResults: []any{first, second}}, hostPort, "", false)
+			gotTags, gotComplete, err := sut.TagsForRepo(t.Context(), "someorg/repo1", known, tc.incremental)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotTagNames []string
+			for _, tag := range gotTags {
+				gotTagNames = append(gotTagNames, tag.Tag)
+			}
+			if diff := cmp.Diff(tc.wantTags, gotTagNames); diff != "" {
+				t.Errorf("unexpected tags: -want, +got: %s", diff)
+			}
+			if gotComplete != tc.wantComplete {
+				t.Errorf("wanted complete %v, got %v", tc.wantComplete, gotComplete)
+			}
+		})
+	}
+}
+
+func TestTagsForRepo_RejectsInvalidVersions(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	tags := []tagResponse{
+		{tag: "_gheMigrationPR-435", committedDate: date},
+		{tag: "v1.2", committedDate: date},
+		// No go.mod: served as an +incompatible version.
+		{tag: "v2.0.0", committedDate: date},
+		// go.mod without /v3 suffix: can't be served.
+		{tag: "v3.0.0", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+		{tag: "v4.0.0", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1/v4\n"},
+	}
+
+	authToken := "test-token"
+	server, hostPort := createTestGoModServer(t, authToken, tags)
+	defer server.Close()
+
+	wantTags := []*RepoTag{
+		{Tag: "_gheMigrationPR-435", TagDate: date, Rejection: "_gheMigrationPR-435 is not a semantic version"},
+		{Tag: "v1.2", TagDate: date, Rejection: "v1.2 is not a canonical semantic version (should be v1.2.0)"},
+		{Tag: "v2.0.0", TagDate: date, ModulePath: hostPort + "/someorg/repo1", Version: "v2.0.0+incompatible"},
+		{Tag: "v3.0.0", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Rejection: `go.mod declares module path "stash.someorg.company.com/someorg/repo1", which has no /v3 suffix required by tag v3.0.0`},
+		{Tag: "v4.0.0", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1/v4", Version: "v4.0.0"},
+	}
+
+	stubbedResponses := []any{buildTagQueryResponses(t, tags, "", false)}
+	sut := NewGithubSCM(&mockGithubClient{stubbedResults: stubbedResponses}, hostPort, authToken, false)
+	gotTags, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(wantTags, gotTags); diff != "" {
+		t.Errorf("unexpected tags: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepo_NestedModules(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	tags := []tagResponse{
+		{tag: "v1.0.0", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1\n"},
+		{tag: "tools/cli/v0.4.0", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1/tools/cli\n"},
+		{tag: "api/v2.1.0", committedDate: date, goModContent: "module stash.someorg.company.com/someorg/repo1/api/v2\n"},
+		// No go.mod in the subdirectory: not a module.
+		{tag: "docs/v0.1.0", committedDate: date},
+		{tag: "tools/cli/latest", committedDate: date},
+	}
+
+	authToken := "test-token"
+	server, hostPort := createTestGoModServer(t, authToken, tags)
+	defer server.Close()
+
+	wantTags := []*RepoTag{
+		{Tag: "v1.0.0", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Version: "v1.0.0"},
+		{Tag: "tools/cli/v0.4.0", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1/tools/cli", Version: "v0.4.0"},
+		{Tag: "api/v2.1.0", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1/api/v2", Version: "v2.1.0"},
+		{Tag: "docs/v0.1.0", TagDate: date, Rejection: "no go.mod file in docs"},
+		{Tag: "tools/cli/latest", TagDate: date, Rejection: "latest is not a semantic version"},
+	}
+
+	stubbedResponses := []any{buildTagQueryResponses(t, tags, "", false)}
+	sut := NewGithubSCM(&mockGithubClient{stubbedResults: stubbedResponses}, hostPort, authToken, false)
+	gotTags, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(wantTags, gotTags); diff != "" {
+		t.Errorf("unexpected tags: -want, +got: %s", diff)
+	}
+}
+
+func orgRepoNames(repos []*Repo) []string {
+	var names []string
+	for _, r := range repos {
+		names = append(names, r.OrgRepoName)
+	}
+	return names
 }
 
 func buildRepoQueryResult(t *testing.T, reposURLs []string, endCursor githubv4.String, hasNextPage bool) repoQueryResult {
@@ -249,6 +599,7 @@ type tagResponse struct {
 	goModContent  string
 	committedDate time.Time
 	taggerDate    time.Time
+	sha           string
 }
 
 func buildTagQueryResponses(t *testing.T, tags []tagResponse, endCursor githubv4.String, hasNextPage bool) tagQueryResponse {
@@ -261,9 +612,11 @@ func buildTagQueryResponses(t *testing.T, tags []tagResponse, endCursor githubv4
 		edge.Node.Name = githubv4.String(tag.tag)
 		if !tag.committedDate.IsZero() {
 			edge.Node.Target.Commit.CommittedDate = *githubv4.NewDateTime(githubv4.DateTime{Time: tag.committedDate})
+			edge.Node.Target.Commit.Oid = githubv4.GitObjectID(tag.sha)
 		}
 		if !tag.taggerDate.IsZero() {
 			edge.Node.Target.Tag.Tagger.Date = *githubv4.NewDateTime(githubv4.DateTime{Time: tag.taggerDate})
+			edge.Node.Target.Tag.Target.Oid = githubv4.GitObjectID(tag.sha)
 		}
 		edges = append(edges, edge)
 	}
@@ -285,15 +638,11 @@ func createTestGoModServer(t *testing.T, authToken string, tags []tagResponse) (
 			return
 		}
 
-		urlParts := strings.Split(r.URL.String(), "/")
-		if len(urlParts) < 2 {
-			http.Error(w, fmt.Sprintf("unexpected url format: %s", r.URL.String()), http.StatusBadRequest)
-			return
-		}
-		wantTag := urlParts[len(urlParts)-2]
-
 		for _, tag := range tags {
-			if tag.tag == wantTag && tag.goModContent != "" {
+			// Tags of nested modules, ex "tools/cli/v0.4.0", have their go.mod
+			// file in the tag's prefix directory, ex "tools/cli/go.mod".
+			goModPath := path.Join(path.Dir(tag.tag), "go.mod")
+			if r.URL.Path == fmt.Sprintf("/raw/someorg/repo1/%s/%s", tag.tag, goModPath) && tag.goModContent != "" {
 				if _, err := w.Write([]byte(tag.goModContent)); err != nil {
 					t.Fatal(err)
 				}
@@ -306,3 +655,97 @@ func createTestGoModServer(t *testing.T, authToken string, tags []tagResponse) (
 
 	return server, strings.TrimPrefix(server.URL, "http://")
 }
+
+func TestZipball(t *testing.T) {
+	authToken := "test-token"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != fmt.Sprintf("token %s", authToken) {
+			http.Error(w, "wrong Authorization header", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v3/repos/someorg/repo1/zipball/tools/cli/v0.4.0" {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := w.Write([]byte("zipball contents")); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer server.Close()
+	hostPort := strings.TrimPrefix(server.URL, "http://")
+
+	sut := NewGithubSCM(&mockGithubClient{}, hostPort, authToken, false)
+	got, err := sut.Zipball(t.Context(), "someorg/repo1", "tools/cli/v0.4.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "zipball contents" {
+		t.Errorf("unexpected zipball: %q", got)
+	}
+
+	if _, err := sut.Zipball(t.Context(), "someorg/repo1", "v9.9.9"); err == nil {
+		t.Errorf("expected error for missing zipball")
+	}
+}
+
+func TestTagsForRepo_ExcludedTags(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	tags := buildTagQueryResponses(t, []tagResponse{
+		{tag: "v1.0.0", committedDate: date, sha: "sha1"},
+		{tag: "_gheMigrationPR-435", committedDate: date, sha: "sha2"},
+	}, "", false)
+	client := &mockGithubClient{
+		stubbedResults: []any{tags},
+		goMods:         map[string]string{"refs/tags/v1.0.0:go.mod": "module github.somecompany.net/someorg/repo1\n"},
+	}
+	r, err := rules.Parse([]byte(`{"excludeTags": ["_gheMigrationPR-*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+	sut.SetRules(r)
+	// The excluded tag was indexed before the rule was added.
+	known := map[string]*RepoTag{
+		"_gheMigrationPR-435": {Tag: "_gheMigrationPR-435", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.0", TargetSHA: "sha2"},
+	}
+	got, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", known, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*RepoTag{
+		{Tag: "v1.0.0", TagDate: date, ModulePath: "github.somecompany.net/someorg/repo1", Version: "v1.0.0", TargetSHA: "sha1"},
+		{Tag: "_gheMigrationPR-435", TagDate: date, Rejection: `excluded by tag pattern "_gheMigrationPR-*"`, TargetSHA: "sha2"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected tags: -want, +got: %s", diff)
+	}
+	// No go.mod file is fetched for excluded tags.
+	if diff := cmp.Diff([]string{"refs/tags/v1.0.0:go.mod"}, client.gotGoModExpressions); diff != "" {
+		t.Errorf("unexpected go.mod queries: -want, +got: %s", diff)
+	}
+}
+
+func TestGoRepos_ArchivedAndForks(t *testing.T) {
+	response := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/repo1", "https://github.somecompany.net/someorg/repo2"}, "", false)
+	response.Search.Edges[0].Node.Repo.IsArchived = true
+	response.Search.Edges[1].Node.Repo.IsFork = true
+	response.Search.Edges[1].Node.Repo.ID = "R_kgDOAbc"
+
+	sut := NewGithubSCM(&mockGithubClient{stubbedResults: []any{response}}, testGithubHostname, "", false)
+	got, _, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Excluded or not, they're returned so that they can be removed from the
+	// index.
+	want := []*Repo{
+		{OrgRepoName: "someorg/repo1", Archived: true},
+		{OrgRepoName: "someorg/repo2", Fork: true, NodeID: "R_kgDOAbc"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected repos: -want, +got: %s", diff)
+	}
+}
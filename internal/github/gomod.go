@@ -0,0 +1,118 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+	"github.com/shurcooL/githubv4"
+)
+
+// The expression that names the go.mod file in GraphQL object queries, ex
+// "refs/tags/tools/cli/v0.4.0:tools/cli/go.mod". The tag is named by its full
+// ref, so that a branch with the same name isn't used instead.
+func goModExpression(f vcs.GoModFile) string {
+	return "refs/tags/" + f.Tag + ":" + path.Join(f.Dir, "go.mod")
+}
+
+// The go.mod file, if any, that a goModQueryType field resolves to.
+type goModQueryObject struct {
+	Blob struct {
+		Text        githubv4.String
+		IsBinary    bool
+		IsTruncated bool
+	} `graphql:"... on Blob"`
+}
+
+// Retrieves the contents of the given go.mod files of the given repo, returning
+// a result per file in the same order.
+//
+// All of the files are fetched with a single GraphQL query, through the same
+// client as every other query. Files that can't be fetched that way, for
+// example because they're too large for the GraphQL API to return in full, are
+// fetched from the raw endpoint instead. So are all of them if the GraphQL
+// query fails.
+//
+// Returns a *RateLimitError, rather than per file errors, if GitHub rate limits
+// any of the requests.
+func (scm *GithubSCM) goMods(ctx context.Context, repo repo, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+	results := make([]*vcs.GoModFetch, len(files))
+	if len(files) == 0 {
+		return results, nil
+	}
+	if scm.rest {
+		return scm.rawGoMods(ctx, repo, files)
+	}
+
+	q := reflect.New(goModQueryType(len(files)))
+	variables := map[string]any{
+		"repoOrg":  githubv4.String(repo.org),
+		"repoName": githubv4.String(repo.name),
+	}
+	for i, f := range files {
+		variables[fmt.Sprintf("goModExpr%d", i)] = githubv4.String(goModExpression(f))
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err := scm.query(queryCtx, repo.org, q.Interface(), variables)
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return nil, fmt.Errorf("error querying go.mod files for %s: %w", repo.fullName(), err)
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("error querying go.mod files for %s: %v. Falling back to the raw github API", repo.fullName(), err))
+	}
+
+	objects := q.Elem().Field(0)
+	for i, f := range files {
+		if err == nil {
+			object := objects.Field(i).Interface().(*goModQueryObject)
+			if object == nil {
+				results[i] = &vcs.GoModFetch{}
+				continue
+			}
+			if !object.Blob.IsBinary && !object.Blob.IsTruncated {
+				results[i] = &vcs.GoModFetch{Content: []byte(object.Blob.Text), Found: true}
+				continue
+			}
+		}
+
+		content, found, rawErr := scm.goMod(ctx, repo, f.Tag, f.Dir)
+		if errors.As(rawErr, &rateLimitErr) {
+			return nil, rawErr
+		}
+		results[i] = &vcs.GoModFetch{Content: content, Found: found, Err: rawErr}
+	}
+	return results, nil
+}
+
+// Returns a query struct type that fetches n go.mod files of a repo at once.
+// Field i of its Repository is a *goModQueryObject aliased as gi, whose
+// expression is the goModExpri variable.
+//
+// Like tagBatchQueryType, the type is built at runtime to spell out the
+// aliases.
+func goModQueryType(n int) reflect.Type {
+	var objects []reflect.StructField
+	for i := range n {
+		objects = append(objects, reflect.StructField{
+			Name: fmt.Sprintf("G%d", i),
+			Type: reflect.TypeFor[*goModQueryObject](),
+			Tag:  reflect.StructTag(fmt.Sprintf(`graphql:"g%[1]d: object(expression: $goModExpr%[1]d)"`, i)),
+		})
+	}
+	return reflect.StructOf([]reflect.StructField{
+		{
+			Name: "Repository",
+			Type: reflect.StructOf(objects),
+			Tag:  `graphql:"repository(owner: $repoOrg, name: $repoName)"`,
+		},
+		{Name: "RateLimit", Type: reflect.TypeFor[rateLimitQuery]()},
+	})
+}
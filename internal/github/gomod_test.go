@@ -0,0 +1,90 @@
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestTagsForRepo_GoModsViaGraphQL(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	tags := buildTagQueryResponses(t, []tagResponse{
+		{tag: "v1.0.0", committedDate: date, sha: "sha1"},
+		{tag: "tools/cli/v0.1.0", committedDate: date, sha: "sha2"},
+		{tag: "v0.9.0", committedDate: date, sha: "sha3"},
+		{tag: "not-a-version", committedDate: date, sha: "sha4"},
+	}, "", false)
+	client := &mockGithubClient{
+		stubbedResults: []any{tags},
+		goMods: map[string]string{
+			"refs/tags/v1.0.0:go.mod":                     "module stash.someorg.company.com/someorg/repo1\n",
+			"refs/tags/tools/cli/v0.1.0:tools/cli/go.mod": "module github.somecompany.net/someorg/repo1/tools/cli\n",
+		},
+	}
+
+	// No raw endpoint is stood up: if go.mod files were fetched from it, their
+	// TargetSHA would be cleared.
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+	got, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*RepoTag{
+		{Tag: "v1.0.0", TagDate: date, ModulePath: "stash.someorg.company.com/someorg/repo1", Version: "v1.0.0", TargetSHA: "sha1"},
+		{Tag: "tools/cli/v0.1.0", TagDate: date, ModulePath: "github.somecompany.net/someorg/repo1/tools/cli", Version: "v0.1.0", TargetSHA: "sha2"},
+		{Tag: "v0.9.0", TagDate: date, ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.9.0", TargetSHA: "sha3"},
+		{Tag: "not-a-version", TagDate: date, Rejection: "not-a-version is not a semantic version", TargetSHA: "sha4"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected tags: -want, +got: %s", diff)
+	}
+
+	// All of the page's go.mod files were fetched with one query.
+	wantExpressions := []string{"refs/tags/v1.0.0:go.mod", "refs/tags/tools/cli/v0.1.0:tools/cli/go.mod", "refs/tags/v0.9.0:go.mod"}
+	if diff := cmp.Diff(wantExpressions, client.gotGoModExpressions); diff != "" {
+		t.Errorf("unexpected go.mod queries: -want, +got: %s", diff)
+	}
+}
+
+func TestGoMod_GraphQLQuery(t *testing.T) {
+	// Check the go.mod query against the real GraphQL client, and that go.mod
+	// files too large for the GraphQL API are fetched from the raw endpoint.
+	var gotQuery string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		gotQuery = body.Query
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"repository": {"g0": {"text": "module trunc", "isBinary": false, "isTruncated": true}}}}`))
+	})
+	mux.HandleFunc("/raw/someorg/repo1/v1.0.0/go.mod", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("module stash.someorg.company.com/someorg/repo1\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sut := NewGithubSCM(githubv4.NewEnterpriseClient(server.URL+"/api/graphql", server.Client()), strings.TrimPrefix(server.URL, "http://"), "", false)
+	got, found, err := sut.GoMod(t.Context(), "someorg/repo1", "v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "g0: object(expression: $goModExpr0)"; !strings.Contains(gotQuery, want) {
+		t.Errorf("expected the query to contain %q, got %s", want, gotQuery)
+	}
+	if want := "module stash.someorg.company.com/someorg/repo1\n"; !found || string(got) != want {
+		t.Errorf("expected go.mod %q from the raw endpoint, got %q (found: %v)", want, got, found)
+	}
+}
@@ -0,0 +1,180 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// How long to wait after being rate limited when GitHub doesn't say how long.
+// GitHub asks for at least a minute after secondary rate limits.
+const defaultRateLimitWait = time.Minute
+
+// The rate limit fields requested with every GraphQL query. See
+// https://docs.github.com/en/graphql/overview/rate-limits-and-query-limits-for-the-graphql-api.
+type rateLimitQuery struct {
+	Limit     int
+	Remaining int
+	Cost      int
+	ResetAt   githubv4.DateTime
+}
+
+// Returns the RateLimit field of the given pointer to a GraphQL query struct.
+// Every query struct has one, including the ones built at runtime by
+// tagBatchQueryType, so that the rate limit can be read back after each query.
+func rateLimitOf(q any) *rateLimitQuery {
+	return reflect.ValueOf(q).Elem().FieldByName("RateLimit").Addr().Interface().(*rateLimitQuery)
+}
+
+// The GitHub GraphQL API rate limit, or the REST API one when that is used
+// instead, as of the last request.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	// The cost of the last query.
+	Cost    int
+	ResetAt time.Time
+}
+
+// Returned when GitHub rate limits requests. No requests should be made until
+// ResetAt.
+type RateLimitError struct {
+	ResetAt time.Time
+
+	// Whether a secondary rate limit was hit, ex for making too many requests
+	// concurrently, rather than running out of the hourly budget.
+	Secondary bool
+
+	Err error
+}
+
+func (e *RateLimitError) Error() string {
+	kind := "primary"
+	if e.Secondary {
+		kind = "secondary"
+	}
+	return fmt.Sprintf("github %s rate limit exceeded, resets at %s: %v", kind, e.ResetAt.Format(time.RFC3339), e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error { return e.Err }
+
+// Runs the given GraphQL query with the credential for the given org, keeping
+// track of its rate limit. If GitHub rate limits the query, the credential is
+// benched until the limit resets and the query is retried with another one.
+//
+// Returns a *RateLimitError without querying when every credential that could
+// run the query has used up its budget, or been benched, and hasn't reset yet.
+func (scm *GithubSCM) query(ctx context.Context, org string, q any, variables map[string]any) error {
+	for {
+		cred, err := scm.credentialFor(ctx, org)
+		if err != nil {
+			return err
+		}
+		err = cred.query(ctx, q, variables)
+		var rateLimitErr *RateLimitError
+		if !errors.As(err, &rateLimitErr) {
+			return err
+		}
+		cred.bench(rateLimitErr)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// Runs the given GraphQL query with the credential. See GithubSCM.query.
+func (c *credential) query(ctx context.Context, q any, variables map[string]any) error {
+	rl := c.RateLimit()
+	err := c.graphqlClient.Query(ctx, q, variables)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage.queries++
+	if err != nil {
+		return graphqlRateLimitError(err, rl)
+	}
+
+	got := rateLimitOf(q)
+	if got.ResetAt.IsZero() {
+		// Rate limiting is disabled, ex on some GitHub Enterprise instances.
+		return nil
+	}
+	c.usage.points += got.Cost
+	c.rateLimit = RateLimit{Limit: got.Limit, Remaining: got.Remaining, Cost: got.Cost, ResetAt: got.ResetAt.UTC()}
+	return nil
+}
+
+// Records the REST API rate limit from the headers of a response, in place of
+// the GraphQL one, when the REST API is used instead: see SetREST. Each request
+// costs a point. Search requests have a budget of their own, which isn't
+// recorded.
+func (c *credential) recordRESTRateLimit(h http.Header) {
+	if resource := h.Get("X-RateLimit-Resource"); resource != "" && resource != "core" {
+		return
+	}
+	limit, limitErr := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if limitErr != nil || remainingErr != nil || resetErr != nil {
+		// Rate limiting is disabled, ex on some GitHub Enterprise instances.
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rateLimit = RateLimit{Limit: limit, Remaining: remaining, Cost: 1, ResetAt: time.Unix(reset, 0).UTC()}
+}
+
+// Returns a *RateLimitError wrapping err if err says that a GraphQL query was
+// rate limited, and err otherwise. The GraphQL client doesn't expose response
+// headers, so rate limits are recognised by their message.
+func graphqlRateLimitError(err error, last RateLimit) error {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "secondary rate limit"):
+		return &RateLimitError{ResetAt: time.Now().Add(defaultRateLimitWait), Secondary: true, Err: err}
+	case strings.Contains(msg, "rate limit exceeded") || strings.Contains(msg, "rate_limited"):
+		resetAt := last.ResetAt
+		if !resetAt.After(time.Now()) {
+			resetAt = time.Now().Add(defaultRateLimitWait)
+		}
+		return &RateLimitError{ResetAt: resetAt, Err: err}
+	}
+	return err
+}
+
+// Returns a *RateLimitError if the given response to a raw HTTP request says
+// that the request was rate limited, and nil otherwise. See
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api#exceeding-the-rate-limit.
+func httpRateLimitError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	err := fmt.Errorf("status code %d from %s", resp.StatusCode, resp.Request.URL)
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, parseErr := strconv.Atoi(retryAfter); parseErr == nil {
+			return &RateLimitError{ResetAt: time.Now().Add(time.Duration(seconds) * time.Second), Secondary: true, Err: err}
+		}
+		if date, parseErr := http.ParseTime(retryAfter); parseErr == nil {
+			return &RateLimitError{ResetAt: date, Secondary: true, Err: err}
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		resetAt := time.Now().Add(defaultRateLimitWait)
+		if reset, parseErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); parseErr == nil {
+			resetAt = time.Unix(reset, 0)
+		}
+		return &RateLimitError{ResetAt: resetAt, Err: err}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{ResetAt: time.Now().Add(defaultRateLimitWait), Secondary: true, Err: err}
+	}
+	// Otherwise, a 403 is a plain permissions error.
+	return nil
+}
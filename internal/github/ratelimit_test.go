@@ -0,0 +1,207 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/shurcooL/githubv4"
+)
+
+type errGithubClient struct {
+	err error
+}
+
+func (c *errGithubClient) Query(ctx context.Context, query any, variables map[string]any) error {
+	return c.err
+}
+
+func TestQuery_TracksRateLimit(t *testing.T) {
+	resetAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	response := buildRepoQueryResult(t, []string{"https://github.somecompany.net/someorg/repo1"}, "", false)
+	response.RateLimit = rateLimitQuery{Limit: 5000, Remaining: 4321, Cost: 1, ResetAt: githubv4.DateTime{Time: resetAt}}
+
+	sut := NewGithubSCM(&mockGithubClient{stubbedResults: []any{response}}, testGithubHostname, "", false)
+	if _, _, err := sut.GoRepos(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := RateLimit{Limit: 5000, Remaining: 4321, Cost: 1, ResetAt: resetAt}
+	if diff := cmp.Diff(want, sut.RateLimit()); diff != "" {
+		t.Errorf("unexpected rate limit: -want, +got: %s", diff)
+	}
+}
+
+func TestQuery_WaitsForReset(t *testing.T) {
+	client := &mockGithubClient{}
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+	resetAt := time.Now().Add(time.Hour)
+	sut.pool[0].rateLimit = RateLimit{Limit: 5000, Remaining: 0, Cost: 1, ResetAt: resetAt}
+
+	_, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a *RateLimitError, got %v", err)
+	}
+	if !rateLimitErr.ResetAt.Equal(resetAt) {
+		t.Errorf("expected reset at %v, got %v", resetAt, rateLimitErr.ResetAt)
+	}
+	if len(client.gotVariables) != 0 {
+		t.Errorf("expected no queries until the rate limit resets, got %d", len(client.gotVariables))
+	}
+
+	// Once reset, queries are made again.
+	sut.pool[0].rateLimit.ResetAt = time.Now().Add(-time.Second)
+	if _, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.gotVariables) != 1 {
+		t.Errorf("expected 1 query, got %d", len(client.gotVariables))
+	}
+}
+
+func TestQuery_RateLimitErrors(t *testing.T) {
+	resetAt := time.Now().Add(30 * time.Minute)
+	for _, tc := range []struct {
+		name          string
+		err           error
+		wantRateLimit bool
+		wantSecondary bool
+		wantResetAt   time.Time
+	}{
+		{
+			name:          "primary",
+			err:           errors.New("API rate limit exceeded for user ID 1."),
+			wantRateLimit: true,
+			wantResetAt:   resetAt,
+		},
+		{
+			name:          "secondary",
+			err:           errors.New(`non-200 OK status code: 403 Forbidden body: "{\"message\":\"You have exceeded a secondary rate limit. Please wait a few minutes before you try again.\"}"`),
+			wantRateLimit: true,
+			wantSecondary: true,
+			wantResetAt:   time.Now().Add(defaultRateLimitWait),
+		},
+		{
+			name: "other",
+			err:  errors.New("Could not resolve to a Repository with the name 'someorg/repo1'."),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := NewGithubSCM(&errGithubClient{err: tc.err}, testGithubHostname, "", false)
+			sut.pool[0].rateLimit = RateLimit{Limit: 5000, Remaining: 10, Cost: 1, ResetAt: resetAt}
+
+			_, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var rateLimitErr *RateLimitError
+			if got := errors.As(err, &rateLimitErr); got != tc.wantRateLimit {
+				t.Fatalf("expected rate limit error %v, got %v", tc.wantRateLimit, err)
+			}
+			if !tc.wantRateLimit {
+				return
+			}
+			if rateLimitErr.Secondary != tc.wantSecondary {
+				t.Errorf("expected secondary %v, got %v", tc.wantSecondary, rateLimitErr.Secondary)
+			}
+			if diff := cmp.Diff(tc.wantResetAt, rateLimitErr.ResetAt, cmpopts.EquateApproxTime(5*time.Second)); diff != "" {
+				t.Errorf("unexpected reset time: -want, +got: %s", diff)
+			}
+		})
+	}
+}
+
+func TestGet_RateLimitErrors(t *testing.T) {
+	reset := time.Now().Add(20 * time.Minute).Truncate(time.Second)
+	for _, tc := range []struct {
+		name          string
+		statusCode    int
+		headers       map[string]string
+		wantRateLimit bool
+		wantSecondary bool
+		wantResetAt   time.Time
+	}{
+		{
+			name:          "retry after",
+			statusCode:    http.StatusForbidden,
+			headers:       map[string]string{"Retry-After": "120"},
+			wantRateLimit: true,
+			wantSecondary: true,
+			wantResetAt:   time.Now().Add(2 * time.Minute),
+		},
+		{
+			name:          "primary rate limit",
+			statusCode:    http.StatusForbidden,
+			headers:       map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			wantRateLimit: true,
+			wantResetAt:   reset,
+		},
+		{
+			name:          "too many requests",
+			statusCode:    http.StatusTooManyRequests,
+			wantRateLimit: true,
+			wantSecondary: true,
+			wantResetAt:   time.Now().Add(defaultRateLimitWait),
+		},
+		{
+			name:       "forbidden",
+			statusCode: http.StatusForbidden,
+			headers:    map[string]string{"X-RateLimit-Remaining": "4000"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tc.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer server.Close()
+
+			sut := NewGithubSCM(&mockGithubClient{}, strings.TrimPrefix(server.URL, "http://"), "", false)
+			_, _, err := sut.GoMod(t.Context(), "someorg/repo1", "v1.0.0", "")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var rateLimitErr *RateLimitError
+			if got := errors.As(err, &rateLimitErr); got != tc.wantRateLimit {
+				t.Fatalf("expected rate limit error %v, got %v", tc.wantRateLimit, err)
+			}
+			if !tc.wantRateLimit {
+				return
+			}
+			if rateLimitErr.Secondary != tc.wantSecondary {
+				t.Errorf("expected secondary %v, got %v", tc.wantSecondary, rateLimitErr.Secondary)
+			}
+			if diff := cmp.Diff(tc.wantResetAt, rateLimitErr.ResetAt, cmpopts.EquateApproxTime(5*time.Second)); diff != "" {
+				t.Errorf("unexpected reset time: -want, +got: %s", diff)
+			}
+		})
+	}
+}
+
+func TestTagsForRepo_StopsOnRawRateLimit(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	tags := buildTagQueryResponses(t, []tagResponse{{tag: "v0.0.1", committedDate: date}}, "", false)
+	sut := NewGithubSCM(&mockGithubClient{stubbedResults: []any{tags}}, strings.TrimPrefix(server.URL, "http://"), "", false)
+
+	_, _, err := sut.TagsForRepo(t.Context(), "someorg/repo1", nil, false)
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a *RateLimitError rather than guessing module paths, got %v", err)
+	}
+}
@@ -24,6 +24,7 @@ func (r repo) fullName() string {
 	return fmt.Sprintf("%s/%s", r.org, r.name)
 }
 
+// Returns the module path implied by the repo URL.
 func (r repo) asModulePath() string {
 	return fmt.Sprintf("%s/%s/%s", r.host, r.org, r.name)
 }
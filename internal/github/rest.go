@@ -0,0 +1,437 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+)
+
+// Makes GoRepos, TagsForRepos and GoMod use the REST API rather than the
+// GraphQL API, ex for GitHub Enterprise instances that are too old for the
+// GraphQL queries, or behind proxies that block /api/graphql. Off by default.
+//
+// The REST API takes many more requests to do the same: a page per 100 tags,
+// and a request to date each new tag. Search results don't count repos' tags,
+// so new tags are only noticed by their repo's PushedAt.
+func (scm *GithubSCM) SetREST(enabled bool) {
+	scm.rest = enabled
+}
+
+// Checks whether the GraphQL API can be queried and, if it's unavailable but
+// the REST API can be queried, switches to the REST API: see SetREST. Returns
+// an error if the GraphQL API can't be queried but isn't known to be
+// unavailable, ex because of a 502 or a timeout, or if neither API can be
+// queried, in which case the GraphQL API is kept.
+func (scm *GithubSCM) DetectAPI(ctx context.Context) error {
+	cred, err := scm.anyCredential(ctx)
+	if err != nil || cred == nil {
+		return err
+	}
+
+	var q struct{ RateLimit rateLimitQuery }
+	graphqlErr := cred.query(ctx, &q, nil)
+	var rateLimitErr *RateLimitError
+	if graphqlErr == nil || errors.As(graphqlErr, &rateLimitErr) {
+		return nil
+	}
+
+	// The REST API takes many more requests, and nothing switches back, so only
+	// an endpoint that's missing or that a proxy blocks counts as unavailable.
+	// Other failures may be transient.
+	status, err := scm.graphqlStatus(ctx, cred)
+	if errors.As(err, &rateLimitErr) {
+		return nil
+	}
+	if err != nil || !graphqlUnavailable(status) {
+		return fmt.Errorf("DetectAPI: the GraphQL API can't be queried, but isn't known to be unavailable: %v", graphqlErr)
+	}
+
+	resp, err := scm.getWith(ctx, cred, scm.githubHostName+"/api/v3/meta")
+	if errors.As(err, &rateLimitErr) {
+		err = nil
+	} else if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("DetectAPI: neither the GraphQL API (%v) nor the REST API (%v) can be queried", graphqlErr, err)
+	}
+	slog.Warn(fmt.Sprintf("the github GraphQL API is unavailable (status code %d): %v. Using the REST API instead", status, graphqlErr))
+	scm.SetREST(true)
+	return nil
+}
+
+// Returns the status code of a GraphQL query made directly, since the GraphQL
+// client's errors don't have it.
+func (scm *GithubSCM) graphqlStatus(ctx context.Context, cred *credential) (int, error) {
+	resp, err := scm.doWith(ctx, cred, http.MethodPost, scm.githubHostName+"/api/graphql", strings.NewReader(`{"query": "{ rateLimit { remaining } }"}`))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Whether a status code of /api/graphql shows that the GraphQL API is
+// unavailable: instances too old for it don't have it, and proxies that block
+// it forbid it.
+func graphqlUnavailable(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusForbidden
+}
+
+// Returns a credential to probe the APIs with, or nil if there's none, ex
+// because the GitHub App isn't installed anywhere yet.
+func (scm *GithubSCM) anyCredential(ctx context.Context) (*credential, error) {
+	if scm.app == nil {
+		if len(scm.pool) == 0 {
+			return nil, nil
+		}
+		return scm.pool[0], nil
+	}
+	if err := scm.refreshInstallations(ctx); err != nil {
+		return nil, err
+	}
+	creds := scm.credentials()
+	if len(creds) == 0 {
+		return nil, nil
+	}
+	return creds[slices.Min(slices.Collect(maps.Keys(creds)))], nil
+}
+
+// Returned by restGet for 404 responses, and for the 409 responses that GitHub
+// sends about the contents of empty repos.
+var errNotFound = errors.New("not found")
+
+// Makes a GET request to the given path of the REST API, ex "/repos/o/r/tags",
+// with the credential for the given org, and decodes the JSON response into
+// out. apiURL may instead be a whole URL, ex the next page from a Link header.
+// Returns the URL of the next page, if any.
+func (scm *GithubSCM) restGet(ctx context.Context, org, apiURL string, out any) (next string, _ error) {
+	if _, rest, ok := strings.Cut(apiURL, "://"); ok {
+		apiURL = rest
+	} else {
+		apiURL = scm.githubHostName + "/api/v3" + apiURL
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := scm.get(queryCtx, org, apiURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict {
+		return "", fmt.Errorf("%s: %w", apiURL, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, apiURL)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response from %s: %v", apiURL, err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return "", fmt.Errorf("error unmarshalling response from %s: %v", apiURL, err)
+	}
+	if m := nextLinkRegexp.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	}
+	return next, nil
+}
+
+// Gets every page of the given REST API list. See restGet.
+func restList[T any](ctx context.Context, scm *GithubSCM, org, apiURL string) ([]T, error) {
+	var results []T
+	for apiURL != "" {
+		var page []T
+		var err error
+		if apiURL, err = scm.restGet(ctx, org, apiURL, &page); err != nil {
+			return nil, err
+		}
+		results = append(results, page...)
+	}
+	return results, nil
+}
+
+// A repo, as the REST API describes it.
+type restRepo struct {
+	NodeID        string    `json:"node_id"`
+	FullName      string    `json:"full_name"`
+	PushedAt      time.Time `json:"pushed_at"`
+	Archived      bool      `json:"archived"`
+	Fork          bool      `json:"fork"`
+	DefaultBranch string    `json:"default_branch"`
+}
+
+func (r *restRepo) repo() *Repo {
+	return &Repo{
+		OrgRepoName: r.FullName,
+		PushedAt:    r.PushedAt.UTC(),
+		Archived:    r.Archived,
+		Fork:        r.Fork,
+		NodeID:      r.NodeID,
+	}
+}
+
+// Retrieves golang repos created in the inclusive range [from, to] with the
+// REST search API. See goReposCreatedBetween.
+func (scm *GithubSCM) restGoReposCreatedBetween(ctx context.Context, org, qualifier string, from, to time.Time) (_ []*Repo, count int, truncated bool, _ error) {
+	var results []*Repo
+	query := fmt.Sprintf("language:golang created:%s..%s", from.Format(searchDateFormat), to.Format(searchDateFormat))
+	if qualifier != "" {
+		query = qualifier + " " + query
+	}
+
+	apiURL := "/search/repositories?per_page=100&q=" + url.QueryEscape(query)
+	for firstPage := true; apiURL != ""; firstPage = false {
+		var page struct {
+			TotalCount        int         `json:"total_count"`
+			IncompleteResults bool        `json:"incomplete_results"`
+			Items             []*restRepo `json:"items"`
+		}
+		var err error
+		if apiURL, err = scm.restGet(ctx, org, apiURL, &page); err != nil {
+			return nil, 0, false, fmt.Errorf("error searching repositories: %w", err)
+		}
+
+		if firstPage {
+			count = page.TotalCount
+			if count > searchResultsCap {
+				if to.After(from) {
+					return nil, count, false, nil
+				}
+				truncated = true
+			}
+		}
+		// The search timed out before finding every match.
+		truncated = truncated || page.IncompleteResults
+
+		for _, r := range page.Items {
+			results = append(results, r.repo())
+		}
+	}
+
+	return results, count, truncated, nil
+}
+
+// Retrieves the repos of the given org, or user, that have a go.mod file with
+// the REST API, looking for one in each repo's tree at the head of its default
+// branch. See goReposWithGoMod.
+func (scm *GithubSCM) restGoReposWithGoMod(ctx context.Context, org string) ([]*Repo, error) {
+	repos, err := restList[*restRepo](ctx, scm, org, fmt.Sprintf("/orgs/%s/repos?per_page=100", url.PathEscape(org)))
+	if errors.Is(err, errNotFound) {
+		repos, err = restList[*restRepo](ctx, scm, org, fmt.Sprintf("/users/%s/repos?per_page=100", url.PathEscape(org)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing repositories of %s: %w", org, err)
+	}
+
+	var results []*Repo
+	for _, r := range repos {
+		hasGoMod, err := scm.restHasGoMod(ctx, org, r)
+		if err != nil {
+			return nil, fmt.Errorf("error looking for a go.mod file in %s: %w", r.FullName, err)
+		}
+		if hasGoMod {
+			results = append(results, r.repo())
+		}
+	}
+
+	slog.Info(fmt.Sprintf("found %d repos with a go.mod file among the %d repos of %s", len(results), len(repos), org))
+	return results, nil
+}
+
+// A tree, as the REST API describes it.
+type restTree struct {
+	Tree []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+	} `json:"tree"`
+}
+
+// Whether the repo has a go.mod file at its root, or in one of its immediate
+// subdirectories. Takes a request per subdirectory, unless there's a go.mod file
+// at the root.
+func (scm *GithubSCM) restHasGoMod(ctx context.Context, org string, r *restRepo) (bool, error) {
+	var root restTree
+	if _, err := scm.restGet(ctx, org, fmt.Sprintf("/repos/%s/git/trees/%s", r.FullName, url.PathEscape(r.DefaultBranch)), &root); err != nil {
+		if errors.Is(err, errNotFound) {
+			// An empty repo.
+			return false, nil
+		}
+		return false, err
+	}
+	for _, e := range root.Tree {
+		if e.Type == "blob" && e.Path == "go.mod" {
+			return true, nil
+		}
+	}
+	for _, e := range root.Tree {
+		if e.Type != "tree" {
+			continue
+		}
+		var sub restTree
+		if _, err := scm.restGet(ctx, org, fmt.Sprintf("/repos/%s/git/trees/%s", r.FullName, e.SHA), &sub); err != nil {
+			return false, err
+		}
+		for _, s := range sub.Tree {
+			if s.Type == "blob" && s.Path == "go.mod" {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Retrieves the tags of each of the given repos with the REST API, one by one.
+// See TagsForRepos.
+func (scm *GithubSCM) restTagsForRepos(ctx context.Context, requests []*TagsRequest) ([]*TagsResult, error) {
+	results := make([]*TagsResult, len(requests))
+	for i, r := range requests {
+		results[i] = &TagsResult{OrgRepoName: r.OrgRepoName}
+		repo, err := newRepo(scm.githubHostName, r.OrgRepoName)
+		if err != nil {
+			results[i].Err = fmt.Errorf("TagsForRepos: %v", err)
+			continue
+		}
+		tags, err := scm.restTagsForRepo(ctx, repo, r.Known)
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			return nil, err
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Tags, results[i].Complete = tags, true
+	}
+	return results, nil
+}
+
+// Retrieves all tags for a given repo with the REST API. Every tag is listed,
+// so the results are always complete.
+//
+// Tags in known that still point at the same commit keep their date. The rest
+// are dated like the GraphQL API dates them, which takes a request each:
+// annotated tags by when they were created, and lightweight tags by their
+// commit.
+func (scm *GithubSCM) restTagsForRepo(ctx context.Context, repo repo, known map[string]*RepoTag) ([]*RepoTag, error) {
+	type restTag struct {
+		Name   string `json:"name"`
+		Commit struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
+	}
+	listed, err := restList[restTag](ctx, scm, repo.org, fmt.Sprintf("/repos/%s/tags?per_page=100", repo.fullName()))
+	if err != nil {
+		return nil, fmt.Errorf("error querying tags for %s: %w", repo.fullName(), err)
+	}
+
+	var tags, undated []*RepoTag
+	for _, t := range listed {
+		tag := &RepoTag{Tag: t.Name, TargetSHA: t.Commit.SHA}
+		if k, ok := known[t.Name]; ok && k.TargetSHA == tag.TargetSHA && !k.TagDate.IsZero() {
+			tag.TagDate = k.TagDate
+		} else {
+			undated = append(undated, tag)
+		}
+		tags = append(tags, tag)
+	}
+	if err := scm.restDateTags(ctx, repo, undated); err != nil {
+		return nil, err
+	}
+
+	_, _, err = vcs.ResolveTags(ctx, repo.fullName(), repo.asModulePath(), tags, known, scm.rules, func(ctx context.Context, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+		return scm.goMods(ctx, repo, files)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Sets the date of each of the given tags of the repo, from its tag object if
+// it's annotated, or else from its commit.
+func (scm *GithubSCM) restDateTags(ctx context.Context, repo repo, tags []*RepoTag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	type restRef struct {
+		Ref    string `json:"ref"`
+		Object struct {
+			SHA  string `json:"sha"`
+			Type string `json:"type"`
+		} `json:"object"`
+	}
+	refs, err := restList[restRef](ctx, scm, repo.org, fmt.Sprintf("/repos/%s/git/refs/tags?per_page=100", repo.fullName()))
+	if err != nil {
+		return fmt.Errorf("error querying tag refs for %s: %w", repo.fullName(), err)
+	}
+	byName := make(map[string]restRef)
+	for _, r := range refs {
+		byName[strings.TrimPrefix(r.Ref, "refs/tags/")] = r
+	}
+
+	// Lightweight tags often share a commit.
+	commitDates := make(map[string]time.Time)
+	for _, tag := range tags {
+		if ref := byName[tag.Tag]; ref.Object.Type == "tag" {
+			var tagObject struct {
+				Tagger struct {
+					Date time.Time `json:"date"`
+				} `json:"tagger"`
+			}
+			if _, err := scm.restGet(ctx, repo.org, fmt.Sprintf("/repos/%s/git/tags/%s", repo.fullName(), ref.Object.SHA), &tagObject); err != nil {
+				return fmt.Errorf("error querying tag %s of %s: %w", tag.Tag, repo.fullName(), err)
+			}
+			tag.TagDate = tagObject.Tagger.Date.UTC()
+			continue
+		}
+
+		date, ok := commitDates[tag.TargetSHA]
+		if !ok {
+			var commit struct {
+				Committer struct {
+					Date time.Time `json:"date"`
+				} `json:"committer"`
+			}
+			if _, err := scm.restGet(ctx, repo.org, fmt.Sprintf("/repos/%s/git/commits/%s", repo.fullName(), tag.TargetSHA), &commit); err != nil {
+				return fmt.Errorf("error querying commit of tag %s of %s: %w", tag.Tag, repo.fullName(), err)
+			}
+			date = commit.Committer.Date.UTC()
+			commitDates[tag.TargetSHA] = date
+		}
+		tag.TagDate = date
+	}
+	return nil
+}
+
+// Retrieves the given go.mod files of the given repo from the raw endpoint, one
+// by one. See goMods.
+func (scm *GithubSCM) rawGoMods(ctx context.Context, repo repo, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+	results := make([]*vcs.GoModFetch, len(files))
+	for i, f := range files {
+		content, found, err := scm.goMod(ctx, repo, f.Tag, f.Dir)
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			return nil, err
+		}
+		results[i] = &vcs.GoModFetch{Content: content, Found: found, Err: err}
+	}
+	return results, nil
+}
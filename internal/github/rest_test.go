@@ -0,0 +1,276 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Stands up a GitHub host whose REST API serves someorg, with repos:
+//
+//   - repo1, found by the language search, with tags v1.0.0 and v1.0 at
+//     commit c1, on two pages, and v1.1.0 annotated at commit c2.
+//   - repo2, with a go.mod file in its tools directory.
+//   - repo3, with no go.mod file, and repo4, which is empty.
+//
+// /api/graphql is blocked unless graphql is true. Records the path and query
+// of each request.
+type fakeRESTHost struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	gotPaths []string
+}
+
+func newFakeRESTHost(t *testing.T, graphql bool) *fakeRESTHost {
+	h := &fakeRESTHost{t: t}
+	mux := http.NewServeMux()
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.gotPaths = append(h.gotPaths, r.URL.RequestURI())
+		h.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(h.server.Close)
+
+	serve := func(pattern, body string) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-RateLimit-Limit", "5000")
+			w.Header().Set("X-RateLimit-Remaining", "4999")
+			w.Header().Set("X-RateLimit-Reset", "1767225600")
+			fmt.Fprint(w, body)
+		})
+	}
+	mux.HandleFunc("POST /api/graphql", func(w http.ResponseWriter, r *http.Request) {
+		if !graphql {
+			http.Error(w, "blocked", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data": {"rateLimit": {"limit": 5000, "remaining": 4999, "cost": 1, "resetAt": "2026-01-01T00:00:00Z"}}}`)
+	})
+	serve("GET /api/v3/meta", `{}`)
+
+	serve("GET /api/v3/search/repositories", `{"total_count": 1, "incomplete_results": false, "items": [
+		{"node_id": "R_1", "full_name": "someorg/repo1", "pushed_at": "2025-03-01T00:00:00Z", "default_branch": "main"}
+	]}`)
+	mux.HandleFunc("GET /api/v3/orgs/someorg/repos", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/orgs/someorg/repos?per_page=100&page=2>; rel="next"`, h.server.URL))
+			fmt.Fprint(w, `[
+				{"node_id": "R_1", "full_name": "someorg/repo1", "pushed_at": "2025-03-01T00:00:00Z", "default_branch": "main"},
+				{"node_id": "R_2", "full_name": "someorg/repo2", "pushed_at": "2025-03-02T00:00:00Z", "default_branch": "main", "fork": true}
+			]`)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"node_id": "R_3", "full_name": "someorg/repo3", "pushed_at": "2025-03-03T00:00:00Z", "default_branch": "main"},
+			{"node_id": "R_4", "full_name": "someorg/repo4", "pushed_at": null, "default_branch": "main"}
+		]`)
+	})
+	serve("GET /api/v3/repos/someorg/repo1/git/trees/main", `{"tree": [{"path": "go.mod", "type": "blob", "sha": "b1"}]}`)
+	serve("GET /api/v3/repos/someorg/repo2/git/trees/main", `{"tree": [{"path": "api.proto", "type": "blob", "sha": "b2"}, {"path": "tools", "type": "tree", "sha": "t2"}]}`)
+	serve("GET /api/v3/repos/someorg/repo2/git/trees/t2", `{"tree": [{"path": "go.mod", "type": "blob", "sha": "b3"}]}`)
+	serve("GET /api/v3/repos/someorg/repo3/git/trees/main", `{"tree": [{"path": "main.tf", "type": "blob", "sha": "b4"}, {"path": "go.mod", "type": "tree", "sha": "t3"}]}`)
+	serve("GET /api/v3/repos/someorg/repo3/git/trees/t3", `{"tree": []}`)
+	mux.HandleFunc("GET /api/v3/repos/someorg/repo4/git/trees/main", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Git Repository is empty."}`, http.StatusConflict)
+	})
+
+	mux.HandleFunc("GET /api/v3/repos/someorg/repo1/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/repos/someorg/repo1/tags?per_page=100&page=2>; rel="next"`, h.server.URL))
+			fmt.Fprint(w, `[{"name": "v1.1.0", "commit": {"sha": "c2"}}, {"name": "v1.0.0", "commit": {"sha": "c1"}}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"name": "v1.0", "commit": {"sha": "c1"}}]`)
+	})
+	serve("GET /api/v3/repos/someorg/repo1/git/refs/tags", `[
+		{"ref": "refs/tags/v1.0", "object": {"sha": "c1", "type": "commit"}},
+		{"ref": "refs/tags/v1.0.0", "object": {"sha": "c1", "type": "commit"}},
+		{"ref": "refs/tags/v1.1.0", "object": {"sha": "t1", "type": "tag"}}
+	]`)
+	serve("GET /api/v3/repos/someorg/repo1/git/tags/t1", `{"tagger": {"date": "2025-02-01T00:00:00Z"}}`)
+	serve("GET /api/v3/repos/someorg/repo1/git/commits/c1", `{"committer": {"date": "2025-01-01T00:00:00Z"}}`)
+	mux.HandleFunc("GET /raw/someorg/repo1/{tag}/go.mod", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "module github.somecompany.net/someorg/repo1\n")
+	})
+	return h
+}
+
+func (h *fakeRESTHost) hostName() string {
+	return strings.TrimPrefix(h.server.URL, "http://")
+}
+
+// Returns the requests made since the last call.
+func (h *fakeRESTHost) requests() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	got := h.gotPaths
+	h.gotPaths = nil
+	return got
+}
+
+func TestDetectAPI(t *testing.T) {
+	for _, graphql := range []bool{true, false} {
+		t.Run(map[bool]string{true: "graphql", false: "rest"}[graphql], func(t *testing.T) {
+			h := newFakeRESTHost(t, graphql)
+			sut := NewGithubTokenPoolSCM(h.hostName(), []string{"token"}, false)
+
+			if err := sut.DetectAPI(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+			if sut.rest == graphql {
+				t.Errorf("DetectAPI: expected REST to be %v, got %v", !graphql, sut.rest)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name        string
+		graphqlCode int
+		wantREST    bool
+	}{
+		{name: "missing", graphqlCode: http.StatusNotFound, wantREST: true},
+		{name: "bad gateway", graphqlCode: http.StatusBadGateway},
+		{name: "unavailable", graphqlCode: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/graphql", func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, http.StatusText(tc.graphqlCode), tc.graphqlCode)
+			})
+			mux.HandleFunc("GET /api/v3/meta", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{}`)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			sut := NewGithubTokenPoolSCM(strings.TrimPrefix(server.URL, "http://"), []string{"token"}, false)
+
+			// Only a GraphQL API that's known to be unavailable is given up
+			// on: other failures may be transient.
+			err := sut.DetectAPI(t.Context())
+			if tc.wantREST != (err == nil) {
+				t.Errorf("DetectAPI: expected an error: %v, got %v", !tc.wantREST, err)
+			}
+			if sut.rest != tc.wantREST {
+				t.Errorf("DetectAPI: expected REST to be %v, got %v", tc.wantREST, sut.rest)
+			}
+		})
+	}
+
+	t.Run("neither", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+		sut := NewGithubTokenPoolSCM(strings.TrimPrefix(server.URL, "http://"), []string{"token"}, false)
+
+		if err := sut.DetectAPI(t.Context()); err == nil {
+			t.Errorf("DetectAPI: expected an error")
+		}
+		if sut.rest {
+			t.Errorf("DetectAPI: expected to keep the GraphQL API")
+		}
+	})
+}
+
+func TestGoRepos_REST(t *testing.T) {
+	h := newFakeRESTHost(t, false)
+	sut := NewGithubTokenPoolSCM(h.hostName(), []string{"token"}, false)
+	r, err := rules.Parse([]byte(`{"allowOrgs": ["someorg"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut.SetRules(r)
+	sut.SetREST(true)
+	sut.SetGoModDiscovery(true)
+
+	got, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+
+	// repo1 is found both ways, but returned once. repo3's go.mod is a
+	// directory, and repo4 is empty.
+	want := []*Repo{
+		{OrgRepoName: "someorg/repo1", PushedAt: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), NodeID: "R_1"},
+		{OrgRepoName: "someorg/repo2", PushedAt: time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC), Fork: true, NodeID: "R_2"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+	for _, p := range h.requests() {
+		if strings.HasPrefix(p, "/api/graphql") {
+			t.Errorf("GoRepos: unexpected GraphQL query")
+		}
+	}
+
+	// The REST API's rate limit is tracked instead.
+	if rl := sut.RateLimit(); rl.Limit != 5000 || rl.Remaining != 4999 {
+		t.Errorf("RateLimit: expected the REST API's, got %+v", rl)
+	}
+}
+
+func TestTagsForRepos_REST(t *testing.T) {
+	h := newFakeRESTHost(t, false)
+	sut := NewGithubTokenPoolSCM(h.hostName(), []string{"token"}, false)
+	sut.SetREST(true)
+
+	got, err := sut.TagsForRepos(t.Context(), []*TagsRequest{{OrgRepoName: "someorg/repo1"}, {OrgRepoName: "someorg/missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("TagsForRepos: expected 2 results, got %d", len(got))
+	}
+	if got[0].Err != nil {
+		t.Fatal(got[0].Err)
+	}
+	jan1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := &TagsResult{OrgRepoName: "someorg/repo1", Complete: true, Tags: []*RepoTag{
+		// Annotated tags are dated by when they were created.
+		{Tag: "v1.1.0", TagDate: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), ModulePath: "github.somecompany.net/someorg/repo1", Version: "v1.1.0", TargetSHA: "c2"},
+		{Tag: "v1.0.0", TagDate: jan1, ModulePath: "github.somecompany.net/someorg/repo1", Version: "v1.0.0", TargetSHA: "c1"},
+		{Tag: "v1.0", TagDate: jan1, Rejection: "v1.0 is not a canonical semantic version (should be v1.0.0)", TargetSHA: "c1"},
+	}}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+	if got[1].Err == nil {
+		t.Errorf("TagsForRepos: expected an error for someorg/missing")
+	}
+	// c1 is only dated once.
+	if n := strings.Count(strings.Join(h.requests(), "\n"), "/git/commits/c1"); n != 1 {
+		t.Errorf("TagsForRepos: expected c1 to be requested once, got %d", n)
+	}
+
+	// Known tags are neither dated nor resolved again.
+	known := make(map[string]*RepoTag)
+	for _, tag := range got[0].Tags {
+		known[tag.Tag] = tag
+	}
+	again, err := sut.TagsForRepos(t.Context(), []*TagsRequest{{OrgRepoName: "someorg/repo1", Known: known}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, again[0]); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+	wantRequests := []string{"/api/v3/repos/someorg/repo1/tags?per_page=100", "/api/v3/repos/someorg/repo1/tags?per_page=100&page=2"}
+	if diff := cmp.Diff(wantRequests, h.requests()); diff != "" {
+		t.Errorf("unexpected requests: -want, +got: %s", diff)
+	}
+}
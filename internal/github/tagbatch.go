@@ -0,0 +1,132 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// Retrieves the tags of each of the given repos, like TagsForRepo, returning a
+// result per request in the same order.
+//
+// The first page of tags of every repo is fetched with a single query, which
+// costs about as much as querying one repo. Only repos with more tags are then
+// paged through one by one. If the batched query fails, for example because
+// one of the repos doesn't exist, each repo is fetched on its own instead, so
+// that only the repos that fail get an Err. When authenticating as a GitHub
+// App, there's a batched query per installation.
+//
+// A *RateLimitError is returned as is rather than per repo: none of the repos
+// should be retried until the rate limit resets.
+func (scm *GithubSCM) TagsForRepos(ctx context.Context, requests []*TagsRequest) ([]*TagsResult, error) {
+	if scm.rest {
+		return scm.restTagsForRepos(ctx, requests)
+	}
+
+	results := make([]*TagsResult, len(requests))
+	// The repos to fetch with each group of credentials, in order, and the
+	// index in results of each.
+	var groups []string
+	fetches := make(map[string][]*tagsFetch)
+	fetchIndexes := make(map[*tagsFetch]int)
+	for i, r := range requests {
+		results[i] = &TagsResult{OrgRepoName: r.OrgRepoName}
+		repo, err := newRepo(scm.githubHostName, r.OrgRepoName)
+		if err != nil {
+			results[i].Err = fmt.Errorf("TagsForRepos: %v", err)
+			continue
+		}
+		if _, err := scm.credentialFor(ctx, repo.org); err != nil {
+			var rateLimitErr *RateLimitError
+			if errors.As(err, &rateLimitErr) {
+				return nil, err
+			}
+			results[i].Err = fmt.Errorf("TagsForRepos: %w", err)
+			continue
+		}
+		group := scm.credentialGroup(repo.org)
+		if _, ok := fetches[group]; !ok {
+			groups = append(groups, group)
+		}
+		f := &tagsFetch{repo: repo, known: r.Known, incremental: r.Incremental}
+		fetches[group] = append(fetches[group], f)
+		fetchIndexes[f] = i
+	}
+
+	for _, group := range groups {
+		if err := scm.fetchTagsBatch(ctx, fetches[group]); err != nil {
+			return nil, err
+		}
+		for _, f := range fetches[group] {
+			result := results[fetchIndexes[f]]
+			if f.err != nil {
+				result.Err = f.err
+				continue
+			}
+			result.Tags, result.Complete = f.results, f.complete
+		}
+	}
+	return results, nil
+}
+
+// Fetches the tags of the given repos, which must share a credential group,
+// setting the err of each fetch that fails. See TagsForRepos.
+func (scm *GithubSCM) fetchTagsBatch(ctx context.Context, fetches []*tagsFetch) error {
+	q := reflect.New(tagBatchQueryType(len(fetches)))
+	variables := map[string]any{"tagsCursor": (*githubv4.String)(nil)}
+	for i, f := range fetches {
+		variables[fmt.Sprintf("repoOrg%d", i)] = githubv4.String(f.repo.org)
+		variables[fmt.Sprintf("repoName%d", i)] = githubv4.String(f.repo.name)
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	batchErr := scm.query(queryCtx, fetches[0].repo.org, q.Interface(), variables)
+	var rateLimitErr *RateLimitError
+	if errors.As(batchErr, &rateLimitErr) {
+		return fmt.Errorf("error querying tags for %d repos: %w", len(fetches), batchErr)
+	}
+	if batchErr != nil {
+		slog.Warn(fmt.Sprintf("error querying tags for %d repos at once: %v. Querying them one by one", len(fetches), batchErr))
+	}
+
+	for i, f := range fetches {
+		if batchErr != nil {
+			f.err = scm.fetchTagPages(ctx, f, nil)
+		} else {
+			refs := &q.Elem().Field(i).Addr().Interface().(*tagQueryRepository).Refs
+			var more bool
+			if more, f.err = scm.addTagPage(ctx, f, refs); f.err == nil && more {
+				f.err = scm.fetchTagPages(ctx, f, githubv4.NewString(refs.PageInfo.EndCursor))
+			}
+		}
+		if errors.As(f.err, &rateLimitErr) {
+			return f.err
+		}
+	}
+	return nil
+}
+
+// Returns a query struct type that queries the first page of tags of n repos at
+// once. Field i is a tagQueryRepository aliased as ri, whose owner and name are
+// the repoOrgi and repoNamei variables. The last field is the RateLimit.
+//
+// GraphQL aliases have to be spelled out in struct tags, so the type is built
+// at runtime. reflect.StructOf returns the same type for the same n.
+func tagBatchQueryType(n int) reflect.Type {
+	var fields []reflect.StructField
+	for i := range n {
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("R%d", i),
+			Type: reflect.TypeFor[tagQueryRepository](),
+			Tag:  reflect.StructTag(fmt.Sprintf(`graphql:"r%[1]d: repository(owner: $repoOrg%[1]d, name: $repoName%[1]d)"`, i)),
+		})
+	}
+	fields = append(fields, reflect.StructField{Name: "RateLimit", Type: reflect.TypeFor[rateLimitQuery]()})
+	return reflect.StructOf(fields)
+}
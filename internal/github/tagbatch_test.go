@@ -0,0 +1,164 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shurcooL/githubv4"
+)
+
+// Stubs the response to a batched tags query for the given repos' first pages.
+func buildTagBatchQueryResponse(t *testing.T, pages []tagQueryResponse) any {
+	t.Helper()
+
+	q := reflect.New(tagBatchQueryType(len(pages))).Elem()
+	for i, page := range pages {
+		q.Field(i).Set(reflect.ValueOf(page.Repository))
+	}
+	return q.Interface()
+}
+
+func TestTagsForRepos(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	known := func(tags ...string) map[string]*RepoTag {
+		m := make(map[string]*RepoTag)
+		for _, tag := range tags {
+			m[tag] = &RepoTag{Tag: tag, ModulePath: "github.somecompany.net/someorg/repo", Version: tag, TargetSHA: "sha-" + tag}
+		}
+		return m
+	}
+	page := func(endCursor githubv4.String, hasNextPage bool, tags ...string) tagQueryResponse {
+		var responses []tagResponse
+		for _, tag := range tags {
+			responses = append(responses, tagResponse{tag: tag, committedDate: date, sha: "sha-" + tag})
+		}
+		return buildTagQueryResponses(t, responses, endCursor, hasNextPage)
+	}
+	tags := func(tags ...string) []*RepoTag {
+		var want []*RepoTag
+		for _, tag := range tags {
+			want = append(want, &RepoTag{Tag: tag, TagDate: date, ModulePath: "github.somecompany.net/someorg/repo", Version: tag, TargetSHA: "sha-" + tag})
+		}
+		return want
+	}
+
+	client := &mockGithubClient{stubbedResults: []any{
+		// The first page of each repo, at once.
+		buildTagBatchQueryResponse(t, []tagQueryResponse{
+			page("", false, "v0.0.2", "v0.0.1"),
+			page("cursor", true, "v1.0.1"),
+			page("", false),
+		}),
+		// The rest of repo2's tags.
+		page("", false, "v1.0.0"),
+	}}
+	sut := NewGithubSCM(client, testGithubHostname, "", false)
+
+	got, err := sut.TagsForRepos(t.Context(), []*TagsRequest{
+		{OrgRepoName: "someorg/repo1", Known: known("v0.0.2", "v0.0.1")},
+		{OrgRepoName: "not-a-repo"},
+		{OrgRepoName: "someorg/repo2", Known: known("v1.0.1", "v1.0.0")},
+		{OrgRepoName: "someorg/repo3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 4 || got[1].Err == nil {
+		t.Fatalf("expected an error for not-a-repo, got %v", got)
+	}
+	got[1].Err = nil
+	want := []*TagsResult{
+		{OrgRepoName: "someorg/repo1", Tags: tags("v0.0.2", "v0.0.1"), Complete: true},
+		{OrgRepoName: "not-a-repo"},
+		{OrgRepoName: "someorg/repo2", Tags: tags("v1.0.1", "v1.0.0"), Complete: true},
+		{OrgRepoName: "someorg/repo3", Complete: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results: -want, +got: %s", diff)
+	}
+
+	wantVariables := []map[string]any{
+		{
+			"tagsCursor": (*githubv4.String)(nil),
+			"repoOrg0":   githubv4.String("someorg"), "repoName0": githubv4.String("repo1"),
+			"repoOrg1": githubv4.String("someorg"), "repoName1": githubv4.String("repo2"),
+			"repoOrg2": githubv4.String("someorg"), "repoName2": githubv4.String("repo3"),
+		},
+		{"repoOrg": githubv4.String("someorg"), "repoName": githubv4.String("repo2"), "tagsCursor": githubv4.NewString("cursor")},
+	}
+	if diff := cmp.Diff(wantVariables, client.gotVariables); diff != "" {
+		t.Errorf("unexpected query variables: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepos_FallsBackToOneByOne(t *testing.T) {
+	sut := NewGithubSCM(&errGithubClient{err: errors.New("Could not resolve to a Repository with the name 'someorg/repo2'.")}, testGithubHostname, "", false)
+
+	got, err := sut.TagsForRepos(t.Context(), []*TagsRequest{{OrgRepoName: "someorg/repo1"}, {OrgRepoName: "someorg/repo2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range got {
+		if r.Err == nil {
+			t.Errorf("expected an error querying %s on its own", r.OrgRepoName)
+		}
+	}
+}
+
+func TestTagsForRepos_RateLimited(t *testing.T) {
+	sut := NewGithubSCM(&errGithubClient{err: errors.New("API rate limit exceeded for user ID 1.")}, testGithubHostname, "", false)
+
+	_, err := sut.TagsForRepos(t.Context(), []*TagsRequest{{OrgRepoName: "someorg/repo1"}, {OrgRepoName: "someorg/repo2"}})
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a *RateLimitError, got %v", err)
+	}
+}
+
+func TestTagsForRepos_GraphQLQuery(t *testing.T) {
+	// Check the aliased query against the real GraphQL client, since the mock
+	// client doesn't build queries.
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		gotQuery = body.Query
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {
+			"r0": {"refs": {"totalCount": 1, "edges": [{"node": {"name": "v0.0.1-", "target": {"oid": "abc", "committedDate": "2025-01-02T03:04:05Z"}}}], "pageInfo": {"hasNextPage": false}}},
+			"r1": {"refs": {"totalCount": 0, "edges": [], "pageInfo": {"hasNextPage": false}}},
+			"rateLimit": {"limit": 5000, "remaining": 4999, "cost": 1, "resetAt": "2030-01-01T00:00:00Z"}
+		}}`))
+	}))
+	defer server.Close()
+
+	sut := NewGithubSCM(githubv4.NewEnterpriseClient(server.URL, server.Client()), testGithubHostname, "", false)
+	got, err := sut.TagsForRepos(t.Context(), []*TagsRequest{{OrgRepoName: "someorg/repo1"}, {OrgRepoName: "someorg/repo2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"r0: repository(owner: $repoOrg0, name: $repoName0)", "r1: repository(owner: $repoOrg1, name: $repoName1)", "$tagsCursor:String"} {
+		if !strings.Contains(gotQuery, want) {
+			t.Errorf("expected the query to contain %q, got %s", want, gotQuery)
+		}
+	}
+	if len(got) != 2 || len(got[0].Tags) != 1 || got[0].Tags[0].Tag != "v0.0.1-" || len(got[1].Tags) != 0 {
+		t.Errorf("unexpected results: %+v, %+v", got[0], got[1])
+	}
+	if remaining := sut.RateLimit().Remaining; remaining != 4999 {
+		t.Errorf("expected 4999 rate limit points remaining, got %d", remaining)
+	}
+}
@@ -0,0 +1,205 @@
+// Package gitlab implements gitlab querying logic.
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+)
+
+// A handle for querying a self-managed GitLab host through its REST API.
+type GitlabSCM struct {
+	gitlabHostName string
+	client         *vcs.RESTClient
+	useHTTPS       bool
+
+	// Which tags to index. See SetRules.
+	rules *rules.Rules
+}
+
+// Creates a new GitLab SCM that authenticates with the given personal, group
+// or project access token.
+func NewGitlabSCM(gitlabHostName, authToken string, useHTTPS bool) *GitlabSCM {
+	return &GitlabSCM{gitlabHostName: gitlabHostName, client: vcs.NewRESTClient("gitlab", "PRIVATE-TOKEN", authToken), useHTTPS: useHTTPS}
+}
+
+// Sets the rules that decide which tags TagsForRepos excludes. A nil *Rules,
+// the default, excludes nothing.
+func (scm *GitlabSCM) SetRules(r *rules.Rules) {
+	scm.rules = r
+}
+
+func (scm *GitlabSCM) apiURL() string {
+	protocol := "http://"
+	if scm.useHTTPS {
+		protocol = "https://"
+	}
+	return protocol + scm.gitlabHostName + "/api/v4"
+}
+
+// The API URL of the given project, ex ".../projects/group%2Fproject". Projects
+// can be named by their path instead of their ID.
+func (scm *GitlabSCM) projectURL(orgRepoName string) string {
+	return scm.apiURL() + "/projects/" + url.PathEscape(orgRepoName)
+}
+
+// Matches the next page URL in a Link header.
+var nextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+func nextLink(header http.Header) string {
+	if m := nextLinkRegexp.FindStringSubmatch(header.Get("Link")); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+type project struct {
+	ID                int64     `json:"id"`
+	PathWithNamespace string    `json:"path_with_namespace"`
+	LastActivityAt    time.Time `json:"last_activity_at"`
+	Archived          bool      `json:"archived"`
+	ForkedFromProject *struct {
+		ID int64 `json:"id"`
+	} `json:"forked_from_project"`
+}
+
+// Retrieves all projects that GitLab detected Go code in, along with when each
+// was last active, so that projects that haven't changed needn't be re-indexed.
+// Projects are named by their full path, ex "group/subgroup/project".
+//
+// Projects are paged through by ID, which GitLab doesn't cap, so the results
+// are always complete.
+func (scm *GitlabSCM) GoRepos(ctx context.Context) (_ []*vcs.Repo, complete bool, _ error) {
+	var results []*vcs.Repo
+	for pageURL := scm.apiURL() + "/projects?with_programming_language=Go&pagination=keyset&order_by=id&sort=asc&per_page=100"; pageURL != ""; {
+		var page []*project
+		header, err := scm.client.GetJSON(ctx, pageURL, &page)
+		if err != nil {
+			return nil, false, fmt.Errorf("GoRepos: %w", err)
+		}
+		for _, p := range page {
+			results = append(results, &vcs.Repo{
+				OrgRepoName: p.PathWithNamespace,
+				PushedAt:    p.LastActivityAt.UTC(),
+				Archived:    p.Archived,
+				Fork:        p.ForkedFromProject != nil,
+				// Project IDs survive renames and transfers.
+				NodeID: fmt.Sprintf("gid://gitlab/Project/%d", p.ID),
+			})
+		}
+		pageURL = nextLink(header)
+	}
+	return results, true, nil
+}
+
+type tag struct {
+	Name string `json:"name"`
+	// When an annotated tag was created. Nil for lightweight tags.
+	CreatedAt *time.Time `json:"created_at"`
+	Commit    struct {
+		ID            string    `json:"id"`
+		CommittedDate time.Time `json:"committed_date"`
+	} `json:"commit"`
+}
+
+// Retrieves the tags of each of the given repos, one by one. Tags that aren't
+// valid module versions, or that the rules exclude, are included with their
+// Rejection set.
+//
+// Tags are listed most recently updated first, so incremental requests stop
+// paging once a page has a known tag and GitLab reports no more tags than
+// expected: see vcs.TagsRequest.
+//
+// Returns an error, rather than per repo errors, if GitLab rate limits us.
+func (scm *GitlabSCM) TagsForRepos(ctx context.Context, requests []*vcs.TagsRequest) ([]*vcs.TagsResult, error) {
+	var results []*vcs.TagsResult
+	for _, r := range requests {
+		tags, complete, err := scm.tagsForRepo(ctx, r)
+		if errors.Is(err, vcs.ErrRateLimited) {
+			return nil, err
+		}
+		results = append(results, &vcs.TagsResult{OrgRepoName: r.OrgRepoName, Tags: tags, Complete: complete, Err: err})
+	}
+	return results, nil
+}
+
+func (scm *GitlabSCM) tagsForRepo(ctx context.Context, r *vcs.TagsRequest) (_ []*vcs.RepoTag, complete bool, _ error) {
+	var results []*vcs.RepoTag
+	unknown := 0
+	for pageURL := scm.projectURL(r.OrgRepoName) + "/repository/tags?order_by=updated&sort=desc&per_page=100"; ; {
+		var page []*tag
+		header, err := scm.client.GetJSON(ctx, pageURL, &page)
+		if err != nil {
+			return nil, false, fmt.Errorf("error querying tags for %s: %w", r.OrgRepoName, err)
+		}
+
+		var tags []*vcs.RepoTag
+		for _, t := range page {
+			// Like GitHub, annotated tags are dated by when they were created,
+			// and lightweight tags by their commit.
+			tagDate := t.Commit.CommittedDate
+			if t.CreatedAt != nil {
+				tagDate = *t.CreatedAt
+			}
+			tags = append(tags, &vcs.RepoTag{Tag: t.Name, TagDate: tagDate.UTC(), TargetSHA: t.Commit.ID})
+		}
+		results = append(results, tags...)
+
+		reachedKnown, pageUnknown, err := vcs.ResolveTags(ctx, r.OrgRepoName, scm.gitlabHostName+"/"+r.OrgRepoName, tags, r.Known, scm.rules, func(ctx context.Context, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+			return scm.goMods(ctx, r.OrgRepoName, files)
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		unknown += pageUnknown
+
+		if pageURL = nextLink(header); pageURL == "" {
+			return results, true, nil
+		}
+		// With no tags deleted, the repo has every known tag plus the unknown
+		// ones seen so far. GitLab leaves out the total for large listings.
+		total, err := strconv.Atoi(header.Get("X-Total"))
+		if r.Incremental && reachedKnown && err == nil && total == len(r.Known)+unknown {
+			return results, false, nil
+		}
+	}
+}
+
+// Retrieves the given go.mod files of the given repo one by one. See
+// vcs.GoModsFetcher.
+func (scm *GitlabSCM) goMods(ctx context.Context, orgRepoName string, files []vcs.GoModFile) ([]*vcs.GoModFetch, error) {
+	var results []*vcs.GoModFetch
+	for _, f := range files {
+		content, found, err := scm.GoMod(ctx, orgRepoName, f.Tag, f.Dir)
+		if errors.Is(err, vcs.ErrRateLimited) {
+			return nil, err
+		}
+		results = append(results, &vcs.GoModFetch{Content: content, Found: found, Err: err})
+	}
+	return results, nil
+}
+
+// Retrieves the contents of the go.mod file in the given repo subdirectory at
+// the given tag. found is false if there is no such go.mod file.
+func (scm *GitlabSCM) GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error) {
+	return scm.client.GetFile(ctx, fmt.Sprintf("%s/repository/files/%s/raw?ref=%s", scm.projectURL(orgRepoName), url.PathEscape(path.Join(dir, "go.mod")), url.QueryEscape(tag)))
+}
+
+// Retrieves a zip archive of the repo contents at the given tag. All files in
+// the archive are inside a single top-level directory.
+func (scm *GitlabSCM) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	archive, err := scm.client.GetZipball(ctx, fmt.Sprintf("%s/repository/archive.zip?sha=%s", scm.projectURL(orgRepoName), url.QueryEscape(tag)))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching archive of %s (tag: %s): %w", orgRepoName, tag, err)
+	}
+	return archive, nil
+}
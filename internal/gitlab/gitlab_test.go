@@ -0,0 +1,222 @@
+package gitlab
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testAuthToken = "test-token"
+
+// A canned response of the test GitLab host. next is the request URI of the
+// next page, if any.
+type testResponse struct {
+	body  string
+	next  string
+	total int
+}
+
+// Stands up a GitLab host that serves the given go.mod files, keyed by
+// "project@tag:path", ex "group/project@v1.0.0:tools/go.mod", and answers
+// every other request with the response for its request URI. Records the
+// request URIs of every request but go.mod ones.
+func createTestGitlabServer(t *testing.T, responses map[string]testResponse, goMods map[string]string) (string, *[]string) {
+	t.Helper()
+
+	var gotRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != testAuthToken {
+			http.Error(w, "wrong PRIVATE-TOKEN header", http.StatusUnauthorized)
+			return
+		}
+
+		// Ex /api/v4/projects/group%2Fproject/repository/files/tools%2Fgo.mod/raw
+		if project, file, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/projects/"), "/repository/files/"); ok {
+			project = strings.ReplaceAll(project, "%2F", "/")
+			file = strings.ReplaceAll(strings.TrimSuffix(file, "/raw"), "%2F", "/")
+			if content, ok := goMods[fmt.Sprintf("%s@%s:%s", project, r.URL.Query().Get("ref"), file)]; ok {
+				fmt.Fprint(w, content)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+
+		gotRequests = append(gotRequests, r.URL.RequestURI())
+		response, ok := responses[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if response.next != "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, response.next))
+		}
+		if response.total != 0 {
+			w.Header().Set("X-Total", fmt.Sprint(response.total))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response.body)
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://"), &gotRequests
+}
+
+func TestGoRepos(t *testing.T) {
+	const firstPage = "/api/v4/projects?with_programming_language=Go&pagination=keyset&order_by=id&sort=asc&per_page=100"
+	const secondPage = firstPage + "&id_after=2"
+	hostName, _ := createTestGitlabServer(t, map[string]testResponse{
+		firstPage: {next: secondPage, body: `[
+			{"id": 1, "path_with_namespace": "group/project1", "last_activity_at": "2025-01-02T03:04:05.000Z", "archived": false},
+			{"id": 2, "path_with_namespace": "group/subgroup/project2", "last_activity_at": "2025-01-03T03:04:05.000Z", "archived": true, "forked_from_project": {"id": 7}}
+		]`},
+		secondPage: {body: `[{"id": 3, "path_with_namespace": "other/project3", "last_activity_at": "2025-01-04T03:04:05.000Z"}]`},
+	}, nil)
+	sut := NewGitlabSCM(hostName, testAuthToken, false)
+
+	got, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+
+	want := []*vcs.Repo{
+		{OrgRepoName: "group/project1", PushedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), NodeID: "gid://gitlab/Project/1"},
+		{OrgRepoName: "group/subgroup/project2", PushedAt: time.Date(2025, 1, 3, 3, 4, 5, 0, time.UTC), Archived: true, Fork: true, NodeID: "gid://gitlab/Project/2"},
+		{OrgRepoName: "other/project3", PushedAt: time.Date(2025, 1, 4, 3, 4, 5, 0, time.UTC), NodeID: "gid://gitlab/Project/3"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+}
+
+const tagsPage = "/api/v4/projects/group%2Fproject1/repository/tags?order_by=updated&sort=desc&per_page=100"
+
+func TestTagsForRepos(t *testing.T) {
+	hostName, _ := createTestGitlabServer(t, map[string]testResponse{
+		tagsPage: {next: tagsPage + "&page=2", body: `[
+			{"name": "v1.1.0", "created_at": "2025-02-01T00:00:00.000Z", "commit": {"id": "sha3", "committed_date": "2025-01-03T00:00:00.000Z"}},
+			{"name": "tools/cli/v0.1.0", "created_at": null, "commit": {"id": "sha2", "committed_date": "2025-01-02T00:00:00.000Z"}}
+		]`},
+		tagsPage + "&page=2": {body: `[
+			{"name": "v1.0", "commit": {"id": "sha1", "committed_date": "2025-01-01T00:00:00.000Z"}},
+			{"name": "_gheMigrationPR-1", "commit": {"id": "sha1", "committed_date": "2025-01-01T00:00:00.000Z"}}
+		]`},
+	}, map[string]string{
+		"group/project1@v1.1.0:go.mod":                     "module gitlab.somecompany.net/group/project1\n",
+		"group/project1@tools/cli/v0.1.0:tools/cli/go.mod": "module stash.someorg.company.com/group/cli\n",
+	})
+	sut := NewGitlabSCM(hostName, testAuthToken, false)
+	r, err := rules.Parse([]byte(`{"excludeTags": ["_gheMigrationPR-*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut.SetRules(r)
+
+	got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "group/project1"}, {OrgRepoName: "group/missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("TagsForRepos: expected 2 results, got %d", len(got))
+	}
+
+	want := &vcs.TagsResult{
+		OrgRepoName: "group/project1",
+		Complete:    true,
+		Tags: []*vcs.RepoTag{
+			// Annotated tags are dated by when they were created.
+			{Tag: "v1.1.0", TagDate: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), ModulePath: "gitlab.somecompany.net/group/project1", Version: "v1.1.0", TargetSHA: "sha3"},
+			{Tag: "tools/cli/v0.1.0", TagDate: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), ModulePath: "stash.someorg.company.com/group/cli", Version: "v0.1.0", TargetSHA: "sha2"},
+			{Tag: "v1.0", TagDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Rejection: "v1.0 is not a canonical semantic version (should be v1.0.0)", TargetSHA: "sha1"},
+			{Tag: "_gheMigrationPR-1", TagDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Rejection: `excluded by tag pattern "_gheMigrationPR-*"`, TargetSHA: "sha1"},
+		},
+	}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+	// Other repos are fetched despite the missing one.
+	if got[1].Err == nil {
+		t.Errorf("TagsForRepos: expected an error for group/missing")
+	}
+}
+
+func TestTagsForRepos_Incremental(t *testing.T) {
+	hostName, gotRequests := createTestGitlabServer(t, map[string]testResponse{
+		tagsPage: {next: tagsPage + "&page=2", total: 3, body: `[
+			{"name": "v1.1.0", "commit": {"id": "sha2", "committed_date": "2025-01-02T00:00:00.000Z"}},
+			{"name": "v1.0.0", "commit": {"id": "sha1", "committed_date": "2025-01-01T00:00:00.000Z"}}
+		]`},
+	}, map[string]string{
+		"group/project1@v1.1.0:go.mod": "module gitlab.somecompany.net/group/project1\n",
+	})
+	sut := NewGitlabSCM(hostName, testAuthToken, false)
+
+	known := map[string]*vcs.RepoTag{
+		"v1.0.0": {Tag: "v1.0.0", ModulePath: "gitlab.somecompany.net/group/project1", Version: "v1.0.0", TargetSHA: "sha1"},
+		"v0.9.0": {Tag: "v0.9.0", ModulePath: "gitlab.somecompany.net/group/project1", Version: "v0.9.0", TargetSHA: "sha0"},
+	}
+	got, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "group/project1", Known: known, Incremental: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second page isn't fetched: the first reaches a known tag, and there
+	// are as many tags as known ones plus the new one.
+	if got[0].Err != nil || got[0].Complete || len(got[0].Tags) != 2 {
+		t.Errorf("TagsForRepos: expected 2 tags of an incomplete listing, got %+v", got[0])
+	}
+	if diff := cmp.Diff([]string{tagsPage}, *gotRequests); diff != "" {
+		t.Errorf("unexpected requests: -want, +got: %s", diff)
+	}
+}
+
+func TestTagsForRepos_RateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	sut := NewGitlabSCM(strings.TrimPrefix(server.URL, "http://"), testAuthToken, false)
+
+	if _, err := sut.TagsForRepos(t.Context(), []*vcs.TagsRequest{{OrgRepoName: "group/project1"}}); err == nil {
+		t.Errorf("TagsForRepos: expected an error rather than per repo errors")
+	}
+}
+
+func TestZipball(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	if _, err := zw.Create("project1-v1.0.0-sha1/go.mod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	hostName, _ := createTestGitlabServer(t, map[string]testResponse{
+		"/api/v4/projects/group%2Fproject1/repository/archive.zip?sha=v1.0.0": {body: archive.String()},
+	}, nil)
+	sut := NewGitlabSCM(hostName, testAuthToken, false)
+
+	got, err := sut.Zipball(t.Context(), "group/project1", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(archive.Bytes(), got) {
+		t.Errorf("Zipball: unexpected archive")
+	}
+	if _, err := sut.Zipball(t.Context(), "group/project1", "v2.0.0"); err == nil {
+		t.Errorf("Zipball: expected an error for a missing tag")
+	}
+}
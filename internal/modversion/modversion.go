@@ -0,0 +1,82 @@
+// Package modversion maps VCS tags to Go module versions.
+package modversion
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Split splits a tag into the repo subdirectory of the module it belongs to,
+// and the version. For example, "tools/cli/v0.4.0" is split into "tools/cli"
+// and "v0.4.0". Tags of the module at the repo root have an empty dir.
+func Split(tag string) (dir, version string) {
+	i := strings.LastIndex(tag, "/")
+	if i < 0 {
+		return "", tag
+	}
+	return tag[:i], tag[i+1:]
+}
+
+// MajorSubdir returns the major version subdirectory, ex "v2", in which the go
+// command also looks for the go.mod file of a module at the given version, or
+// "" for v0 and v1 versions. For example, tag v2.0.0 of a repo whose v2/go.mod
+// declares "example.com/repo/v2" is version v2.0.0 of that module.
+func MajorSubdir(version string) string {
+	major := semver.Major(version)
+	if major == "" || major == "v0" || major == "v1" {
+		return ""
+	}
+	return major
+}
+
+// Version returns the module version denoted by tag for the module at
+// modulePath. hasGoMod reports whether the module has a go.mod file at tag.
+// tag should not include a subdirectory prefix: see Split.
+//
+// A non-nil error describes why tag can't be served as a version of the
+// module: for example, because it isn't a canonical semantic version, or
+// because its major version doesn't match modulePath.
+//
+// Tags v2 and above for modules without a go.mod file and without a /vN major
+// version suffix are mapped to +incompatible versions, the same way the go
+// command does.
+func Version(modulePath, tag string, hasGoMod bool) (string, error) {
+	if err := CheckTag(tag); err != nil {
+		return "", err
+	}
+
+	_, pathMajor, ok := module.SplitPathVersion(modulePath)
+	if !ok {
+		return "", fmt.Errorf("invalid module path %q", modulePath)
+	}
+	if err := module.CheckPathMajor(tag, pathMajor); err != nil {
+		if !hasGoMod && pathMajor == "" && semver.Major(tag) != "v0" && semver.Major(tag) != "v1" {
+			return tag + "+incompatible", nil
+		}
+		if hasGoMod && pathMajor == "" {
+			return "", fmt.Errorf("go.mod declares module path %q, which has no /%s suffix required by tag %s", modulePath, semver.Major(tag), tag)
+		}
+		return "", fmt.Errorf("module path %q does not match tag %s: %v", modulePath, tag, err)
+	}
+	return tag, nil
+}
+
+// CheckTag returns a non-nil error if tag is not a canonical semantic version
+// that the go command would consider a release or pre-release version. It
+// needs no knowledge of the module, so it can be used to cheaply reject tags
+// before looking up their go.mod file.
+func CheckTag(tag string) error {
+	if !semver.IsValid(tag) {
+		return fmt.Errorf("%s is not a semantic version", tag)
+	}
+	if canonical := semver.Canonical(tag); canonical != tag {
+		return fmt.Errorf("%s is not a canonical semantic version (should be %s)", tag, canonical)
+	}
+	if module.IsPseudoVersion(tag) {
+		return fmt.Errorf("%s is a pseudo-version", tag)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package modversion
+
+import "testing"
+
+func TestSplit(t *testing.T) {
+	for _, tc := range []struct {
+		tag         string
+		wantDir     string
+		wantVersion string
+	}{
+		{tag: "v1.2.3", wantDir: "", wantVersion: "v1.2.3"},
+		{tag: "cli/v1.2.3", wantDir: "cli", wantVersion: "v1.2.3"},
+		{tag: "tools/cli/v0.4.0", wantDir: "tools/cli", wantVersion: "v0.4.0"},
+		{tag: "_gheMigrationPR-435", wantDir: "", wantVersion: "_gheMigrationPR-435"},
+	} {
+		gotDir, gotVersion := Split(tc.tag)
+		if gotDir != tc.wantDir || gotVersion != tc.wantVersion {
+			t.Errorf("Split(%q): want (%q, %q), got (%q, %q)", tc.tag, tc.wantDir, tc.wantVersion, gotDir, gotVersion)
+		}
+	}
+}
+
+func TestMajorSubdir(t *testing.T) {
+	for version, want := range map[string]string{
+		"v0.1.0":              "",
+		"v1.2.3":              "",
+		"v2.0.0":              "v2",
+		"v10.1.0-rc.1":        "v10",
+		"v2.0.0+incompatible": "v2",
+		"_gheMigrationPR-435": "",
+	} {
+		if got := MajorSubdir(version); got != want {
+			t.Errorf("MajorSubdir(%q): want %q, got %q", version, want, got)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		modulePath  string
+		tag         string
+		hasGoMod    bool
+		wantVersion string
+		wantErr     bool
+	}{
+		{name: "v0", modulePath: "github.somecompany.net/foo/bar", tag: "v0.1.0", hasGoMod: true, wantVersion: "v0.1.0"},
+		{name: "v1", modulePath: "github.somecompany.net/foo/bar", tag: "v1.2.3", hasGoMod: true, wantVersion: "v1.2.3"},
+		{name: "pre-release", modulePath: "github.somecompany.net/foo/bar", tag: "v1.2.3-rc.1", hasGoMod: true, wantVersion: "v1.2.3-rc.1"},
+		{name: "v2 with major suffix", modulePath: "github.somecompany.net/foo/bar/v2", tag: "v2.0.0", hasGoMod: true, wantVersion: "v2.0.0"},
+		{name: "v2 without go.mod", modulePath: "github.somecompany.net/foo/bar", tag: "v2.0.0", wantVersion: "v2.0.0+incompatible"},
+		{name: "v2 with go.mod but no major suffix", modulePath: "github.somecompany.net/foo/bar", tag: "v2.0.0", hasGoMod: true, wantErr: true},
+		{name: "v1 with major suffix", modulePath: "github.somecompany.net/foo/bar/v2", tag: "v1.0.0", hasGoMod: true, wantErr: true},
+		{name: "v3 with v2 major suffix", modulePath: "github.somecompany.net/foo/bar/v2", tag: "v3.0.0", hasGoMod: true, wantErr: true},
+		{name: "gopkg.in", modulePath: "gopkg.in/yaml.v2", tag: "v2.4.0", hasGoMod: true, wantVersion: "v2.4.0"},
+		{name: "not semver", modulePath: "github.somecompany.net/foo/bar", tag: "_gheMigrationPR-435", wantErr: true},
+		{name: "missing v prefix", modulePath: "github.somecompany.net/foo/bar", tag: "1.2.3", wantErr: true},
+		{name: "not canonical", modulePath: "github.somecompany.net/foo/bar", tag: "v1.2", wantErr: true},
+		{name: "build metadata", modulePath: "github.somecompany.net/foo/bar", tag: "v1.2.3+meta", wantErr: true},
+		{name: "pseudo-version", modulePath: "github.somecompany.net/foo/bar", tag: "v0.0.0-20250102030405-abcdefabcdef", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotVersion, err := Version(tc.modulePath, tc.tag, tc.hasGoMod)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Version(%q, %q, %v): expected error, got version %q", tc.modulePath, tc.tag, tc.hasGoMod, gotVersion)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Version(%q, %q, %v): unexpected error: %v", tc.modulePath, tc.tag, tc.hasGoMod, err)
+			}
+			if gotVersion != tc.wantVersion {
+				t.Errorf("Version(%q, %q, %v): want %q, got %q", tc.modulePath, tc.tag, tc.hasGoMod, tc.wantVersion, gotVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package rules decides which orgs, repos and tags are indexed.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Rules that include or exclude orgs, repos and tags from indexing. Excluded
+// repos and tags are removed from the feed, not just skipped.
+//
+// Repo and tag patterns are globs, where * matches any run of characters
+// (including /) and ? matches any one character, or regular expressions when
+// wrapped in slashes, ex "/^v[0-9]+-rc/". Org and repo names are matched case
+// insensitively, since GitHub treats them that way.
+//
+// A nil *Rules excludes nothing.
+type Rules struct {
+	// Orgs to index. If empty, every org not in DenyOrgs is indexed.
+	AllowOrgs []string `json:"allowOrgs"`
+	DenyOrgs  []string `json:"denyOrgs"`
+
+	// Repos to index, as "org/repo" patterns. If empty, every repo not
+	// matching ExcludeRepos is indexed.
+	IncludeRepos []string `json:"includeRepos"`
+	ExcludeRepos []string `json:"excludeRepos"`
+
+	ExcludeArchived bool `json:"excludeArchived"`
+	ExcludeForks    bool `json:"excludeForks"`
+
+	// Tags not to index, ex "_gheMigrationPR-*".
+	ExcludeTags []string `json:"excludeTags"`
+
+	allowOrgs    map[string]bool
+	denyOrgs     map[string]bool
+	includeRepos []*pattern
+	excludeRepos []*pattern
+	excludeTags  []*pattern
+}
+
+// A compiled repo or tag pattern.
+type pattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// Reads rules from the given JSON file. See Parse.
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Load: %v", err)
+	}
+	r, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Load: %s: %v", path, err)
+	}
+	return r, nil
+}
+
+// Parses rules from JSON, ex:
+//
+//	{
+//	  "denyOrgs": ["sandbox"],
+//	  "excludeRepos": ["*/*-archive"],
+//	  "excludeArchived": true,
+//	  "excludeForks": true,
+//	  "excludeTags": ["_gheMigrationPR-*"]
+//	}
+func Parse(data []byte) (*Rules, error) {
+	var r Rules
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return nil, fmt.Errorf("Parse: %v", err)
+	}
+
+	r.allowOrgs = lowerSet(r.AllowOrgs)
+	r.denyOrgs = lowerSet(r.DenyOrgs)
+	var err error
+	if r.includeRepos, err = compile(r.IncludeRepos, true); err != nil {
+		return nil, fmt.Errorf("Parse: includeRepos: %v", err)
+	}
+	if r.excludeRepos, err = compile(r.ExcludeRepos, true); err != nil {
+		return nil, fmt.Errorf("Parse: excludeRepos: %v", err)
+	}
+	if r.excludeTags, err = compile(r.ExcludeTags, false); err != nil {
+		return nil, fmt.Errorf("Parse: excludeTags: %v", err)
+	}
+	return &r, nil
+}
+
+func lowerSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[strings.ToLower(v)] = true
+	}
+	return set
+}
+
+func compile(sources []string, ignoreCase bool) ([]*pattern, error) {
+	var patterns []*pattern
+	for _, source := range sources {
+		var expr string
+		if len(source) > 1 && strings.HasPrefix(source, "/") && strings.HasSuffix(source, "/") {
+			expr = source[1 : len(source)-1]
+		} else {
+			expr = "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(source)) + "$"
+		}
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", source, err)
+		}
+		patterns = append(patterns, &pattern{source: source, re: re})
+	}
+	return patterns, nil
+}
+
+func match(patterns []*pattern, s string) *pattern {
+	for _, p := range patterns {
+		if p.re.MatchString(s) {
+			return p
+		}
+	}
+	return nil
+}
+
+func matchEither(patterns []*pattern, s, t string) *pattern {
+	if p := match(patterns, s); p != nil {
+		return p
+	}
+	return match(patterns, t)
+}
+
+// Returns why the given org is excluded, or "" if it isn't.
+func (r *Rules) OrgExclusion(org string) string {
+	if r == nil {
+		return ""
+	}
+	org = strings.ToLower(org)
+	if r.denyOrgs[org] {
+		return fmt.Sprintf("org %s is denied", org)
+	}
+	if len(r.allowOrgs) > 0 && !r.allowOrgs[org] {
+		return fmt.Sprintf("org %s isn't allowed", org)
+	}
+	return ""
+}
+
+// Returns why the given repo, ex "corp/my-repo", is excluded, or "" if it
+// isn't. Pass false for archived and fork if they're unknown.
+//
+// Repos of hosts other than GitHub are prefixed with their host, ex
+// "gitlab.somecompany.net/group/project": see vcs.Multi. Their org is the
+// segment after the host, and repo patterns match them with or without it.
+func (r *Rules) RepoExclusion(orgRepoName string, archived, fork bool) string {
+	if r == nil {
+		return ""
+	}
+	name := orgRepoName
+	if host, rest, ok := strings.Cut(orgRepoName, "/"); ok && strings.Contains(host, ".") {
+		name = rest
+	}
+	org, _, _ := strings.Cut(name, "/")
+	if reason := r.OrgExclusion(org); reason != "" {
+		return reason
+	}
+	if p := matchEither(r.excludeRepos, orgRepoName, name); p != nil {
+		return fmt.Sprintf("matches excluded repo pattern %q", p.source)
+	}
+	if len(r.includeRepos) > 0 && matchEither(r.includeRepos, orgRepoName, name) == nil {
+		return "doesn't match any included repo pattern"
+	}
+	if archived && r.ExcludeArchived {
+		return "archived repos are excluded"
+	}
+	if fork && r.ExcludeForks {
+		return "forks are excluded"
+	}
+	return ""
+}
+
+// Prefixes the reasons returned by TagExclusion.
+const tagExclusionPrefix = "excluded by tag pattern "
+
+// Returns why the given tag is excluded, or "" if it isn't. The reason is
+// stored as the tag's rejection.
+func (r *Rules) TagExclusion(tag string) string {
+	if r == nil {
+		return ""
+	}
+	if p := match(r.excludeTags, tag); p != nil {
+		return fmt.Sprintf("%s%q", tagExclusionPrefix, p.source)
+	}
+	return ""
+}
+
+// Whether the given rejection was returned by TagExclusion, rather than
+// determined from the tag's contents. Such rejections only last as long as the
+// rule does.
+func IsTagExclusion(rejection string) bool {
+	return strings.HasPrefix(rejection, tagExclusionPrefix)
+}
+
+// Whether any tags are excluded.
+func (r *Rules) ExcludesTags() bool {
+	return r != nil && len(r.excludeTags) > 0
+}
@@ -0,0 +1,129 @@
+package rules
+
+import "testing"
+
+func TestRepoExclusion(t *testing.T) {
+	r, err := Parse([]byte(`{
+		"denyOrgs": ["Sandbox"],
+		"includeRepos": ["corp/*", "/^platform/svc-[a-z]+$/", "sandbox/*"],
+		"excludeRepos": ["corp/*-archive"],
+		"excludeArchived": true,
+		"excludeForks": true
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		orgRepoName  string
+		archived     bool
+		fork         bool
+		wantExcluded bool
+	}{
+		{orgRepoName: "corp/my-repo"},
+		{orgRepoName: "Corp/My-Repo"},
+		{orgRepoName: "platform/svc-auth"},
+		{orgRepoName: "platform/svc-auth2", wantExcluded: true},
+		{orgRepoName: "other/my-repo", wantExcluded: true},
+		{orgRepoName: "sandbox/my-repo", wantExcluded: true},
+		{orgRepoName: "corp/old-archive", wantExcluded: true},
+		{orgRepoName: "corp/my-repo", archived: true, wantExcluded: true},
+		{orgRepoName: "corp/my-repo", fork: true, wantExcluded: true},
+	} {
+		got := r.RepoExclusion(tc.orgRepoName, tc.archived, tc.fork)
+		if (got != "") != tc.wantExcluded {
+			t.Errorf("RepoExclusion(%q, %v, %v): want excluded %v, got %q", tc.orgRepoName, tc.archived, tc.fork, tc.wantExcluded, got)
+		}
+	}
+}
+
+func TestRepoExclusion_HostPrefixed(t *testing.T) {
+	r, err := Parse([]byte(`{
+		"allowOrgs": ["corp", "group"],
+		"includeRepos": ["corp/*", "group/*", "gitlab.somecompany.net/other/kept"],
+		"excludeRepos": ["group/*-archive"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		orgRepoName  string
+		wantExcluded bool
+	}{
+		{orgRepoName: "corp/my-repo"},
+		{orgRepoName: "gitlab.somecompany.net/group/project"},
+		{orgRepoName: "gitlab.somecompany.net/group/sub/project"},
+		{orgRepoName: "stash.somecompany.net/CORP/repo"},
+		{orgRepoName: "gitlab.somecompany.net/group/old-archive", wantExcluded: true},
+		{orgRepoName: "gitlab.somecompany.net/sandbox/project", wantExcluded: true},
+		// Patterns may name the host, but orgs are still checked.
+		{orgRepoName: "gitlab.somecompany.net/other/kept", wantExcluded: true},
+	} {
+		got := r.RepoExclusion(tc.orgRepoName, false, false)
+		if (got != "") != tc.wantExcluded {
+			t.Errorf("RepoExclusion(%q): want excluded %v, got %q", tc.orgRepoName, tc.wantExcluded, got)
+		}
+	}
+}
+
+func TestOrgExclusion_AllowOrgs(t *testing.T) {
+	r, err := Parse([]byte(`{"allowOrgs": ["corp"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.OrgExclusion("CORP"); got != "" {
+		t.Errorf("OrgExclusion(CORP): expected no exclusion, got %q", got)
+	}
+	if got := r.OrgExclusion("other"); got == "" {
+		t.Errorf("OrgExclusion(other): expected an exclusion")
+	}
+}
+
+func TestTagExclusion(t *testing.T) {
+	r, err := Parse([]byte(`{"excludeTags": ["_gheMigrationPR-*", "/-nightly$/"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		tag          string
+		wantExcluded bool
+	}{
+		{tag: "v1.0.0"},
+		{tag: "tools/cli/v1.0.0"},
+		{tag: "_gheMigrationPR-435", wantExcluded: true},
+		{tag: "v1.0.0-nightly", wantExcluded: true},
+		// Tags are case sensitive.
+		{tag: "_ghemigrationpr-435"},
+	} {
+		if got := r.TagExclusion(tc.tag); (got != "") != tc.wantExcluded {
+			t.Errorf("TagExclusion(%q): want excluded %v, got %q", tc.tag, tc.wantExcluded, got)
+		}
+	}
+	if !r.ExcludesTags() {
+		t.Errorf("ExcludesTags: expected true")
+	}
+	if reason := r.TagExclusion("_gheMigrationPR-435"); !IsTagExclusion(reason) {
+		t.Errorf("IsTagExclusion(%q): expected true", reason)
+	}
+	if IsTagExclusion("v1.2 is not a canonical semantic version (should be v1.2.0)") {
+		t.Errorf("IsTagExclusion: expected false for a rejection by contents")
+	}
+}
+
+func TestNilRules(t *testing.T) {
+	var r *Rules
+	if r.RepoExclusion("corp/my-repo", true, true) != "" || r.TagExclusion("_gheMigrationPR-1") != "" || r.ExcludesTags() {
+		t.Errorf("expected nil rules to exclude nothing")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, data := range []string{
+		`{"excludeTags": ["/(/"]}`,
+		`{"excludeTag": ["v*"]}`,
+		`not json`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%s): expected an error", data)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Combines the SCMs of several hosts into one.
+//
+// Repos of the primary SCM keep their names, ex "corp/my-repo". Repos of every
+// other SCM are named after their host too, ex
+// "gitlab.somecompany.net/group/project", so that repos of different hosts are
+// stored side by side without clashing.
+type Multi struct {
+	primary SCM
+	// In the order added.
+	hosts  []string
+	byHost map[string]SCM
+}
+
+// Creates a Multi whose primary SCM is the given one. primary may be nil, in
+// which case every repo is named after its host.
+func NewMulti(primary SCM) *Multi {
+	return &Multi{primary: primary, byHost: make(map[string]SCM)}
+}
+
+// Adds the SCM of the given host, ex "gitlab.somecompany.net". Fails if the
+// host already has an SCM, or is empty.
+func (m *Multi) Add(host string, s SCM) error {
+	if host == "" {
+		return fmt.Errorf("Add: empty host name")
+	}
+	if _, ok := m.byHost[host]; ok {
+		return fmt.Errorf("Add: host %s already has an SCM", host)
+	}
+	m.hosts = append(m.hosts, host)
+	m.byHost[host] = s
+	return nil
+}
+
+// Returns the SCM of the given repo, and the repo's name within it. Host names
+// have dots, which org names don't, so primary repos never look like they're
+// named after a host.
+func (m *Multi) route(orgRepoName string) (SCM, string, error) {
+	if host, name, ok := strings.Cut(orgRepoName, "/"); ok {
+		if s, ok := m.byHost[host]; ok {
+			return s, name, nil
+		}
+	}
+	if m.primary == nil {
+		return nil, "", fmt.Errorf("no SCM for repo %s", orgRepoName)
+	}
+	return m.primary, orgRepoName, nil
+}
+
+// Retrieves the Go repos of every SCM. complete is false if any SCM's results
+// are incomplete. Fails if any SCM fails: see GoReposByHost to keep the results
+// of the others.
+func (m *Multi) GoRepos(ctx context.Context) (_ []*Repo, complete bool, _ error) {
+	complete = true
+	var results []*Repo
+	for _, h := range m.GoReposByHost(ctx) {
+		if h.Err != nil {
+			return nil, false, h.Err
+		}
+		results = append(results, h.Repos...)
+		complete = complete && h.Complete
+	}
+	return results, complete, nil
+}
+
+// The Go repos of one of the SCMs of a Multi, named as in the Multi.
+type HostRepos struct {
+	// Empty for the primary SCM.
+	Host     string
+	Repos    []*Repo
+	Complete bool
+
+	// Set if the SCM's repos couldn't be fetched, in which case Repos is
+	// empty.
+	Err error
+}
+
+// Retrieves the Go repos of each SCM, primary first. One SCM failing, ex
+// because it rate limited us, doesn't fail the others: since each SCM's repos
+// are named apart, a listing of one SCM's repos shows which of its repos are
+// gone. See HasRepo.
+func (m *Multi) GoReposByHost(ctx context.Context) []*HostRepos {
+	var results []*HostRepos
+	if m.primary != nil {
+		repos, complete, err := m.primary.GoRepos(ctx)
+		results = append(results, &HostRepos{Repos: repos, Complete: complete, Err: err})
+	}
+	for _, host := range m.hosts {
+		repos, complete, err := m.byHost[host].GoRepos(ctx)
+		if err != nil {
+			results = append(results, &HostRepos{Host: host, Err: fmt.Errorf("error fetching Go repos of %s: %w", host, err)})
+			continue
+		}
+		var named []*Repo
+		for _, r := range repos {
+			n := *r
+			n.OrgRepoName = host + "/" + r.OrgRepoName
+			// Node IDs are only unique within a host.
+			if r.NodeID != "" {
+				n.NodeID = host + "/" + r.NodeID
+			}
+			named = append(named, &n)
+		}
+		results = append(results, &HostRepos{Host: host, Repos: named, Complete: complete})
+	}
+	return results
+}
+
+// Whether the given repo belongs to the SCM of the given host, or to the
+// primary SCM if host is empty.
+func (m *Multi) HasRepo(host, orgRepoName string) bool {
+	s, _, err := m.route(orgRepoName)
+	if err != nil {
+		return false
+	}
+	if host == "" {
+		return s == m.primary
+	}
+	repoHost, _, _ := strings.Cut(orgRepoName, "/")
+	return repoHost == host
+}
+
+// Retrieves the tags of each of the given repos from their SCMs. If an SCM
+// fails as a whole, ex because it rate limited us, the results of its repos get
+// its error, and the results of other SCMs' repos are kept.
+func (m *Multi) TagsForRepos(ctx context.Context, requests []*TagsRequest) ([]*TagsResult, error) {
+	results := make([]*TagsResult, len(requests))
+	// The requests for each SCM, in order, and the index in results of each.
+	var scms []SCM
+	scmRequests := make(map[SCM][]*TagsRequest)
+	scmIndexes := make(map[SCM][]int)
+	for i, r := range requests {
+		s, name, err := m.route(r.OrgRepoName)
+		if err != nil {
+			results[i] = &TagsResult{OrgRepoName: r.OrgRepoName, Err: fmt.Errorf("TagsForRepos: %v", err)}
+			continue
+		}
+		if _, ok := scmRequests[s]; !ok {
+			scms = append(scms, s)
+		}
+		routed := *r
+		routed.OrgRepoName = name
+		scmRequests[s] = append(scmRequests[s], &routed)
+		scmIndexes[s] = append(scmIndexes[s], i)
+	}
+
+	for _, s := range scms {
+		scmResults, err := s.TagsForRepos(ctx, scmRequests[s])
+		if err != nil {
+			for _, i := range scmIndexes[s] {
+				results[i] = &TagsResult{OrgRepoName: requests[i].OrgRepoName, Err: err}
+			}
+			continue
+		}
+		for j, result := range scmResults {
+			i := scmIndexes[s][j]
+			named := *result
+			named.OrgRepoName = requests[i].OrgRepoName
+			results[i] = &named
+		}
+	}
+	return results, nil
+}
+
+// Retrieves a go.mod file from the repo's SCM.
+func (m *Multi) GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error) {
+	s, name, err := m.route(orgRepoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("GoMod: %v", err)
+	}
+	return s.GoMod(ctx, name, tag, dir)
+}
+
+// Retrieves a zipball from the repo's SCM.
+func (m *Multi) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	s, name, err := m.route(orgRepoName)
+	if err != nil {
+		return nil, fmt.Errorf("Zipball: %v", err)
+	}
+	return s.Zipball(ctx, name, tag)
+}
@@ -0,0 +1,192 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// An SCM with the given repos, whose tags are named after the repo. Records
+// the repo of each request. If err is set, listing repos and tags fails with
+// it.
+type fakeSCM struct {
+	repos       []*Repo
+	err         error
+	gotRequests []string
+}
+
+func (f *fakeSCM) GoRepos(ctx context.Context) ([]*Repo, bool, error) {
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	return f.repos, true, nil
+}
+
+func (f *fakeSCM) TagsForRepos(ctx context.Context, requests []*TagsRequest) ([]*TagsResult, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var results []*TagsResult
+	for _, r := range requests {
+		f.gotRequests = append(f.gotRequests, r.OrgRepoName)
+		results = append(results, &TagsResult{OrgRepoName: r.OrgRepoName, Tags: []*RepoTag{{Tag: r.OrgRepoName}}, Complete: true})
+	}
+	return results, nil
+}
+
+func (f *fakeSCM) GoMod(ctx context.Context, orgRepoName, tag, dir string) ([]byte, bool, error) {
+	f.gotRequests = append(f.gotRequests, orgRepoName)
+	return []byte(fmt.Sprintf("module %s\n", orgRepoName)), true, nil
+}
+
+func (f *fakeSCM) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	f.gotRequests = append(f.gotRequests, orgRepoName)
+	return nil, nil
+}
+
+func TestMulti(t *testing.T) {
+	primary := &fakeSCM{repos: []*Repo{{OrgRepoName: "corp/repo1", NodeID: "R_1"}}}
+	gitlab := &fakeSCM{repos: []*Repo{{OrgRepoName: "group/subgroup/project1", NodeID: "gid://gitlab/Project/1"}}}
+	sut := NewMulti(primary)
+	if err := sut.Add("gitlab.somecompany.net", gitlab); err != nil {
+		t.Fatal(err)
+	}
+
+	gotRepos, complete, err := sut.GoRepos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRepos := []*Repo{
+		{OrgRepoName: "corp/repo1", NodeID: "R_1"},
+		{OrgRepoName: "gitlab.somecompany.net/group/subgroup/project1", NodeID: "gitlab.somecompany.net/gid://gitlab/Project/1"},
+	}
+	if diff := cmp.Diff(wantRepos, gotRepos); diff != "" {
+		t.Errorf("GoRepos: -want, +got: %s", diff)
+	}
+	if !complete {
+		t.Errorf("GoRepos: expected complete results")
+	}
+	// The SCMs' own repos are left as they were.
+	if gitlab.repos[0].OrgRepoName != "group/subgroup/project1" {
+		t.Errorf("GoRepos: modified the SCM's repo: %v", gitlab.repos[0])
+	}
+
+	gotResults, err := sut.TagsForRepos(t.Context(), []*TagsRequest{
+		{OrgRepoName: "gitlab.somecompany.net/group/subgroup/project1"},
+		{OrgRepoName: "corp/repo1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResults := []*TagsResult{
+		{OrgRepoName: "gitlab.somecompany.net/group/subgroup/project1", Tags: []*RepoTag{{Tag: "group/subgroup/project1"}}, Complete: true},
+		{OrgRepoName: "corp/repo1", Tags: []*RepoTag{{Tag: "corp/repo1"}}, Complete: true},
+	}
+	if diff := cmp.Diff(wantResults, gotResults); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+
+	goMod, _, err := sut.GoMod(t.Context(), "gitlab.somecompany.net/group/subgroup/project1", "v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "module group/subgroup/project1\n"; string(goMod) != want {
+		t.Errorf("GoMod: want %q, got %q", want, goMod)
+	}
+	if diff := cmp.Diff([]string{"corp/repo1"}, primary.gotRequests); diff != "" {
+		t.Errorf("unexpected primary requests: -want, +got: %s", diff)
+	}
+}
+
+func TestMulti_NoPrimary(t *testing.T) {
+	sut := NewMulti(nil)
+	if err := sut.Add("gitlab.somecompany.net", &fakeSCM{}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := sut.TagsForRepos(t.Context(), []*TagsRequest{{OrgRepoName: "corp/repo1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err == nil {
+		t.Errorf("TagsForRepos: expected an error for a repo of no SCM")
+	}
+	if _, err := sut.Zipball(t.Context(), "corp/repo1", "v1.0.0"); err == nil {
+		t.Errorf("Zipball: expected an error for a repo of no SCM")
+	}
+}
+
+func TestMulti_HostFails(t *testing.T) {
+	rateLimited := errors.New("rate limited")
+	primary := &fakeSCM{err: rateLimited}
+	gitlab := &fakeSCM{repos: []*Repo{{OrgRepoName: "group/project1"}}}
+	sut := NewMulti(primary)
+	if err := sut.Add("gitlab.somecompany.net", gitlab); err != nil {
+		t.Fatal(err)
+	}
+
+	// The GitLab repos are listed regardless.
+	hosts := sut.GoReposByHost(t.Context())
+	if len(hosts) != 2 || !errors.Is(hosts[0].Err, rateLimited) {
+		t.Fatalf("GoReposByHost: expected the primary to fail, got %v", hosts)
+	}
+	wantGitlab := &HostRepos{Host: "gitlab.somecompany.net", Repos: []*Repo{{OrgRepoName: "gitlab.somecompany.net/group/project1"}}, Complete: true}
+	if diff := cmp.Diff(wantGitlab, hosts[1]); diff != "" {
+		t.Errorf("GoReposByHost: -want, +got: %s", diff)
+	}
+	if _, _, err := sut.GoRepos(t.Context()); !errors.Is(err, rateLimited) {
+		t.Errorf("GoRepos: expected %v, got %v", rateLimited, err)
+	}
+
+	// So are the GitLab repos' tags.
+	results, err := sut.TagsForRepos(t.Context(), []*TagsRequest{
+		{OrgRepoName: "corp/repo1"},
+		{OrgRepoName: "gitlab.somecompany.net/group/project1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, rateLimited) || results[0].OrgRepoName != "corp/repo1" {
+		t.Errorf("TagsForRepos: expected corp/repo1 to fail, got %v", results[0])
+	}
+	wantResult := &TagsResult{OrgRepoName: "gitlab.somecompany.net/group/project1", Tags: []*RepoTag{{Tag: "group/project1"}}, Complete: true}
+	if diff := cmp.Diff(wantResult, results[1]); diff != "" {
+		t.Errorf("TagsForRepos: -want, +got: %s", diff)
+	}
+}
+
+func TestMulti_HasRepo(t *testing.T) {
+	sut := NewMulti(&fakeSCM{})
+	if err := sut.Add("gitlab.somecompany.net", &fakeSCM{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host, orgRepoName string
+		want              bool
+	}{
+		{host: "", orgRepoName: "corp/repo1", want: true},
+		{host: "", orgRepoName: "gitlab.somecompany.net/group/project1"},
+		{host: "gitlab.somecompany.net", orgRepoName: "gitlab.somecompany.net/group/project1", want: true},
+		{host: "gitlab.somecompany.net", orgRepoName: "corp/repo1"},
+	} {
+		if got := sut.HasRepo(tc.host, tc.orgRepoName); got != tc.want {
+			t.Errorf("HasRepo(%q, %q): want %v, got %v", tc.host, tc.orgRepoName, tc.want, got)
+		}
+	}
+}
+
+func TestMulti_AddDuplicateHost(t *testing.T) {
+	sut := NewMulti(nil)
+	if err := sut.Add("gitlab.somecompany.net", &fakeSCM{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sut.Add("gitlab.somecompany.net", &fakeSCM{}); err == nil {
+		t.Errorf("Add: expected an error for a host added twice")
+	}
+	if err := sut.Add("", &fakeSCM{}); err == nil {
+		t.Errorf("Add: expected an error for an empty host")
+	}
+}
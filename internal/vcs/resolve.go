@@ -0,0 +1,200 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/modversion"
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// A go.mod file to fetch: the one in the given repo subdirectory at the given
+// tag. Dir is empty for the module at the repo root.
+type GoModFile struct {
+	Tag string
+	Dir string
+}
+
+// A go.mod file fetched for ResolveTags.
+type GoModFetch struct {
+	Content []byte
+	Found   bool
+	// Set if the go.mod file couldn't be fetched.
+	Err error
+}
+
+// Fetches the given go.mod files of a repo, returning a result per file in the
+// same order. An error is returned, rather than per file errors, if none of
+// the files should be fetched for a while, ex because the host rate limited
+// us.
+type GoModsFetcher func(ctx context.Context, files []GoModFile) ([]*GoModFetch, error)
+
+// Determines the module path and version of each of the given tags of a repo,
+// or why it can't be served as a module version: sets the ModulePath, Version
+// and Rejection of each tag. Tags need Tag and TargetSHA set.
+//
+// repoModulePath is the module path implied by the repo's URL, ex
+// "gitlab.somecompany.net/group/project", which modules without a go.mod file
+// get. orgRepoName is only used in logs and errors.
+//
+// Tags in known that still point at the same commit keep their module path,
+// version and rejection: see TagsRequest. Tags that the rules exclude are
+// rejected, and tags that they no longer exclude are resolved again. The go.mod
+// files of the remaining tags are fetched with a single call to fetchGoMods.
+//
+// For v2+ tags, the go.mod file in the major version subdirectory, ex
+// "tools/cli/v2/go.mod" for tag "tools/cli/v2.0.0", is fetched too. As with the
+// go command, it's used if the go.mod file of the tag's directory doesn't
+// declare a module path with the tag's major version, but it does.
+//
+// reachedKnown is true if any of the tags is in known and still points at the
+// same commit. unknown is the number of tags that aren't in known.
+func ResolveTags(ctx context.Context, orgRepoName, repoModulePath string, tags []*RepoTag, known map[string]*RepoTag, r *rules.Rules, fetchGoMods GoModsFetcher) (reachedKnown bool, unknown int, _ error) {
+	// Tags whose go.mod file is needed, their directory and version, and the
+	// indexes in goModFiles of their go.mod files: the one in their directory
+	// and, for v2+ tags, the one in the major version subdirectory, or -1.
+	var needGoMod []*RepoTag
+	var dirs, versions []string
+	var goModFiles []GoModFile
+	var goModIndexes, majorGoModIndexes []int
+	for _, tag := range tags {
+		k, ok := known[tag.Tag]
+		if !ok {
+			unknown++
+		}
+		sameTarget := ok && k.TargetSHA != "" && k.TargetSHA == tag.TargetSHA
+		if sameTarget {
+			reachedKnown = true
+		}
+
+		// Checked before reusing known tags, in case the rules changed since.
+		if reason := r.TagExclusion(tag.Tag); reason != "" {
+			tag.Rejection = reason
+			continue
+		}
+		if sameTarget && !rules.IsTagExclusion(k.Rejection) {
+			tag.ModulePath, tag.Version, tag.Rejection = k.ModulePath, k.Version, k.Rejection
+			continue
+		}
+
+		// Tags of nested modules are prefixed with the module's
+		// subdirectory, ex "tools/cli/v0.4.0".
+		dir, version := modversion.Split(tag.Tag)
+
+		// Don't bother fetching go.mod for tags that can never be valid
+		// versions.
+		if err := modversion.CheckTag(version); err != nil {
+			tag.Rejection = err.Error()
+			continue
+		}
+
+		needGoMod = append(needGoMod, tag)
+		dirs = append(dirs, dir)
+		versions = append(versions, version)
+		goModIndexes = append(goModIndexes, len(goModFiles))
+		goModFiles = append(goModFiles, GoModFile{Tag: tag.Tag, Dir: dir})
+		majorGoModIndexes = append(majorGoModIndexes, -1)
+		if sub := modversion.MajorSubdir(version); sub != "" {
+			majorGoModIndexes[len(majorGoModIndexes)-1] = len(goModFiles)
+			goModFiles = append(goModFiles, GoModFile{Tag: tag.Tag, Dir: path.Join(dir, sub)})
+		}
+	}
+	if len(goModFiles) == 0 {
+		return reachedKnown, unknown, nil
+	}
+
+	goMods, err := fetchGoMods(ctx, goModFiles)
+	if err != nil {
+		// Falling back would get every remaining tag wrong too.
+		return false, 0, fmt.Errorf("error getting go.mod files for %s: %w", orgRepoName, err)
+	}
+	for i, tag := range needGoMod {
+		dir, version := dirs[i], versions[i]
+		modulePath := repoModulePath
+		if dir != "" {
+			modulePath += "/" + dir
+		}
+
+		goMod := goMods[goModIndexes[i]]
+		if j := majorGoModIndexes[i]; j >= 0 && !declaresMajor(orgRepoName, tag.Tag, version, goMod) && declaresMajor(orgRepoName, tag.Tag, version, goMods[j]) {
+			goMod = goMods[j]
+		}
+
+		var goModModulePath string
+		found, err := goMod.Found, goMod.Err
+		if err == nil && found {
+			goModModulePath, found, err = modulePathFromGoMod(orgRepoName, tag.Tag, goMod.Content)
+		}
+		if err != nil {
+			// if go.mod file was found but turned out to be invalid, we want to reject the tag
+			if found {
+				slog.Error(fmt.Sprintf("found go.mod file for %s but it's invalid: %v. Rejecting the tag", orgRepoName, err))
+				tag.Rejection = fmt.Sprintf("invalid go.mod: %v", err)
+				continue
+			}
+
+			slog.Error(fmt.Sprintf("error getting go.mod file for %s: %v. Defaulting to repo url for module path", orgRepoName, err))
+			// Don't let the next re-index reuse a module path that's only
+			// a guess.
+			tag.TargetSHA = ""
+		}
+
+		if found {
+			modulePath = goModModulePath
+		} else if dir != "" {
+			// Without a go.mod file, the subdirectory is just part of the
+			// module at the repo root.
+			tag.Rejection = fmt.Sprintf("no go.mod file in %s", dir)
+			continue
+		} else {
+			slog.Info(fmt.Sprintf("unable to find go.mod file in the root of the project for %s. Defaulting to repo url for module path", orgRepoName))
+		}
+
+		tag.ModulePath = modulePath
+		if tag.Version, err = modversion.Version(modulePath, version, found); err != nil {
+			tag.Rejection = err.Error()
+		}
+	}
+	return reachedKnown, unknown, nil
+}
+
+// Whether the given go.mod file was fetched and declares a module path whose
+// major version suffix, ex "/v2", matches the given v2+ version.
+func declaresMajor(orgRepoName, tag, version string, goMod *GoModFetch) bool {
+	if goMod.Err != nil || !goMod.Found {
+		return false
+	}
+	modulePath, found, err := modulePathFromGoMod(orgRepoName, tag, goMod.Content)
+	if err != nil || !found {
+		return false
+	}
+	_, pathMajor, ok := module.SplitPathVersion(modulePath)
+	return ok && pathMajor != "" && module.CheckPathMajor(version, pathMajor) == nil
+}
+
+// Parses the module path out of the given go.mod file, so that we can determine
+// if the module path matches the repo URL or if the module path is different
+// and needs to be updated in the index. The latter commonly occurs when a
+// module has been migrated from one vcs to another without changing the module
+// path.
+func modulePathFromGoMod(orgRepoName, tag string, goMod []byte) (string, bool, error) {
+	file, err := modfile.Parse("go.mod", goMod, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("error parsing go.mod file for %s (tag: %s): %v", orgRepoName, tag, err)
+	}
+
+	if file.Module != nil {
+		err := module.CheckPath(file.Module.Mod.Path)
+		if err != nil {
+			return "", true, fmt.Errorf("invalid module path found for %s (tag: %s): %v", orgRepoName, tag, err)
+		}
+
+		return file.Module.Mod.Path, true, nil
+	}
+
+	return "", false, nil
+}
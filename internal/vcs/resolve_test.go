@@ -0,0 +1,93 @@
+package vcs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+)
+
+func TestResolveTags_RuleRemoved(t *testing.T) {
+	r, err := rules.Parse([]byte(`{"excludeTags": ["v0.1.*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchGoMods := func(ctx context.Context, files []GoModFile) ([]*GoModFetch, error) {
+		var goMods []*GoModFetch
+		for range files {
+			goMods = append(goMods, &GoModFetch{Content: []byte("module example.com/repo1\n"), Found: true})
+		}
+		return goMods, nil
+	}
+
+	tags := []*RepoTag{{Tag: "v0.1.0", TargetSHA: "abc"}}
+	if _, _, err := ResolveTags(t.Context(), "org/repo1", "example.com/org/repo1", tags, nil, r, fetchGoMods); err != nil {
+		t.Fatal(err)
+	}
+	if !rules.IsTagExclusion(tags[0].Rejection) {
+		t.Fatalf("expected v0.1.0 to be excluded, got rejection %q", tags[0].Rejection)
+	}
+
+	// Once the rule is removed, the tag is resolved again even though it
+	// still points at the same commit.
+	known := map[string]*RepoTag{"v0.1.0": tags[0]}
+	tags = []*RepoTag{{Tag: "v0.1.0", TargetSHA: "abc"}}
+	if _, _, err := ResolveTags(t.Context(), "org/repo1", "example.com/org/repo1", tags, known, nil, fetchGoMods); err != nil {
+		t.Fatal(err)
+	}
+	if tags[0].Rejection != "" || tags[0].ModulePath != "example.com/repo1" || tags[0].Version != "v0.1.0" {
+		t.Errorf("expected v0.1.0 to be accepted again, got %+v", tags[0])
+	}
+
+	// Rejections determined from the tag's contents are still reused.
+	known = map[string]*RepoTag{"v0.1.0": {Tag: "v0.1.0", TargetSHA: "abc", Rejection: "invalid go.mod"}}
+	tags = []*RepoTag{{Tag: "v0.1.0", TargetSHA: "abc"}}
+	if _, _, err := ResolveTags(t.Context(), "org/repo1", "example.com/org/repo1", tags, known, nil, fetchGoMods); err != nil {
+		t.Fatal(err)
+	}
+	if tags[0].Rejection != "invalid go.mod" {
+		t.Errorf("expected the known rejection to be reused, got %q", tags[0].Rejection)
+	}
+}
+
+func TestResolveTags_MajorSubdirectory(t *testing.T) {
+	goMods := map[string]string{
+		"v1.0.0:":                       "module example.com/repo1\n",
+		"v2.0.0:":                       "module example.com/repo1\n",
+		"v2.0.0:v2":                     "module example.com/repo1/v2\n",
+		"v3.0.0:":                       "module example.com/repo1/v3\n",
+		"v3.0.0:v3":                     "module example.com/repo1/v3/old\n",
+		"tools/cli/v2.0.0:tools/cli/v2": "module example.com/repo1/tools/cli/v2\n",
+		"v4.0.0:":                       "module example.com/repo1\n",
+	}
+	fetchGoMods := func(ctx context.Context, files []GoModFile) ([]*GoModFetch, error) {
+		var fetches []*GoModFetch
+		for _, f := range files {
+			content, found := goMods[f.Tag+":"+f.Dir]
+			fetches = append(fetches, &GoModFetch{Content: []byte(content), Found: found})
+		}
+		return fetches, nil
+	}
+
+	tags := []*RepoTag{{Tag: "v1.0.0"}, {Tag: "v2.0.0"}, {Tag: "v3.0.0"}, {Tag: "tools/cli/v2.0.0"}, {Tag: "v4.0.0"}}
+	if _, _, err := ResolveTags(t.Context(), "org/repo1", "example.com/repo1", tags, nil, nil, fetchGoMods); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		modulePath string
+		rejected   bool
+	}{
+		{modulePath: "example.com/repo1"},
+		// The go.mod file in v2/ declares the v2 module.
+		{modulePath: "example.com/repo1/v2"},
+		// The go.mod file at the root takes precedence.
+		{modulePath: "example.com/repo1/v3"},
+		{modulePath: "example.com/repo1/tools/cli/v2"},
+		// Neither go.mod file declares a v4 module.
+		{modulePath: "example.com/repo1", rejected: true},
+	} {
+		if got := tags[i]; got.ModulePath != want.modulePath || (got.Rejection != "") != want.rejected {
+			t.Errorf("%s: want module path %s (rejected: %v), got %+v", got.Tag, want.modulePath, want.rejected, got)
+		}
+	}
+}
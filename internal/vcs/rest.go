@@ -0,0 +1,125 @@
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/mod/zip"
+)
+
+// Returned, wrapped, by RESTClient when the host rate limits a request.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// The largest archive that will be downloaded. Matches the largest module zip
+// that the go command accepts.
+const MaxZipballSize = zip.MaxZipFile
+
+// Makes requests to the REST API of a host that authenticates requests with a
+// token in a header, ex GitLab or Bitbucket Server.
+type RESTClient struct {
+	// Names the host in errors, ex "gitlab".
+	name       string
+	authHeader string
+	authValue  string
+}
+
+// Creates a REST client for the host with the given name, which sets the given
+// header to the given value to authenticate requests.
+func NewRESTClient(name, authHeader, authValue string) *RESTClient {
+	return &RESTClient{name: name, authHeader: authHeader, authValue: authValue}
+}
+
+// Makes a GET request. Returns an error wrapping ErrRateLimited if the host
+// rate limited the request. The caller must close the response body.
+func (c *RESTClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building %s API request: %v", c.name, err)
+	}
+	request.Header.Set(c.authHeader, c.authValue)
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %w: retry after %s seconds", c.name, ErrRateLimited, resp.Header.Get("Retry-After"))
+	}
+	return resp, nil
+}
+
+// Makes a GET request and decodes the JSON response into out. Returns an error
+// unless the response is a 200.
+func (c *RESTClient) GetJSON(ctx context.Context, url string, out any) (http.Header, error) {
+	resp, err := c.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, url, bytes.TrimSpace(body))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response from %s: %v", url, err)
+	}
+	return resp.Header, nil
+}
+
+// Retrieves the raw contents of a file, ex a go.mod file. found is false if the
+// host responds with a 404.
+func (c *RESTClient) GetFile(ctx context.Context, url string) (_ []byte, found bool, _ error) {
+	resp, err := c.Get(ctx, url)
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying %s API for file contents: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status code from %s API for file contents. Status code: %d", c.name, resp.StatusCode)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading %s API response: %v", c.name, err)
+	}
+	return bodyBytes, true, nil
+}
+
+// Retrieves a zip archive, failing if it's larger than MaxZipballSize.
+func (c *RESTClient) GetZipball(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.Get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s API for archive: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from %s API for archive. Status code: %d", c.name, resp.StatusCode)
+	}
+	return ReadZipball(resp.Body)
+}
+
+// Reads a zip archive, failing if it's larger than MaxZipballSize.
+func ReadZipball(r io.Reader) ([]byte, error) {
+	bodyBytes, err := io.ReadAll(io.LimitReader(r, MaxZipballSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %v", err)
+	}
+	if len(bodyBytes) > MaxZipballSize {
+		return nil, fmt.Errorf("archive is larger than %d bytes", MaxZipballSize)
+	}
+	return bodyBytes, nil
+}
@@ -0,0 +1,81 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRESTClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total", "1")
+		fmt.Fprint(w, `{"name": "repo1"}`)
+	})
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusBadGateway)
+	})
+	mux.HandleFunc("GET /go.mod", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "module example.com/repo1\n")
+	})
+	mux.HandleFunc("GET /archive.zip", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "PK")
+	})
+	mux.HandleFunc("GET /limited", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "test-token" {
+			http.Error(w, "wrong PRIVATE-TOKEN header", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	sut := NewRESTClient("gitlab", "PRIVATE-TOKEN", "test-token")
+
+	var got struct{ Name string }
+	header, err := sut.GetJSON(t.Context(), server.URL+"/json", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "repo1" || header.Get("X-Total") != "1" {
+		t.Errorf("GetJSON: unexpected response %+v, headers %v", got, header)
+	}
+	if _, err := sut.GetJSON(t.Context(), server.URL+"/broken", &got); err == nil {
+		t.Errorf("GetJSON: expected an error for a 502")
+	}
+
+	content, found, err := sut.GetFile(t.Context(), server.URL+"/go.mod")
+	if err != nil || !found || string(content) != "module example.com/repo1\n" {
+		t.Errorf("GetFile: unexpected %q (found: %v, err: %v)", content, found, err)
+	}
+	if _, found, err := sut.GetFile(t.Context(), server.URL+"/missing/go.mod"); err != nil || found {
+		t.Errorf("GetFile: expected a missing file not to be found, got found: %v, err: %v", found, err)
+	}
+	if _, _, err := sut.GetFile(t.Context(), server.URL+"/broken"); err == nil {
+		t.Errorf("GetFile: expected an error for a 502")
+	}
+
+	archive, err := sut.GetZipball(t.Context(), server.URL+"/archive.zip")
+	if err != nil || string(archive) != "PK" {
+		t.Errorf("GetZipball: unexpected %q (err: %v)", archive, err)
+	}
+	if _, err := sut.GetZipball(t.Context(), server.URL+"/missing.zip"); err == nil {
+		t.Errorf("GetZipball: expected an error for a 404")
+	}
+
+	// Rate limiting is told apart from other errors, so that callers can stop.
+	for _, get := range []func() error{
+		func() error { _, err := sut.GetJSON(t.Context(), server.URL+"/limited", &got); return err },
+		func() error { _, _, err := sut.GetFile(t.Context(), server.URL+"/limited"); return err },
+		func() error { _, err := sut.GetZipball(t.Context(), server.URL+"/limited"); return err },
+	} {
+		if err := get(); !errors.Is(err, ErrRateLimited) {
+			t.Errorf("expected an error wrapping ErrRateLimited, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package vcs defines the source code hosts that modules are indexed from, and
+// the logic they share for turning tags into module versions.
+package vcs
+
+import (
+	"context"
+	"time"
+)
+
+// A source code host, ex GitHub or GitLab, that Go repos and their tags are
+// indexed from.
+type SCM interface {
+	// Retrieves all of the host's Go repos. complete is false if repos missing
+	// from the results may still exist, ex because a search was truncated.
+	GoRepos(ctx context.Context) (_ []*Repo, complete bool, _ error)
+
+	// Retrieves the tags of each of the given repos, returning a result per
+	// request in the same order. An error is returned, rather than per repo
+	// errors, if none of the repos should be retried for a while, ex because
+	// the host rate limited us.
+	TagsForRepos(ctx context.Context, requests []*TagsRequest) ([]*TagsResult, error)
+
+	// Retrieves the contents of the go.mod file in the given repo subdirectory
+	// at the given tag. found is false if there is no such go.mod file.
+	GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error)
+
+	// Retrieves a zip archive of the repo contents at the given tag. All files
+	// in the archive are inside a single top-level directory.
+	Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error)
+}
+
+// A repo found by GoRepos.
+type Repo struct {
+	// Something like "corp/my-repo".
+	OrgRepoName string
+
+	// When the repo was last pushed to. Pushing or deleting tags updates it.
+	// Zero if unknown.
+	PushedAt time.Time
+
+	// The number of tags in the repo. Zero if unknown.
+	TagCount int
+
+	// Whether the repo is archived, and whether it's a fork. Rules may exclude
+	// either: see rules.Rules.RepoExclusion.
+	Archived bool
+	Fork     bool
+
+	// The repo's node ID, which stays the same when the repo is renamed or
+	// transferred to another org. Empty if the host has none.
+	NodeID string
+}
+
+// A repo tag and its creation date.
+type RepoTag struct {
+	Tag        string
+	TagDate    time.Time
+	ModulePath string
+
+	// The module version the tag denotes. May differ from Tag: for example,
+	// v2+ tags of modules without a go.mod file are +incompatible versions.
+	Version string
+
+	// Why the tag can't be served as a module version. Empty if it can.
+	Rejection string
+
+	// The SHA of the commit the tag points to. Empty if ModulePath couldn't be
+	// determined reliably, so that it's determined again next time.
+	TargetSHA string
+}
+
+// A repo whose tags to fetch with TagsForRepos.
+//
+// Known holds the results of a previous fetch, keyed by tag name. Tags in Known
+// that still point at the same commit keep their module path, version and
+// rejection, rather than fetching their go.mod file again, and hosts that date
+// tags with a request per tag may reuse their TagDate. Known may be nil.
+//
+// If Incremental is true, hosts that list tags newest first may stop once they
+// reach known tags, in which case the result isn't Complete.
+type TagsRequest struct {
+	OrgRepoName string
+	Known       map[string]*RepoTag
+	Incremental bool
+}
+
+// The tags of a repo fetched with TagsForRepos. Complete is false if only some
+// of the repo's tags were fetched, in which case Tags should be merged with the
+// known tags rather than replace them.
+type TagsResult struct {
+	OrgRepoName string
+	Tags        []*RepoTag
+	Complete    bool
+
+	// Set if the repo's tags couldn't be fetched, in which case Tags is empty.
+	Err error
+}
@@ -2,40 +2,92 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
 	"math/rand"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Netflix-Skunkworks/golang-index/internal"
+	"github.com/Netflix-Skunkworks/golang-index/internal/bitbucket"
 	"github.com/Netflix-Skunkworks/golang-index/internal/db"
+	"github.com/Netflix-Skunkworks/golang-index/internal/git"
 	"github.com/Netflix-Skunkworks/golang-index/internal/github"
-	"github.com/shurcooL/githubv4"
-	"golang.org/x/oauth2"
+	"github.com/Netflix-Skunkworks/golang-index/internal/gitlab"
+	"github.com/Netflix-Skunkworks/golang-index/internal/rules"
+	"github.com/Netflix-Skunkworks/golang-index/internal/vcs"
 	"golang.org/x/sync/errgroup"
 )
 
 var port = flag.Int("port", 8081, "port to listen on")
 var githubHostName = flag.String("githubHostName", "", "github host to query. should be your enterprise host - ex: github.mycompany.net")
-var githubAuthToken = flag.String("githubAuthToken", "", "github auth token")
+var githubAuthToken = flag.String("githubAuthToken", "", "github auth token, or a comma separated list of tokens to spread requests across: each request is made with the token that has the most rate limit budget left. not needed when authenticating as a github app")
+var githubUsageLogPeriod = flag.Duration("githubUsageLogPeriod", 15*time.Minute, "duration between logging the github requests made with each token, and the rate limit budget each has left")
+var githubAppID = flag.Int64("githubAppID", 0, "id of the github app to authenticate as, instead of with githubAuthToken. each org that the app is installed on is indexed with the app installation's token")
+var githubAppPrivateKeyFile = flag.String("githubAppPrivateKeyFile", "", "path to the PEM encoded private key of the github app")
+var gitlabHostName = flag.String("gitlabHostName", "", "self-managed gitlab host to also index - ex: gitlab.mycompany.net. its repos are named after the host, ex gitlab.mycompany.net/group/project")
+var gitlabAuthToken = flag.String("gitlabAuthToken", "", "gitlab personal, group or project access token with the read_api scope")
+var bitbucketHostName = flag.String("bitbucketHostName", "", "bitbucket server (stash) host to also index - ex: stash.mycompany.net. its repos are named after the host, ex stash.mycompany.net/PROJ/repo")
+var bitbucketAuthToken = flag.String("bitbucketAuthToken", "", "bitbucket server http access token with read access to the repos to index")
+var gitRepoURLs = flag.String("gitRepoURLs", "", "comma separated list of URLs of git repos to also index over the git smart http protocol, for hosts with no api - ex: https://git.mycompany.net/team/repo.git. repos are named after their host and path, ex git.mycompany.net/team/repo")
+var localReposDir = flag.String("localReposDir", "", "directory of bare git repos, laid out as org/repo.git, to index straight from disk - ex a mirror, or for running offline. its repos keep their org/repo names, as github's do, when there's no githubHostName, and are named after localReposHostName otherwise")
+var localReposHostName = flag.String("localReposHostName", "", "host that the repos in localReposDir are mirrored from, which their module paths start with - ex: github.mycompany.net")
+var rulesFile = flag.String("rulesFile", "", "path to a JSON file of rules that include or exclude orgs, repos and tags from indexing. see the README")
+var githubGoModDiscovery = flag.Bool("githubGoModDiscovery", false, "also index repos that have a go.mod file, at their root or one directory down, whatever language github classifies them as. lists every repo of each org of the github app's installations, or of the rules' allowOrgs")
+var githubAPI = flag.String("githubAPI", "auto", "github api to query: graphql, rest, or auto to use the graphql api unless a request at startup finds it unavailable and the rest api available, ex behind proxies that block /api/graphql. the rest api takes many more requests")
+var githubWebhookSecret = flag.String("githubWebhookSecret", "", "secret that github webhook deliveries are signed with. when set, create, delete, push and repository events POSTed to /webhook re-index the affected repo right away")
 
 var allReposReindexWorkCheckPeriod = flag.Duration("allReposReindexWorkCheckPeriod", 5*time.Minute, "duration describing the frequency to poll for work")
 var allReposReindexPeriod = flag.Duration("allReposReindexPeriod", 24*time.Hour, "duration between re-indexing list of all repos")
+var allReposMaxDeletedPercent = flag.Float64("allReposMaxDeletedPercent", 10, "most repos, as a percentage of those stored, that re-indexing all repos may delete because they're no longer found. if more are missing, none are deleted, in case github only returned some of them")
 var allReposReindexTTL = flag.Duration("allReposReindexTTL", 5*time.Minute, "TTL that an indexing worker has for re-indexing list of all repos")
 
 var repoTagsReindexingWorkCheckPeriod = flag.Duration("repoTagsReindexingWorkCheckPeriod", 5*time.Minute, "duration describing the frequency to poll for work. only occurs when no work is found: if work was previously found, instant eager re-poll occurs. note that a 1-60s jitter is added to this duration")
 var repoTagsReindexingWorkers = flag.Int("repoTagsReindexingWorkers", 10, "number of workers that concurrently perform repo tag re-indexing")
 var repoTagsReindexPeriod = flag.Duration("repoTagsReindexPeriod", 24*time.Hour, "duration between re-indexing all tags for a particular repo")
+var repoTagsFullResyncPeriod = flag.Duration("repoTagsFullResyncPeriod", 7*24*time.Hour, "duration between re-indexing all tags for a particular repo even if it hasn't been pushed to. repos that have been pushed to are re-indexed every repoTagsReindexPeriod")
+var repoTagsReindexBatchSize = flag.Int("repoTagsReindexBatchSize", 10, "number of repos that a worker re-indexes at once. the first page of tags of each repo in a batch is fetched with a single github query")
 var repoTagsReindexTTL = flag.Duration("repoTagsReindexTTL", 10*time.Minute, "TTL that an indexing worker has for re-indexing all tags for a particular repo")
 
 func main() {
 	flag.Parse()
 
-	if *githubHostName == "" || *githubAuthToken == "" {
-		slog.Info("--githubHostName (no http/https: github.mycompany.net) and --githubAuthToken are required")
+	if *githubHostName == "" && *gitlabHostName == "" && *bitbucketHostName == "" && *gitRepoURLs == "" && *localReposDir == "" {
+		slog.Info("--githubHostName (no http/https: github.mycompany.net), --gitlabHostName, --bitbucketHostName, --gitRepoURLs or --localReposDir is required")
+		os.Exit(1)
+	}
+	if *githubHostName != "" && *githubAuthToken == "" && *githubAppID == 0 {
+		slog.Info("either --githubAuthToken or --githubAppID is required with --githubHostName")
+		os.Exit(1)
+	}
+	if *gitlabHostName != "" && *gitlabAuthToken == "" {
+		slog.Info("--gitlabAuthToken is required with --gitlabHostName")
+		os.Exit(1)
+	}
+	if *bitbucketHostName != "" && *bitbucketAuthToken == "" {
+		slog.Info("--bitbucketAuthToken is required with --bitbucketHostName")
+		os.Exit(1)
+	}
+	if *localReposDir != "" && *localReposHostName == "" {
+		slog.Info("--localReposHostName is required with --localReposDir")
+		os.Exit(1)
+	}
+	if *localReposDir != "" && *localReposHostName == *githubHostName {
+		slog.Info("--localReposHostName must differ from --githubHostName")
+		os.Exit(1)
+	}
+	if *githubAPI != "auto" && *githubAPI != "graphql" && *githubAPI != "rest" {
+		slog.Info("--githubAPI must be auto, graphql or rest")
+		os.Exit(1)
+	}
+	if *githubAppID != 0 && *githubAppPrivateKeyFile == "" {
+		slog.Info("--githubAppPrivateKeyFile is required with --githubAppID")
 		os.Exit(1)
 	}
 
@@ -52,15 +104,133 @@ func main() {
 		os.Exit(1)
 	}
 
-	fullHost := fmt.Sprintf("https://%s/api/graphql", *githubHostName)
-	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: *githubAuthToken})
-	graphqlClient := githubv4.NewEnterpriseClient(fullHost, oauth2.NewClient(ctx, src))
+	var githubSCM *github.GithubSCM
+	if *githubHostName != "" && *githubAppID != 0 {
+		privateKey, err := os.ReadFile(*githubAppPrivateKeyFile)
+		if err != nil {
+			slog.Error(fmt.Sprintf("error reading github app private key: %v", err))
+			os.Exit(1)
+		}
+		app, err := github.NewApp(*githubAppID, privateKey, *githubHostName, true)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		githubSCM = github.NewGithubAppSCM(app, *githubHostName, true)
+	} else if *githubHostName != "" {
+		var tokens []string
+		for token := range strings.SplitSeq(*githubAuthToken, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+		if len(tokens) == 0 {
+			slog.Info("--githubAuthToken has no tokens")
+			os.Exit(1)
+		}
+		githubSCM = github.NewGithubTokenPoolSCM(*githubHostName, tokens, true)
+	}
+
+	var gitlabSCM *gitlab.GitlabSCM
+	if *gitlabHostName != "" {
+		gitlabSCM = gitlab.NewGitlabSCM(*gitlabHostName, *gitlabAuthToken, true)
+	}
+
+	var bitbucketSCM *bitbucket.BitbucketSCM
+	if *bitbucketHostName != "" {
+		bitbucketSCM = bitbucket.NewBitbucketSCM(*bitbucketHostName, *bitbucketAuthToken, true)
+	}
+
+	gitHosts, gitSCMs, err := newGitSCMs(*gitRepoURLs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var localSCM *git.LocalSCM
+	if *localReposDir != "" {
+		localSCM = git.NewLocalSCM(*localReposDir, *localReposHostName)
+	}
+
+	if *rulesFile != "" {
+		r, err := rules.Load(*rulesFile)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		if githubSCM != nil {
+			githubSCM.SetRules(r)
+		}
+		if gitlabSCM != nil {
+			gitlabSCM.SetRules(r)
+		}
+		if bitbucketSCM != nil {
+			bitbucketSCM.SetRules(r)
+		}
+		for _, scm := range gitSCMs {
+			scm.SetRules(r)
+		}
+		if localSCM != nil {
+			localSCM.SetRules(r)
+		}
+		idb.SetRules(r)
+	}
+
+	// GitHub repos keep their names, as they always have. Other hosts' repos
+	// are named after their host, except local repos when there's no GitHub,
+	// which stand in for it.
+	source := vcs.NewMulti(nil)
+	primaryHostName := *githubHostName
+	if githubSCM != nil {
+		githubSCM.SetGoModDiscovery(*githubGoModDiscovery)
+		switch *githubAPI {
+		case "rest":
+			githubSCM.SetREST(true)
+		case "auto":
+			detectCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			if err := githubSCM.DetectAPI(detectCtx); err != nil {
+				slog.Warn(fmt.Sprintf("%v. Using the GraphQL API", err))
+			}
+			cancel()
+		}
+		source = vcs.NewMulti(githubSCM)
 
-	githubSCM := github.NewGithubSCM(graphqlClient, *githubHostName, *githubAuthToken, true)
+		// Lets operators see how close we are to running out of GitHub budget,
+		// at /debug/vars.
+		expvar.Publish("githubRateLimit", expvar.Func(func() any { return githubSCM.RateLimits() }))
+	}
+	if localSCM != nil && githubSCM == nil {
+		source = vcs.NewMulti(localSCM)
+		primaryHostName = *localReposHostName
+	}
+	// Repos of other hosts are named after their host, which must differ from
+	// every other host, including the primary's.
+	addHost := func(host string, s vcs.SCM) {
+		if host == primaryHostName {
+			slog.Info(fmt.Sprintf("host %s is also the primary host: each host must be given once", host))
+			os.Exit(1)
+		}
+		if err := source.Add(host, s); err != nil {
+			slog.Info(fmt.Sprintf("%v: each host must be given once", err))
+			os.Exit(1)
+		}
+	}
+	if localSCM != nil && githubSCM != nil {
+		addHost(*localReposHostName, localSCM)
+	}
+	if gitlabSCM != nil {
+		addHost(*gitlabHostName, gitlabSCM)
+	}
+	if bitbucketSCM != nil {
+		addHost(*bitbucketHostName, bitbucketSCM)
+	}
+	for _, host := range gitHosts {
+		addHost(host, gitSCMs[host])
+	}
 
-	server := newServer(*port, idb, *githubHostName)
+	server := newServer(*port, idb, primaryHostName, source, *githubWebhookSecret)
 
-	// Backoff for GitHub issues.
+	// Backoff for source code host issues.
 	githubBackoff := &internal.Backoff{
 		Initial:    30 * time.Second,
 		Multiplier: 1.5,
@@ -69,6 +239,19 @@ func main() {
 
 	grp, grpCtx := errgroup.WithContext(ctx)
 
+	if githubSCM != nil {
+		grp.Go(func() error {
+			// Periodically log how much of each token's budget is being used.
+			for {
+				select {
+				case <-time.After(*githubUsageLogPeriod):
+					githubSCM.LogUsage()
+				case <-grpCtx.Done():
+					return grpCtx.Err()
+				}
+			}
+		})
+	}
 	// TODO(jbarkhuysen): This should probably be in a function that's tested.
 	grp.Go(func() error {
 		// Periodically re-index all repos.
@@ -79,21 +262,63 @@ func main() {
 			}
 			if shouldReindex {
 				slog.Info("should re-index all Go repos: yes")
-				allRepos, err := githubSCM.GoRepos(grpCtx)
-				if err != nil {
-					// TODO(jbarkhuysen): Add some metrics/alerting here.
-					slog.Error(fmt.Sprintf("error fetching all Go repos: %v", err))
+				// Each host's listing is stored on its own, so that one host
+				// failing doesn't hold up the others.
+				var failed error
+				var saw int
+				for _, h := range source.GoReposByHost(grpCtx) {
+					name := h.Host
+					if name == "" {
+						name = primaryHostName
+					}
+					if h.Err != nil {
+						// TODO(jbarkhuysen): Add some metrics/alerting here.
+						slog.Error(fmt.Sprintf("error fetching all Go repos of %s: %v", name, h.Err))
+						failed = h.Err
+						continue
+					}
+					if len(h.Repos) == 0 {
+						slog.Warn(fmt.Sprintf("found no Go repos on %s: not deleting missing repos", name))
+						continue
+					}
+					var dbRepos []*db.Repo
+					for _, r := range h.Repos {
+						dbRepos = append(dbRepos, &db.Repo{
+							OrgRepoName: r.OrgRepoName,
+							PushedAt:    r.PushedAt,
+							TagCount:    r.TagCount,
+							Archived:    r.Archived,
+							Fork:        r.Fork,
+							NodeID:      r.NodeID,
+						})
+					}
+					// Only a complete listing shows which of the host's repos
+					// are gone.
+					if h.Complete {
+						owned := func(orgRepoName string) bool { return source.HasRepo(h.Host, orgRepoName) }
+						err = idb.StoreOwnedRepos(ctx, dbRepos, owned, *allReposMaxDeletedPercent)
+						if errors.Is(err, db.ErrTooManyDeletions) {
+							slog.Error(fmt.Sprintf("not deleting missing repos of %s: %v", name, err))
+							err = idb.UpsertRepos(ctx, dbRepos)
+						}
+					} else {
+						slog.Warn(fmt.Sprintf("the listing of all Go repos of %s is incomplete: not deleting missing repos", name))
+						err = idb.UpsertRepos(ctx, dbRepos)
+					}
+					if err != nil {
+						return fmt.Errorf("error storing all repos of %s: %v", name, err)
+					}
+					saw += len(h.Repos)
+				}
+				slog.Info(fmt.Sprintf("finished re-indexing all Go repos. saw %d repos", saw))
+				if failed != nil {
 					select {
-					case <-time.After(githubBackoff.Pause()):
+					case <-time.After(githubPause(failed, githubBackoff)):
 						continue
 					case <-grpCtx.Done():
 						return grpCtx.Err()
 					}
 				}
-				if err := idb.StoreRepos(ctx, allRepos); err != nil {
-					return fmt.Errorf("error storing all repos: %v", err)
-				}
-				slog.Info(fmt.Sprintf("finished re-indexing all Go repos. saw %d repos", len(allRepos)))
 			} else {
 				slog.Info(fmt.Sprintf("should re-index all Go repos: no. waiting %v to check again", *allReposReindexWorkCheckPeriod))
 			}
@@ -113,51 +338,85 @@ func main() {
 			// Periodically re-index a repo's tags.
 			logger := slog.With("workerID", workerID)
 			for {
-				repoToReindex, gotWork, err := idb.NextReindexRepoTagsWork(grpCtx, *repoTagsReindexTTL, *repoTagsReindexPeriod)
+				work, err := idb.NextReindexRepoTagsWorkBatch(grpCtx, *repoTagsReindexBatchSize, *repoTagsReindexTTL, *repoTagsReindexPeriod, *repoTagsFullResyncPeriod)
 				if err != nil {
 					return fmt.Errorf("error fetching next reindex repo tags work: %v", err)
 				}
-				if !gotWork {
+				if len(work) == 0 {
 					// Wait with (1s-60s) jitter and check again.
 					jitter := time.Duration((rand.Intn(60) + 1) * 1e9)
 					waitTime := *repoTagsReindexingWorkCheckPeriod + jitter
 					logger.Info(fmt.Sprintf("repo tags re-indexing: no work, waiting %v to check again", waitTime))
 					select {
 					case <-time.After(waitTime):
+					case <-server.reindexWakeups:
 					case <-grpCtx.Done():
 						return grpCtx.Err()
 					}
 					continue
 				}
-				logger.Info(fmt.Sprintf("repo tags re-indexing: got work for repo %s", repoToReindex))
-				repoTags, err := githubSCM.TagsForRepo(grpCtx, repoToReindex)
+				var requests []*vcs.TagsRequest
+				for _, w := range work {
+					logger.Info(fmt.Sprintf("repo tags re-indexing: got work for repo %s (full sync: %v)", w.OrgRepoName, w.FullSync))
+					storedRepoTags, err := idb.FetchAllRepoTags(grpCtx, w.OrgRepoName)
+					if err != nil {
+						return fmt.Errorf("error fetching stored repo tags: %v", err)
+					}
+					knownRepoTags := make(map[string]*vcs.RepoTag)
+					for _, rt := range storedRepoTags {
+						knownRepoTags[rt.TagName] = &vcs.RepoTag{
+							Tag:        rt.TagName,
+							TagDate:    rt.Created,
+							ModulePath: rt.ModulePath,
+							Version:    rt.Version,
+							Rejection:  rt.Rejection,
+							TargetSHA:  rt.TargetSHA,
+						}
+					}
+					requests = append(requests, &vcs.TagsRequest{
+						OrgRepoName: w.OrgRepoName,
+						Known:       knownRepoTags,
+						// Between full syncs, stop fetching once the known tags
+						// are reached, and merge in the new ones.
+						Incremental: !w.FullSync && len(knownRepoTags) > 0,
+					})
+				}
+				results, err := source.TagsForRepos(grpCtx, requests)
 				if err != nil {
 					// TODO(jbarkhuysen): Add some metrics/alerting here.
 					slog.Error(fmt.Sprintf("erroring fetching all repo tags: %v", err))
 					select {
-					case <-time.After(githubBackoff.Pause()):
+					case <-time.After(githubPause(err, githubBackoff)):
 						continue
 					case <-grpCtx.Done():
 						return grpCtx.Err()
 					}
 				}
-				if len(repoTags) == 0 {
-					continue
+				var failed error
+				for _, result := range results {
+					if result.Err != nil {
+						// The repo is retried once its re-index TTL passes.
+						slog.Error(fmt.Sprintf("erroring fetching all repo tags: %v", result.Err))
+						failed = result.Err
+						continue
+					}
+					if err := storeTagsResult(grpCtx, idb, logger, result); err != nil {
+						return err
+					}
 				}
-				var dbRepoTags []*db.RepoTag
-				for _, rt := range repoTags {
-					dbRepoTags = append(dbRepoTags, &db.RepoTag{
-						OrgRepoName: repoToReindex,
-						TagName:     rt.Tag,
-						ModulePath:  rt.ModulePath,
-						Created:     rt.TagDate,
-					})
+				if githubSCM != nil {
+					logger.Info(fmt.Sprintf("repo tags re-indexing: finished re-indexing %d repos... done. github rate limit remaining: %d", len(results), githubSCM.RateLimit().Remaining))
+				} else {
+					logger.Info(fmt.Sprintf("repo tags re-indexing: finished re-indexing %d repos... done", len(results)))
 				}
-				logger.Info(fmt.Sprintf("repo tags re-indexing: finished re-indexing repo %s, got %d tags... storing results", repoToReindex, len(repoTags)))
-				if err := idb.StoreRepoTags(grpCtx, dbRepoTags); err != nil {
-					return fmt.Errorf("error storing repo tags: %v", err)
+				if failed != nil {
+					select {
+					case <-time.After(githubPause(failed, githubBackoff)):
+						continue
+					case <-grpCtx.Done():
+						return grpCtx.Err()
+					}
 				}
-				logger.Info(fmt.Sprintf("repo tags re-indexing: finished re-indexing repo %s, got %d tags... done", repoToReindex, len(repoTags)))
 
 				// Eagerly check for new work rather than waiting again.
 			}
@@ -177,6 +436,86 @@ func main() {
 	slog.Info("shutting down gracefully")
 }
 
+// Stores the tags of a repo fetched by a repo tags re-indexing worker.
+func storeTagsResult(ctx context.Context, idb *db.DB, logger *slog.Logger, result *vcs.TagsResult) error {
+	if result.Complete && len(result.Tags) == 0 {
+		if err := idb.StoreNoRepoTags(ctx, result.OrgRepoName); err != nil {
+			return fmt.Errorf("error storing repo tags: %v", err)
+		}
+		logger.Info(fmt.Sprintf("repo tags re-indexing: finished re-indexing repo %s, got no tags... done", result.OrgRepoName))
+		return nil
+	}
+	var dbRepoTags []*db.RepoTag
+	var rejected int
+	for _, rt := range result.Tags {
+		dbRepoTags = append(dbRepoTags, &db.RepoTag{
+			OrgRepoName: result.OrgRepoName,
+			TagName:     rt.Tag,
+			ModulePath:  rt.ModulePath,
+			Version:     rt.Version,
+			Rejection:   rt.Rejection,
+			Created:     rt.TagDate,
+			TargetSHA:   rt.TargetSHA,
+		})
+		if rt.Rejection != "" {
+			rejected++
+		}
+	}
+	logger.Info(fmt.Sprintf("repo tags re-indexing: finished re-indexing repo %s, got %d tags (%d rejected)... storing results", result.OrgRepoName, len(result.Tags), rejected))
+	var err error
+	if result.Complete {
+		err = idb.StoreRepoTags(ctx, dbRepoTags)
+	} else {
+		err = idb.UpsertRepoTags(ctx, result.OrgRepoName, dbRepoTags)
+	}
+	if err != nil {
+		return fmt.Errorf("error storing repo tags: %v", err)
+	}
+	return nil
+}
+
+// Creates a git SCM for each host of the given comma separated repo URLs.
+// Returns the hosts in the order first seen.
+func newGitSCMs(repoURLs string) ([]string, map[string]*git.GitSCM, error) {
+	var hosts []string
+	paths := make(map[string][]string)
+	useHTTPS := make(map[string]bool)
+	for repoURL := range strings.SplitSeq(repoURLs, ",") {
+		if repoURL = strings.TrimSpace(repoURL); repoURL == "" {
+			continue
+		}
+		u, err := url.Parse(repoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return nil, nil, fmt.Errorf("invalid git repo URL %q: expected ex https://git.mycompany.net/team/repo.git", repoURL)
+		}
+		if _, ok := paths[u.Host]; !ok {
+			hosts = append(hosts, u.Host)
+			useHTTPS[u.Host] = u.Scheme == "https"
+		} else if useHTTPS[u.Host] != (u.Scheme == "https") {
+			return nil, nil, fmt.Errorf("git repo URLs of %s mix http and https", u.Host)
+		}
+		paths[u.Host] = append(paths[u.Host], u.Path)
+	}
+
+	scms := make(map[string]*git.GitSCM)
+	for _, host := range hosts {
+		scms[host] = git.NewGitSCM(host, paths[host], useHTTPS[host])
+	}
+	return hosts, scms, nil
+}
+
+// Returns how long to wait before querying GitHub again after the given error:
+// until the rate limit resets if GitHub rate limited us, and the next backoff
+// pause otherwise.
+func githubPause(err error, backoff *internal.Backoff) time.Duration {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		// Leave a little leeway for clock skew.
+		return max(time.Until(rateLimitErr.ResetAt), 0) + time.Second
+	}
+	return backoff.Pause()
+}
+
 func postgresDetails() (username string, password string, host string, port uint16, dbname string, _ error) {
 	username = os.Getenv("POSTGRES_USERNAME")
 	if username == "" {
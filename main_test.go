@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/git"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewGitSCMs(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		repoURLs  string
+		wantHosts []string
+		wantSCMs  map[string]*git.GitSCM
+		wantErr   bool
+	}{
+		{
+			name:     "none",
+			repoURLs: "",
+			wantSCMs: map[string]*git.GitSCM{},
+		},
+		{
+			name:      "grouped by host",
+			repoURLs:  "https://git.somecompany.net/team/repo1.git, http://other.somecompany.net/repo2,https://git.somecompany.net/team/repo3.git,",
+			wantHosts: []string{"git.somecompany.net", "other.somecompany.net"},
+			wantSCMs: map[string]*git.GitSCM{
+				"git.somecompany.net":   git.NewGitSCM("git.somecompany.net", []string{"/team/repo1.git", "/team/repo3.git"}, true),
+				"other.somecompany.net": git.NewGitSCM("other.somecompany.net", []string{"/repo2"}, false),
+			},
+		},
+		{
+			name:     "mixed http and https",
+			repoURLs: "https://git.somecompany.net/team/repo1.git,http://git.somecompany.net/team/repo2.git",
+			wantErr:  true,
+		},
+		{
+			name:     "not http",
+			repoURLs: "ssh://git.somecompany.net/team/repo1.git",
+			wantErr:  true,
+		},
+		{
+			name:     "no host",
+			repoURLs: "https:///team/repo1.git",
+			wantErr:  true,
+		},
+		{
+			name:     "no path",
+			repoURLs: "https://git.somecompany.net/",
+			wantErr:  true,
+		},
+		{
+			name:     "unparseable",
+			repoURLs: "https://git.somecompany.net/%zz",
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotHosts, gotSCMs, err := newGitSCMs(tc.repoURLs)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("newGitSCMs: expected an error, got hosts %v", gotHosts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.wantHosts, gotHosts); diff != "" {
+				t.Errorf("newGitSCMs: unexpected hosts: -want, +got: %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantSCMs, gotSCMs, cmp.AllowUnexported(git.GitSCM{})); diff != "" {
+				t.Errorf("newGitSCMs: unexpected SCMs: -want, +got: %s", diff)
+			}
+		})
+	}
+}
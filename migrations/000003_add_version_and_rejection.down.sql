@@ -0,0 +1,9 @@
+-- Rejected tags would otherwise be served.
+DELETE FROM repo_tags
+WHERE rejection <> '';
+
+ALTER TABLE repo_tags
+DROP COLUMN rejection;
+
+ALTER TABLE repo_tags
+DROP COLUMN version;
@@ -0,0 +1,33 @@
+-- version stores the module version that the tag denotes, which might be
+-- different from the tag name (ex "v2.0.0+incompatible" for tag "v2.0.0").
+ALTER TABLE repo_tags
+ADD COLUMN version VARCHAR(255)
+NOT NULL
+DEFAULT '';
+
+-- Tags stored before versions were tracked were served as-is. Tags of modules
+-- in subdirectories, ex "tools/cli/v0.1.0", denote the version after the last
+-- slash.
+UPDATE repo_tags
+SET version = regexp_replace(tag_name, '^.*/', '');
+
+-- rejection stores why the tag can't be served as a module version. Tags with
+-- a rejection are kept so that repo owners can see why they're missing from the
+-- index, but aren't served.
+ALTER TABLE repo_tags
+ADD COLUMN rejection TEXT
+NOT NULL
+DEFAULT '';
+
+-- Stored tags that aren't module versions were served until now. They're
+-- rejected right away, the same way as modversion.CheckTag, rather than when
+-- their repo is next re-indexed, which determines the exact reason.
+UPDATE repo_tags
+SET version = '', rejection = tag_name || ' is a pseudo-version'
+WHERE version ~ '^v[0-9]+\.(0\.0-|[0-9]+\.[0-9]+-([^+]*\.)?0\.)[0-9]{14}-[A-Za-z0-9]+$';
+
+-- Numeric identifiers, including in pre-releases, can't have leading zeros.
+UPDATE repo_tags
+SET version = '', rejection = tag_name || ' is not a canonical semantic version'
+WHERE rejection = ''
+AND version !~ '^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-(0|[1-9][0-9]*|[0-9]*[A-Za-z-][0-9A-Za-z-]*)(\.(0|[1-9][0-9]*|[0-9]*[A-Za-z-][0-9A-Za-z-]*))*)?$';
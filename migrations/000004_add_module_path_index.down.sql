@@ -0,0 +1 @@
+DROP INDEX IF EXISTS repo_tags_module_path_idx;
@@ -0,0 +1,2 @@
+-- Module proxy requests look up repo tags by module path.
+CREATE INDEX repo_tags_module_path_idx ON repo_tags (module_path);
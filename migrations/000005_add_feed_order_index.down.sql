@@ -0,0 +1 @@
+DROP INDEX IF EXISTS repo_tags_feed_order_idx;
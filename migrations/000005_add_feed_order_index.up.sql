@@ -0,0 +1,3 @@
+-- The feed is ordered by creation date, with repo and tag name as a tiebreaker
+-- so that paging through it by cursor is stable.
+CREATE INDEX repo_tags_feed_order_idx ON repo_tags (created, org_repo_name, tag_name);
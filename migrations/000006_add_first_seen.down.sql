@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS repo_tags_feed_order_idx;
+CREATE INDEX repo_tags_feed_order_idx ON repo_tags (created, org_repo_name, tag_name);
+
+ALTER TABLE repo_tags
+DROP COLUMN first_seen;
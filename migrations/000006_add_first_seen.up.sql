@@ -0,0 +1,19 @@
+-- first_seen stores when the tag was first stored, as opposed to created, which
+-- stores the tag or commit date. The feed is ordered by first_seen so that tags
+-- pushed today on old commits aren't missed by clients polling with 'since'.
+ALTER TABLE repo_tags
+ADD COLUMN first_seen TIMESTAMP;
+
+-- Tags stored before first_seen was tracked were served by created.
+UPDATE repo_tags
+SET first_seen = created;
+
+-- The default is the time of the insert, not the start of the transaction as
+-- with NOW(), and writers of repo tags hold a lock until they commit (see
+-- StoreRepoTags), so tags are first seen in the order they're committed.
+ALTER TABLE repo_tags
+ALTER COLUMN first_seen SET NOT NULL,
+ALTER COLUMN first_seen SET DEFAULT (clock_timestamp() AT TIME ZONE 'UTC');
+
+DROP INDEX IF EXISTS repo_tags_feed_order_idx;
+CREATE INDEX repo_tags_feed_order_idx ON repo_tags (first_seen, org_repo_name, tag_name);
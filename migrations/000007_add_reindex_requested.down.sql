@@ -0,0 +1,2 @@
+ALTER TABLE repos
+DROP COLUMN reindex_requested;
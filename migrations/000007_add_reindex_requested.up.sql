@@ -0,0 +1,7 @@
+-- reindex_requested stores when a repo's tags were last asked to be re-indexed
+-- ahead of schedule, ex because a webhook reported a tag push. Workers should
+-- re-index a repo's tags when, in addition to the usual schedule:
+--     reindex_requested > indexing_began, and
+--     the repo isn't being indexed right now
+ALTER TABLE repos
+ADD COLUMN reindex_requested TIMESTAMP NOT NULL DEFAULT TIMESTAMP '-infinity';
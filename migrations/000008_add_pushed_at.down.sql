@@ -0,0 +1,5 @@
+ALTER TABLE repos
+DROP COLUMN pushed_at,
+DROP COLUMN tag_count,
+DROP COLUMN indexed_pushed_at,
+DROP COLUMN indexed_tag_count;
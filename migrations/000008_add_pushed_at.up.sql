@@ -0,0 +1,14 @@
+-- pushed_at and tag_count store when a repo was last pushed to and how many
+-- tags it has, as of the last time the list of all repos was re-indexed. NULL
+-- when unknown.
+--
+-- indexed_pushed_at and indexed_tag_count store the same, as of the last time
+-- indexing of the repo's tags began. Workers should only re-index a repo's
+-- tags on the usual schedule when they differ, or when the last indexing
+-- didn't finish. Otherwise, they're only re-indexed at the much slower full
+-- resync period.
+ALTER TABLE repos
+ADD COLUMN pushed_at TIMESTAMP,
+ADD COLUMN tag_count INTEGER,
+ADD COLUMN indexed_pushed_at TIMESTAMP,
+ADD COLUMN indexed_tag_count INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE repo_tags
+DROP COLUMN target_sha;
@@ -0,0 +1,5 @@
+-- target_sha stores the SHA of the commit a tag points to, so that re-indexing
+-- only fetches go.mod files for tags that are new or were moved. Empty when
+-- unknown, in which case the go.mod file is always fetched.
+ALTER TABLE repo_tags
+ADD COLUMN target_sha VARCHAR(64) NOT NULL DEFAULT '';
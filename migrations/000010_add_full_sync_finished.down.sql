@@ -0,0 +1,2 @@
+ALTER TABLE repos
+DROP COLUMN full_sync_finished;
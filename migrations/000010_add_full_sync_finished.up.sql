@@ -0,0 +1,8 @@
+-- full_sync_finished stores when all of a repo's tags were last indexed, as
+-- opposed to indexing_finished, which is also set when only the newest tags
+-- were indexed. Workers should index all of a repo's tags, and delete the ones
+-- that are gone, once the full resync period has passed since.
+ALTER TABLE repos
+ADD COLUMN full_sync_finished TIMESTAMP NOT NULL DEFAULT '-infinity';
+
+UPDATE repos SET full_sync_finished = indexing_finished;
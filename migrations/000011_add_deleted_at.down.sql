@@ -0,0 +1,5 @@
+ALTER TABLE repo_tags
+DROP COLUMN deleted_at;
+
+ALTER TABLE repos
+DROP COLUMN deleted_at;
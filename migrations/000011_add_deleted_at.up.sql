@@ -0,0 +1,9 @@
+-- deleted_at stores when a repo, or its tag, was soft-deleted because the repo
+-- was missing from a complete listing of all repos. Deleted repos aren't
+-- indexed and their tags aren't served, but both are restored if the repo is
+-- listed again. NULL if not deleted.
+ALTER TABLE repos
+ADD COLUMN deleted_at TIMESTAMP;
+
+ALTER TABLE repo_tags
+ADD COLUMN deleted_at TIMESTAMP;
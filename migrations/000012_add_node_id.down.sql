@@ -0,0 +1,10 @@
+DROP TABLE repo_moves;
+
+ALTER TABLE repo_tags
+DROP CONSTRAINT repo_tags_org_repo_name_fkey,
+ADD CONSTRAINT repo_tags_org_repo_name_fkey FOREIGN KEY (org_repo_name) REFERENCES repos(org_repo_name);
+
+DROP INDEX repos_node_id_idx;
+
+ALTER TABLE repos
+DROP COLUMN node_id;
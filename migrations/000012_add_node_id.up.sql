@@ -0,0 +1,29 @@
+-- node_id stores the repo's GitHub node ID, which stays the same when the repo
+-- is renamed or transferred to another org. NULL if unknown, ex for repos only
+-- seen in webhooks so far.
+ALTER TABLE repos
+ADD COLUMN node_id VARCHAR(100);
+
+CREATE UNIQUE INDEX repos_node_id_idx ON repos (node_id);
+
+-- Renaming a repo renames its tags along with it.
+ALTER TABLE repo_tags
+DROP CONSTRAINT repo_tags_org_repo_name_fkey,
+ADD CONSTRAINT repo_tags_org_repo_name_fkey FOREIGN KEY (org_repo_name) REFERENCES repos(org_repo_name) ON UPDATE CASCADE;
+
+-- Every rename or transfer of a repo, oldest first. Both the path history of
+-- each repo and the feed of moves served to downstream consumers.
+CREATE TABLE repo_moves (
+    id BIGSERIAL PRIMARY KEY,
+
+    -- NULL if the repo's node ID wasn't known when it moved.
+    node_id VARCHAR(100),
+
+    -- Something like "corp/my-repo".
+    from_org_repo_name VARCHAR(200) NOT NULL,
+    to_org_repo_name VARCHAR(200) NOT NULL,
+
+    moved_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX repo_moves_moved_at_idx ON repo_moves (moved_at, id);
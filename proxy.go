@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/db"
+	"github.com/Netflix-Skunkworks/golang-index/internal/modversion"
+	"golang.org/x/exp/slog"
+	"golang.org/x/mod/modfile"
+	gomodule "golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+)
+
+// Exists to allow tests to mock the SCM.
+type moduleSource interface {
+	GoMod(ctx context.Context, orgRepoName, tag, dir string) (_ []byte, found bool, _ error)
+	Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error)
+}
+
+// The response to .info and @latest requests.
+type versionInfo struct {
+	Version string `json:"Version"`
+	Time    string `json:"Time"`
+}
+
+// Whether the given URL path is a module proxy request, as described at
+// https://go.dev/ref/mod#goproxy-protocol.
+func isProxyPath(urlPath string) bool {
+	return strings.Contains(urlPath, "/@v/") || strings.HasSuffix(urlPath, "/@latest")
+}
+
+// Serves the GOPROXY protocol for indexed modules: $module/@v/list,
+// $module/@v/$version.info, $module/@v/$version.mod, $module/@v/$version.zip,
+// and $module/@latest.
+func (s *server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	urlPath := strings.TrimPrefix(r.URL.Path, "/")
+
+	var escapedModulePath, request string
+	if before, ok := strings.CutSuffix(urlPath, "/@latest"); ok {
+		escapedModulePath, request = before, "@latest"
+	} else if before, after, ok := strings.Cut(urlPath, "/@v/"); ok {
+		escapedModulePath, request = before, after
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	modulePath, err := gomodule.UnescapePath(escapedModulePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid module path %s: %v", escapedModulePath, err), http.StatusBadRequest)
+		return
+	}
+
+	repoTags, err := s.idb.FetchModuleVersions(r.Context(), modulePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error fetching module versions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(repoTags) == 0 {
+		http.Error(w, fmt.Sprintf("unknown module %s", modulePath), http.StatusNotFound)
+		return
+	}
+
+	switch request {
+	case "list":
+		s.handleProxyList(w, repoTags)
+		return
+	case "@latest":
+		s.handleProxyInfo(w, latestVersion(repoTags))
+		return
+	}
+
+	ext := path.Ext(request)
+	version, err := gomodule.UnescapeVersion(strings.TrimSuffix(request, ext))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid version %s: %v", request, err), http.StatusBadRequest)
+		return
+	}
+	var repoTag *db.RepoTag
+	for _, rt := range repoTags {
+		if rt.Version == version {
+			repoTag = rt
+			break
+		}
+	}
+	if repoTag == nil {
+		http.Error(w, fmt.Sprintf("unknown version %s of module %s", version, modulePath), http.StatusNotFound)
+		return
+	}
+
+	switch ext {
+	case ".info":
+		s.handleProxyInfo(w, repoTag)
+	case ".mod":
+		s.handleProxyMod(w, r, repoTag)
+	case ".zip":
+		s.handleProxyZip(w, r, repoTag)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) handleProxyList(w http.ResponseWriter, repoTags []*db.RepoTag) {
+	var b strings.Builder
+	for _, rt := range repoTags {
+		b.WriteString(rt.Version)
+		b.WriteString("\n")
+	}
+	if _, err := fmt.Fprint(w, b.String()); err != nil {
+		slog.Error(fmt.Sprintf("error writing module version list: %v", err))
+	}
+}
+
+func (s *server) handleProxyInfo(w http.ResponseWriter, repoTag *db.RepoTag) {
+	out, err := json.Marshal(&versionInfo{
+		Version: repoTag.Version,
+		Time:    repoTag.Created.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error marshalling response for %v: %v", repoTag, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(out); err != nil {
+		slog.Error(fmt.Sprintf("error writing module version info: %v", err))
+	}
+}
+
+func (s *server) handleProxyMod(w http.ResponseWriter, r *http.Request, repoTag *db.RepoTag) {
+	_, goMod, found, err := s.moduleGoMod(r.Context(), repoTag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error fetching go.mod: %v", err), http.StatusBadGateway)
+		return
+	}
+	if !found {
+		// Modules without a go.mod file get a synthesized one, the same way
+		// the go command does.
+		goMod = fmt.Appendf(nil, "module %s\n", modfile.AutoQuote(repoTag.ModulePath))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := w.Write(goMod); err != nil {
+		slog.Error(fmt.Sprintf("error writing go.mod: %v", err))
+	}
+}
+
+func (s *server) handleProxyZip(w http.ResponseWriter, r *http.Request, repoTag *db.RepoTag) {
+	zipball, err := s.source.Zipball(r.Context(), repoTag.OrgRepoName, repoTag.TagName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error fetching zipball: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	dir, _, _, err := s.moduleGoMod(r.Context(), repoTag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error fetching go.mod: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := moduleZipFromZipball(&buf, gomodule.Version{Path: repoTag.ModulePath, Version: repoTag.Version}, zipball, dir); err != nil {
+		http.Error(w, fmt.Sprintf("error creating module zip: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	if _, err := io.Copy(w, &buf); err != nil {
+		slog.Error(fmt.Sprintf("error writing module zip: %v", err))
+	}
+}
+
+// Returns the repo subdirectory of the given module version, and its go.mod
+// file. Like the go command, v2+ versions of modules with a major version
+// suffix, ex "/v2", may be in the major version subdirectory, ex "v2": see
+// modversion.MajorSubdir.
+func (s *server) moduleGoMod(ctx context.Context, repoTag *db.RepoTag) (dir string, _ []byte, found bool, _ error) {
+	dir, _ = modversion.Split(repoTag.TagName)
+	goMod, found, err := s.source.GoMod(ctx, repoTag.OrgRepoName, repoTag.TagName, dir)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	sub := modversion.MajorSubdir(repoTag.Version)
+	if _, pathMajor, _ := gomodule.SplitPathVersion(repoTag.ModulePath); sub == "" || pathMajor != "/"+sub {
+		return dir, goMod, found, nil
+	}
+	if found && modfile.ModulePath(goMod) == repoTag.ModulePath {
+		return dir, goMod, found, nil
+	}
+	subDir := path.Join(dir, sub)
+	subGoMod, subFound, err := s.source.GoMod(ctx, repoTag.OrgRepoName, repoTag.TagName, subDir)
+	if err != nil {
+		return "", nil, false, err
+	}
+	if subFound && modfile.ModulePath(subGoMod) == repoTag.ModulePath {
+		return subDir, subGoMod, true, nil
+	}
+	return dir, goMod, found, nil
+}
+
+// Returns the version that @latest should resolve to: the highest release
+// version if there is one, otherwise the highest pre-release version.
+func latestVersion(repoTags []*db.RepoTag) *db.RepoTag {
+	var latest *db.RepoTag
+	for _, rt := range repoTags {
+		if latest == nil {
+			latest = rt
+			continue
+		}
+		latestIsPrerelease, isPrerelease := semver.Prerelease(latest.Version) != "", semver.Prerelease(rt.Version) != ""
+		if latestIsPrerelease && !isPrerelease {
+			latest = rt
+		} else if latestIsPrerelease == isPrerelease && semver.Compare(rt.Version, latest.Version) > 0 {
+			latest = rt
+		}
+	}
+	return latest
+}
+
+// Writes a module zip for module version m to w, built from the files of the
+// given SCM zipball that are in the repo subdirectory dir.
+//
+// SCM zipballs contain a single top-level directory, which is stripped. Files
+// that don't belong in the module (ex nested modules and vendor directories)
+// are left out by golang.org/x/mod/zip.
+func moduleZipFromZipball(w io.Writer, m gomodule.Version, zipball []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(zipball), int64(len(zipball)))
+	if err != nil {
+		return fmt.Errorf("error reading zipball: %v", err)
+	}
+
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	var files []modzip.File
+	var rootLicense modzip.File
+	hasLicense := false
+	for _, f := range zr.File {
+		// Strip the top-level directory.
+		_, repoPath, ok := strings.Cut(f.Name, "/")
+		if !ok || repoPath == "" || strings.HasSuffix(repoPath, "/") {
+			continue
+		}
+		if dir != "" && repoPath == "LICENSE" {
+			rootLicense = zipballFile{f: f, path: "LICENSE"}
+		}
+		modPath, ok := strings.CutPrefix(repoPath, prefix)
+		if !ok {
+			continue
+		}
+		if modPath == "LICENSE" {
+			hasLicense = true
+		}
+		files = append(files, zipballFile{f: f, path: modPath})
+	}
+	// Nested modules without a LICENSE file get the one at the repo root, the
+	// same way the go command does.
+	if !hasLicense && rootLicense != nil {
+		files = append(files, rootLicense)
+	}
+
+	return modzip.Create(w, m, files)
+}
+
+// A file in an SCM zipball, implementing golang.org/x/mod/zip.File.
+type zipballFile struct {
+	f    *zip.File
+	path string
+}
+
+func (f zipballFile) Path() string { return f.path }
+
+func (f zipballFile) Lstat() (fs.FileInfo, error) { return f.f.FileInfo(), nil }
+
+func (f zipballFile) Open() (io.ReadCloser, error) { return f.f.Open() }
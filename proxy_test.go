@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/db"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeSource struct {
+	// go.mod contents keyed by "tag:dir".
+	goMods map[string]string
+
+	// Zipball contents keyed by tag, each as a map of file path to contents.
+	zipballs map[string]map[string]string
+}
+
+func (fake *fakeSource) GoMod(ctx context.Context, orgRepoName, tag, dir string) ([]byte, bool, error) {
+	goMod, ok := fake.goMods[tag+":"+dir]
+	if !ok {
+		return nil, false, nil
+	}
+	return []byte(goMod), true, nil
+}
+
+func (fake *fakeSource) Zipball(ctx context.Context, orgRepoName, tag string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, contents := range fake.zipballs[tag] {
+		f, err := zw.Create("someorg-repo1-abcdef/" + name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(contents)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestHandleProxy(t *testing.T) {
+	fakeTags := []*db.RepoTag{
+		{OrgRepoName: "someorg/repo1", TagName: "v1.0.0", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v1.0.0", Created: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "v1.1.0-rc.1", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v1.1.0-rc.1", Created: time.Date(2025, 2, 3, 4, 5, 6, 7, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "v0.9.0", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.9.0", Created: time.Date(2025, 3, 4, 5, 6, 7, 8, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "tools/CLI/v0.4.0", ModulePath: "github.somecompany.net/someorg/repo1/tools/CLI", Version: "v0.4.0", Created: time.Date(2025, 4, 5, 6, 7, 8, 9, time.UTC)},
+		{OrgRepoName: "someorg/repo2", TagName: "v2.0.0", ModulePath: "github.somecompany.net/someorg/repo2", Version: "v2.0.0+incompatible", Created: time.Date(2025, 5, 6, 7, 8, 9, 10, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "v2.1.0", ModulePath: "github.somecompany.net/someorg/repo1/v2", Version: "v2.1.0", Created: time.Date(2025, 6, 7, 8, 9, 10, 11, time.UTC)},
+	}
+	source := &fakeSource{
+		goMods: map[string]string{
+			"v1.0.0:":                    "module github.somecompany.net/someorg/repo1\n",
+			"tools/CLI/v0.4.0:tools/CLI": "module github.somecompany.net/someorg/repo1/tools/CLI\n",
+			"v2.1.0:":                    "module github.somecompany.net/someorg/repo1\n",
+			"v2.1.0:v2":                  "module github.somecompany.net/someorg/repo1/v2\n",
+		},
+		zipballs: map[string]map[string]string{
+			"v1.0.0": {
+				"LICENSE":                 "license",
+				"go.mod":                  "module github.somecompany.net/someorg/repo1\n",
+				"foo.go":                  "package foo",
+				"tools/CLI/go.mod":        "module github.somecompany.net/someorg/repo1/tools/CLI\n",
+				"tools/CLI/main.go":       "package main",
+				"vendor/example.com/a.go": "package a",
+			},
+			"v2.1.0": {
+				"LICENSE":   "license",
+				"go.mod":    "module github.somecompany.net/someorg/repo1\n",
+				"foo.go":    "package foo",
+				"v2/go.mod": "module github.somecompany.net/someorg/repo1/v2\n",
+				"v2/foo.go": "package foo",
+			},
+			"tools/CLI/v0.4.0": {
+				"LICENSE":           "license",
+				"go.mod":            "module github.somecompany.net/someorg/repo1\n",
+				"foo.go":            "package foo",
+				"tools/CLI/go.mod":  "module github.somecompany.net/someorg/repo1/tools/CLI\n",
+				"tools/CLI/main.go": "package main",
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		path           string
+		wantStatusCode int
+		wantResponse   string
+		wantZipFiles   []string
+	}{
+		{
+			name:           "list",
+			path:           "/github.somecompany.net/someorg/repo1/@v/list",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   "v1.0.0\nv1.1.0-rc.1\nv0.9.0\n",
+		},
+		{
+			name:           "list of unknown module",
+			path:           "/github.somecompany.net/someorg/unknown/@v/list",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "info",
+			path:           "/github.somecompany.net/someorg/repo1/@v/v1.0.0.info",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   `{"Version":"v1.0.0","Time":"2025-01-02T03:04:05Z"}`,
+		},
+		{
+			name:           "info of unknown version",
+			path:           "/github.somecompany.net/someorg/repo1/@v/v1.2.3.info",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "info of incompatible version",
+			path:           "/github.somecompany.net/someorg/repo2/@v/v2.0.0+incompatible.info",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   `{"Version":"v2.0.0+incompatible","Time":"2025-05-06T07:08:09Z"}`,
+		},
+		{
+			name:           "latest prefers releases over pre-releases",
+			path:           "/github.somecompany.net/someorg/repo1/@latest",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   `{"Version":"v1.0.0","Time":"2025-01-02T03:04:05Z"}`,
+		},
+		{
+			name:           "mod",
+			path:           "/github.somecompany.net/someorg/repo1/@v/v1.0.0.mod",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   "module github.somecompany.net/someorg/repo1\n",
+		},
+		{
+			name:           "mod of nested module with escaped path",
+			path:           "/github.somecompany.net/someorg/repo1/tools/!c!l!i/@v/v0.4.0.mod",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   "module github.somecompany.net/someorg/repo1/tools/CLI\n",
+		},
+		{
+			name:           "mod is synthesized without go.mod",
+			path:           "/github.somecompany.net/someorg/repo2/@v/v2.0.0+incompatible.mod",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   "module github.somecompany.net/someorg/repo2\n",
+		},
+		{
+			name:           "zip leaves out nested modules and vendor",
+			path:           "/github.somecompany.net/someorg/repo1/@v/v1.0.0.zip",
+			wantStatusCode: http.StatusOK,
+			wantZipFiles: []string{
+				"github.somecompany.net/someorg/repo1@v1.0.0/LICENSE",
+				"github.somecompany.net/someorg/repo1@v1.0.0/foo.go",
+				"github.somecompany.net/someorg/repo1@v1.0.0/go.mod",
+			},
+		},
+		{
+			name:           "zip of nested module includes root LICENSE",
+			path:           "/github.somecompany.net/someorg/repo1/tools/!c!l!i/@v/v0.4.0.zip",
+			wantStatusCode: http.StatusOK,
+			wantZipFiles: []string{
+				"github.somecompany.net/someorg/repo1/tools/CLI@v0.4.0/LICENSE",
+				"github.somecompany.net/someorg/repo1/tools/CLI@v0.4.0/go.mod",
+				"github.somecompany.net/someorg/repo1/tools/CLI@v0.4.0/main.go",
+			},
+		},
+		{
+			name:           "mod of module in major version subdirectory",
+			path:           "/github.somecompany.net/someorg/repo1/v2/@v/v2.1.0.mod",
+			wantStatusCode: http.StatusOK,
+			wantResponse:   "module github.somecompany.net/someorg/repo1/v2\n",
+		},
+		{
+			name:           "zip of module in major version subdirectory",
+			path:           "/github.somecompany.net/someorg/repo1/v2/@v/v2.1.0.zip",
+			wantStatusCode: http.StatusOK,
+			wantZipFiles: []string{
+				"github.somecompany.net/someorg/repo1/v2@v2.1.0/LICENSE",
+				"github.somecompany.net/someorg/repo1/v2@v2.1.0/foo.go",
+				"github.somecompany.net/someorg/repo1/v2@v2.1.0/go.mod",
+			},
+		},
+		{
+			name:           "invalid escaped module path",
+			path:           "/github.somecompany.net/someorg/Repo1/@v/list",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newServer(0, &fakeDB{repoTagsToReturn: fakeTags}, "github.somecompany.net", source, "")
+
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			recorder := httptest.NewRecorder()
+
+			s.handleRoot(recorder, request)
+
+			if tc.wantStatusCode != recorder.Code {
+				t.Fatalf("wanted status code %d, got %d: %s", tc.wantStatusCode, recorder.Code, recorder.Body.String())
+			}
+			if tc.wantStatusCode != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(recorder.Body)
+			if err != nil {
+				t.Fatalf("unexpected error while reading recorder body: %v", err)
+			}
+			if tc.wantZipFiles != nil {
+				zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				if err != nil {
+					t.Fatalf("error reading module zip: %v", err)
+				}
+				var gotZipFiles []string
+				for _, f := range zr.File {
+					gotZipFiles = append(gotZipFiles, f.Name)
+				}
+				slices.Sort(gotZipFiles)
+				if diff := cmp.Diff(tc.wantZipFiles, gotZipFiles); diff != "" {
+					t.Errorf("unexpected module zip files: -want, +got: %s", diff)
+				}
+				return
+			}
+			if tc.wantResponse != string(body) {
+				t.Errorf("unexpected reponse: -want, +got: %s", cmp.Diff(tc.wantResponse, string(body)))
+			}
+		})
+	}
+}
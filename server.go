@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,21 +16,49 @@ import (
 	"golang.org/x/exp/slog"
 )
 
-const defaultNumberOfOutputs = int64(2000)
+const (
+	defaultNumberOfOutputs = int64(2000)
+
+	// The most repo tags served in a single index response, whatever 'limit'
+	// asks for.
+	maxNumberOfOutputs = int64(2000)
+
+	// How many index lines are written between flushes, so that clients get
+	// the first lines without waiting for the whole response.
+	flushEvery = 100
+)
 
 // Exists to allow tests to mock the db.
 type idb interface {
-	FetchRepoTags(ctx context.Context, since time.Time, limit int64) ([]*db.RepoTag, error)
+	FetchRepoTags(ctx context.Context, since time.Time, limit int64) iter.Seq2[*db.RepoTag, error]
+	FetchRepoTagsAfter(ctx context.Context, after db.FeedCursor, limit int64) iter.Seq2[*db.RepoTag, error]
+	FetchRejectedRepoTags(ctx context.Context, orgRepoName string) ([]*db.RepoTag, error)
+	FetchModuleVersions(ctx context.Context, modulePath string) ([]*db.RepoTag, error)
+	UpsertRepos(ctx context.Context, repos []*db.Repo) error
+	RequestReindex(ctx context.Context, orgRepoName string) (known bool, _ error)
+	RenameRepo(ctx context.Context, fromOrgRepoName, toOrgRepoName string) (moved bool, _ error)
+	SoftDeleteRepo(ctx context.Context, orgRepoName string) error
+	FetchRepoMoves(ctx context.Context, since time.Time, limit int64) ([]*db.RepoMove, error)
+	FetchRepoPathHistory(ctx context.Context, orgRepoName string) ([]*db.RepoMove, error)
 }
 
 type server struct {
 	port           int
 	idb            idb
 	githubHostName string
+	source         moduleSource
+
+	// The secret GitHub webhook deliveries are signed with. Webhooks aren't
+	// served if empty.
+	webhookSecret string
+
+	// Receives a value when a webhook asks for a repo to be re-indexed. See
+	// wakeReindexWorker.
+	reindexWakeups chan struct{}
 }
 
-func newServer(port int, idb idb, githubHostName string) *server {
-	return &server{port: port, idb: idb, githubHostName: githubHostName}
+func newServer(port int, idb idb, githubHostName string, source moduleSource, webhookSecret string) *server {
+	return &server{port: port, idb: idb, githubHostName: githubHostName, source: source, webhookSecret: webhookSecret, reindexWakeups: make(chan struct{}, 1)}
 }
 
 type module struct {
@@ -36,6 +67,20 @@ type module struct {
 	Timestamp string `json:"Timestamp"`
 }
 
+// The response trailer holding the cursor of the last repo tag in the response.
+// Passing it back as the 'cursor' param continues from where the response left
+// off. It's a trailer rather than a header since the last repo tag is only known
+// once the whole response has been written.
+const cursorTrailer = "Index-Cursor"
+
+// Serves the index. Supports the same 'since' and 'limit' params as
+// https://index.golang.org/, as well as a 'cursor' param: see cursorTrailer.
+// When both 'since' and 'cursor' are given, 'cursor' takes precedence.
+//
+// Repo tags are streamed from the database as they're read. If an error happens
+// after part of the response has been written, the connection is aborted rather
+// than ending the response normally, so that clients don't mistake a partial
+// response for a complete one.
 func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	var since time.Time
 	var err error
@@ -47,26 +92,142 @@ func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var cursor *db.FeedCursor
+	if cursorParam := r.URL.Query().Get("cursor"); cursorParam != "" {
+		c, err := decodeCursor(cursorParam)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error converting 'cursor' param %s: %v", cursorParam, err), http.StatusBadRequest)
+			return
+		}
+		cursor = &c
+	}
+
 	limit := defaultNumberOfOutputs
 	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
 		if limit, err = strconv.ParseInt(limitParam, 10, 64); err != nil {
 			http.Error(w, fmt.Sprintf("error converting 'limit' param %s: %v", limitParam, err), http.StatusBadRequest)
 			return
 		}
+		if limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid 'limit' param %s: must not be negative", limitParam), http.StatusBadRequest)
+			return
+		}
 	}
+	limit = min(limit, maxNumberOfOutputs)
+
+	var repoTags iter.Seq2[*db.RepoTag, error]
+	if cursor != nil {
+		repoTags = s.idb.FetchRepoTagsAfter(r.Context(), *cursor, limit)
+	} else {
+		repoTags = s.idb.FetchRepoTags(r.Context(), since, limit)
+	}
+
+	w.Header().Set("Trailer", cursorTrailer)
+	w.Header().Set("Content-Type", "application/json")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var last *db.RepoTag
+	n := 0
+	for rt, err := range repoTags {
+		if err != nil {
+			if n == 0 {
+				http.Error(w, fmt.Sprintf("error fetching repo tags: %v", err), http.StatusInternalServerError)
+				return
+			}
+			slog.Error(fmt.Sprintf("error fetching repo tags after writing %d of them, aborting response: %v", n, err))
+			panic(http.ErrAbortHandler)
+		}
 
-	repoTags, err := s.idb.FetchRepoTags(r.Context(), since, limit)
+		if err := enc.Encode(&module{
+			Path:      rt.ModulePath,
+			Version:   rt.Version,
+			Timestamp: rt.FirstSeen.Format(time.RFC3339),
+		}); err != nil {
+			// The client has most likely gone away, so there's no one left to
+			// tell.
+			slog.Error(fmt.Sprintf("error writing response: %v", err))
+			return
+		}
+		last = rt
+		n++
+
+		if n%flushEvery == 0 {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				slog.Error(fmt.Sprintf("error flushing response: %v", err))
+				return
+			}
+		}
+	}
+
+	// With no new repo tags, the client should continue from where it is.
+	if last != nil {
+		w.Header().Set(cursorTrailer, encodeCursor(last.FeedCursor()))
+	} else if cursor != nil {
+		w.Header().Set(cursorTrailer, encodeCursor(*cursor))
+	}
+}
+
+// The JSON form of a db.FeedCursor. Field names are kept short, since cursors
+// are passed around in URLs.
+type jsonCursor struct {
+	FirstSeen   string `json:"c"`
+	OrgRepoName string `json:"r"`
+	TagName     string `json:"t"`
+}
+
+// Encodes the cursor as an opaque, URL-safe string.
+func encodeCursor(c db.FeedCursor) string {
+	// Marshalling strings can't fail.
+	out, _ := json.Marshal(&jsonCursor{
+		FirstSeen:   c.FirstSeen.UTC().Format(time.RFC3339Nano),
+		OrgRepoName: c.OrgRepoName,
+		TagName:     c.TagName,
+	})
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// Decodes a cursor encoded with encodeCursor.
+func decodeCursor(s string) (db.FeedCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return db.FeedCursor{}, fmt.Errorf("malformed cursor: %v", err)
+	}
+	var jc jsonCursor
+	if err := json.Unmarshal(b, &jc); err != nil {
+		return db.FeedCursor{}, fmt.Errorf("malformed cursor: %v", err)
+	}
+	firstSeen, err := time.Parse(time.RFC3339Nano, jc.FirstSeen)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error fetching repo tags: %v", err), http.StatusInternalServerError)
+		return db.FeedCursor{}, fmt.Errorf("malformed cursor: %v", err)
+	}
+	return db.FeedCursor{FirstSeen: firstSeen, OrgRepoName: jc.OrgRepoName, TagName: jc.TagName}, nil
+}
+
+type rejectedTag struct {
+	Tag    string `json:"Tag"`
+	Reason string `json:"Reason"`
+}
+
+// Serves the tags of a repo that aren't in the index, and why, so that repo
+// owners can find out why their versions are missing.
+func (s *server) handleRejected(w http.ResponseWriter, r *http.Request) {
+	orgRepoName := r.URL.Query().Get("repo")
+	if orgRepoName == "" {
+		http.Error(w, "missing 'repo' param: should be of the form org/repo", http.StatusBadRequest)
+		return
+	}
+
+	repoTags, err := s.idb.FetchRejectedRepoTags(r.Context(), orgRepoName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error fetching rejected repo tags: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	var lines []string
 	for _, rt := range repoTags {
-		out, err := json.Marshal(&module{
-			Path:      rt.ModulePath,
-			Version:   rt.TagName,
-			Timestamp: rt.Created.Format(time.RFC3339),
+		out, err := json.Marshal(&rejectedTag{
+			Tag:    rt.TagName,
+			Reason: rt.Rejection,
 		})
 		if err != nil {
 			http.Error(w, fmt.Sprintf("error marshalling response for %v: %v", rt, err), http.StatusInternalServerError)
@@ -82,8 +243,86 @@ func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type repoMove struct {
+	// The module path prefixes the repo moved from and to, ex
+	// "github.somecompany.net/someorg/repo1".
+	From      string `json:"From"`
+	To        string `json:"To"`
+	Timestamp string `json:"Timestamp"`
+}
+
+// Serves repo renames and transfers, oldest first, so that consumers can follow
+// modules to their new paths. With a 'repo' param, serves the moves that led to
+// that repo's current name. Otherwise, supports the same 'since' and 'limit'
+// params as the index.
+func (s *server) handleMoves(w http.ResponseWriter, r *http.Request) {
+	var moves []*db.RepoMove
+	var err error
+	if orgRepoName := r.URL.Query().Get("repo"); orgRepoName != "" {
+		moves, err = s.idb.FetchRepoPathHistory(r.Context(), orgRepoName)
+	} else {
+		var since time.Time
+		if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+			since, err = time.Parse(time.RFC3339, sinceParam)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("error converting 'since' param %s: %v", sinceParam, err), http.StatusBadRequest)
+				return
+			}
+		}
+		limit := defaultNumberOfOutputs
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			if limit, err = strconv.ParseInt(limitParam, 10, 64); err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid 'limit' param %s: must be a non-negative integer", limitParam), http.StatusBadRequest)
+				return
+			}
+		}
+		moves, err = s.idb.FetchRepoMoves(r.Context(), since, min(limit, maxNumberOfOutputs))
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error fetching repo moves: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	for _, m := range moves {
+		if err := enc.Encode(&repoMove{
+			From:      s.repoPath(m.FromOrgRepoName),
+			To:        s.repoPath(m.ToOrgRepoName),
+			Timestamp: m.MovedAt.Format(time.RFC3339),
+		}); err != nil {
+			slog.Error(fmt.Sprintf("error writing response: %v", err))
+			return
+		}
+	}
+}
+
+// Returns the host and path of the given repo, ex
+// "github.somecompany.net/someorg/repo1". Repos of hosts other than GitHub are
+// already named after their host: see vcs.Multi.
+func (s *server) repoPath(orgRepoName string) string {
+	if host, _, _ := strings.Cut(orgRepoName, "/"); strings.Contains(host, ".") {
+		return orgRepoName
+	}
+	return s.githubHostName + "/" + orgRepoName
+}
+
+// Routes module proxy requests to the proxy, and everything else to the index.
+func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if isProxyPath(r.URL.Path) {
+		s.handleProxy(w, r)
+		return
+	}
+	s.handleIndex(w, r)
+}
+
 func (s *server) listenAndServe() error {
-	http.HandleFunc("/", s.handleIndex)
+	http.HandleFunc("/", s.handleRoot)
+	http.HandleFunc("/rejected", s.handleRejected)
+	http.HandleFunc("/moves", s.handleMoves)
+	if s.webhookSecret != "" {
+		http.HandleFunc("/webhook", s.handleWebhook)
+	}
 	slog.Info(fmt.Sprintf("Server listening on :%d\n", s.port))
 	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), nil)
 }
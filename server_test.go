@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,26 +19,142 @@ import (
 
 type fakeDB struct {
 	repoTagsToReturn []*db.RepoTag
+
+	// If set, yielded by the feed after repoTagsToReturn.
+	feedErr error
+
+	// The limit passed to the last feed fetch.
+	gotLimit int64
+
+	// Repos that RequestReindex and RenameRepo know about.
+	knownRepos []string
+
+	// Calls to the methods that change repos, ex "RenameRepo(a/b, c/d)".
+	gotRepoChanges []string
+
+	movesToReturn []*db.RepoMove
 }
 
-func (fake *fakeDB) FetchRepoTags(ctx context.Context, since time.Time, limit int64) ([]*db.RepoTag, error) {
-	return fake.repoTagsToReturn, nil
+func (fake *fakeDB) FetchRepoTags(ctx context.Context, since time.Time, limit int64) iter.Seq2[*db.RepoTag, error] {
+	fake.gotLimit = limit
+	return fake.feed(fake.repoTagsToReturn)
+}
+
+func (fake *fakeDB) FetchRepoTagsAfter(ctx context.Context, after db.FeedCursor, limit int64) iter.Seq2[*db.RepoTag, error] {
+	fake.gotLimit = limit
+	var repoTags []*db.RepoTag
+	for _, rt := range fake.repoTagsToReturn {
+		c := rt.FeedCursor()
+		if c.FirstSeen.After(after.FirstSeen) || c.FirstSeen.Equal(after.FirstSeen) && (c.OrgRepoName > after.OrgRepoName || c.OrgRepoName == after.OrgRepoName && c.TagName > after.TagName) {
+			repoTags = append(repoTags, rt)
+		}
+	}
+	return fake.feed(repoTags)
+}
+
+func (fake *fakeDB) feed(repoTags []*db.RepoTag) iter.Seq2[*db.RepoTag, error] {
+	return func(yield func(*db.RepoTag, error) bool) {
+		for _, rt := range repoTags {
+			if !yield(rt, nil) {
+				return
+			}
+		}
+		if fake.feedErr != nil {
+			yield(nil, fake.feedErr)
+		}
+	}
+}
+
+func (fake *fakeDB) FetchModuleVersions(ctx context.Context, modulePath string) ([]*db.RepoTag, error) {
+	var repoTags []*db.RepoTag
+	for _, rt := range fake.repoTagsToReturn {
+		if rt.ModulePath == modulePath && rt.Rejection == "" {
+			repoTags = append(repoTags, rt)
+		}
+	}
+	return repoTags, nil
+}
+
+func (fake *fakeDB) FetchRejectedRepoTags(ctx context.Context, orgRepoName string) ([]*db.RepoTag, error) {
+	var repoTags []*db.RepoTag
+	for _, rt := range fake.repoTagsToReturn {
+		if rt.OrgRepoName == orgRepoName {
+			repoTags = append(repoTags, rt)
+		}
+	}
+	return repoTags, nil
+}
+
+func (fake *fakeDB) UpsertRepos(ctx context.Context, repos []*db.Repo) error {
+	var orgRepoNames []string
+	for _, r := range repos {
+		orgRepoNames = append(orgRepoNames, r.OrgRepoName)
+	}
+	fake.gotRepoChanges = append(fake.gotRepoChanges, fmt.Sprintf("UpsertRepos(%s)", strings.Join(orgRepoNames, ", ")))
+	return nil
+}
+
+func (fake *fakeDB) RequestReindex(ctx context.Context, orgRepoName string) (bool, error) {
+	if !slices.Contains(fake.knownRepos, orgRepoName) {
+		return false, nil
+	}
+	fake.gotRepoChanges = append(fake.gotRepoChanges, fmt.Sprintf("RequestReindex(%s)", orgRepoName))
+	return true, nil
+}
+
+func (fake *fakeDB) RenameRepo(ctx context.Context, fromOrgRepoName, toOrgRepoName string) (bool, error) {
+	if !slices.Contains(fake.knownRepos, fromOrgRepoName) {
+		return false, nil
+	}
+	fake.gotRepoChanges = append(fake.gotRepoChanges, fmt.Sprintf("RenameRepo(%s, %s)", fromOrgRepoName, toOrgRepoName))
+	return true, nil
+}
+
+func (fake *fakeDB) SoftDeleteRepo(ctx context.Context, orgRepoName string) error {
+	fake.gotRepoChanges = append(fake.gotRepoChanges, fmt.Sprintf("SoftDeleteRepo(%s)", orgRepoName))
+	return nil
+}
+
+func (fake *fakeDB) FetchRepoMoves(ctx context.Context, since time.Time, limit int64) ([]*db.RepoMove, error) {
+	fake.gotLimit = limit
+	var moves []*db.RepoMove
+	for _, m := range fake.movesToReturn {
+		if !m.MovedAt.Before(since) {
+			moves = append(moves, m)
+		}
+	}
+	return moves, nil
+}
+
+func (fake *fakeDB) FetchRepoPathHistory(ctx context.Context, orgRepoName string) ([]*db.RepoMove, error) {
+	var moves []*db.RepoMove
+	for i := len(fake.movesToReturn) - 1; i >= 0; i-- {
+		if m := fake.movesToReturn[i]; m.ToOrgRepoName == orgRepoName {
+			moves = append([]*db.RepoMove{m}, moves...)
+			orgRepoName = m.FromOrgRepoName
+		}
+	}
+	return moves, nil
 }
 
 func TestHandleIndex(t *testing.T) {
 	fakeTags := []*db.RepoTag{
-		{OrgRepoName: "someorg/repo1", TagName: "tag1", ModulePath: "github.somecompany.net/someorg/repo1", Created: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)},
-		{OrgRepoName: "someorg/repo1", TagName: "tag2", ModulePath: "github.somecompany.net/someorg/repo1", Created: time.Date(2025, 2, 3, 4, 5, 6, 7, time.UTC)},
-		{OrgRepoName: "someorg/repo1", TagName: "tag3", ModulePath: "stash.somecompany.net/someorg/repo1", Created: time.Date(2025, 3, 4, 5, 6, 7, 8, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "v0.0.1", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.1", Created: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC), FirstSeen: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "v0.0.2", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.2", Created: time.Date(2024, 2, 3, 4, 5, 6, 7, time.UTC), FirstSeen: time.Date(2025, 2, 3, 4, 5, 6, 7, time.UTC)},
+		{OrgRepoName: "someorg/repo1", TagName: "v2.0.0", ModulePath: "stash.somecompany.net/someorg/repo1", Version: "v2.0.0+incompatible", Created: time.Date(2024, 3, 4, 5, 6, 7, 8, time.UTC), FirstSeen: time.Date(2025, 3, 4, 5, 6, 7, 8, time.UTC)},
 	}
 
 	for _, tc := range []struct {
 		name           string
 		sinceParam     string
 		limitParam     string
+		cursorParam    string
 		tags           []*db.RepoTag
+		feedErr        error
 		wantStatusCode int
 		wantResponse   string
+		wantCursor     string
+		wantLimit      int64
 	}{
 		{
 			name:           "empty response",
@@ -44,9 +165,33 @@ func TestHandleIndex(t *testing.T) {
 			tags:           fakeTags,
 			wantStatusCode: http.StatusOK,
 			wantResponse: "" +
-				`{"Path":"github.somecompany.net/someorg/repo1","Version":"tag1","Timestamp":"2025-01-02T03:04:05Z"}` + "\n" +
-				`{"Path":"github.somecompany.net/someorg/repo1","Version":"tag2","Timestamp":"2025-02-03T04:05:06Z"}` + "\n" +
-				`{"Path":"stash.somecompany.net/someorg/repo1","Version":"tag3","Timestamp":"2025-03-04T05:06:07Z"}`,
+				`{"Path":"github.somecompany.net/someorg/repo1","Version":"v0.0.1","Timestamp":"2025-01-02T03:04:05Z"}` + "\n" +
+				`{"Path":"github.somecompany.net/someorg/repo1","Version":"v0.0.2","Timestamp":"2025-02-03T04:05:06Z"}` + "\n" +
+				`{"Path":"stash.somecompany.net/someorg/repo1","Version":"v2.0.0+incompatible","Timestamp":"2025-03-04T05:06:07Z"}` + "\n",
+			wantCursor: encodeCursor(fakeTags[2].FeedCursor()),
+		},
+		{
+			name:           "with cursor query param",
+			cursorParam:    encodeCursor(fakeTags[0].FeedCursor()),
+			tags:           fakeTags,
+			wantStatusCode: http.StatusOK,
+			wantResponse: "" +
+				`{"Path":"github.somecompany.net/someorg/repo1","Version":"v0.0.2","Timestamp":"2025-02-03T04:05:06Z"}` + "\n" +
+				`{"Path":"stash.somecompany.net/someorg/repo1","Version":"v2.0.0+incompatible","Timestamp":"2025-03-04T05:06:07Z"}` + "\n",
+			wantCursor: encodeCursor(fakeTags[2].FeedCursor()),
+		},
+		{
+			name:           "with cursor query param at the end",
+			cursorParam:    encodeCursor(fakeTags[2].FeedCursor()),
+			tags:           fakeTags,
+			wantStatusCode: http.StatusOK,
+			wantCursor:     encodeCursor(fakeTags[2].FeedCursor()),
+		},
+		{
+			name:           "with invalid cursor query param",
+			cursorParam:    "invalid",
+			tags:           fakeTags,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
 			name:           "with invalid since query param",
@@ -60,9 +205,27 @@ func TestHandleIndex(t *testing.T) {
 			tags:           fakeTags,
 			wantStatusCode: http.StatusBadRequest,
 		},
+		{
+			name:           "with negative limit query param",
+			limitParam:     "-1",
+			tags:           fakeTags,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "with limit query param over the max",
+			limitParam:     "1000000",
+			wantStatusCode: http.StatusOK,
+			wantLimit:      maxNumberOfOutputs,
+		},
+		{
+			name:           "with error before any output",
+			feedErr:        errors.New("connection reset"),
+			wantStatusCode: http.StatusInternalServerError,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			s := newServer(0, &fakeDB{repoTagsToReturn: tc.tags}, "github.somecompany.net")
+			fake := &fakeDB{repoTagsToReturn: tc.tags, feedErr: tc.feedErr}
+			s := newServer(0, fake, "github.somecompany.net", nil, "")
 
 			request := httptest.NewRequest(http.MethodGet, "/", nil)
 			query := request.URL.Query()
@@ -72,6 +235,9 @@ func TestHandleIndex(t *testing.T) {
 			if tc.limitParam != "" {
 				query.Add("limit", tc.limitParam)
 			}
+			if tc.cursorParam != "" {
+				query.Add("cursor", tc.cursorParam)
+			}
 			request.URL.RawQuery = query.Encode()
 
 			recorder := httptest.NewRecorder()
@@ -89,6 +255,160 @@ func TestHandleIndex(t *testing.T) {
 				if tc.wantResponse != string(body) {
 					t.Errorf("unexpected reponse: -want, +got: %s", cmp.Diff(tc.wantResponse, string(body)))
 				}
+				if got := recorder.Result().Trailer.Get(cursorTrailer); got != tc.wantCursor {
+					t.Errorf("unexpected cursor: want %q, got %q", tc.wantCursor, got)
+				}
+			}
+			if tc.wantLimit != 0 && tc.wantLimit != fake.gotLimit {
+				t.Errorf("wanted limit %d, got %d", tc.wantLimit, fake.gotLimit)
+			}
+		})
+	}
+}
+
+func TestHandleIndex_AbortsOnErrorMidStream(t *testing.T) {
+	fakeTags := []*db.RepoTag{
+		{OrgRepoName: "someorg/repo1", TagName: "v0.0.1", ModulePath: "github.somecompany.net/someorg/repo1", Version: "v0.0.1", FirstSeen: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)},
+	}
+	s := newServer(0, &fakeDB{repoTagsToReturn: fakeTags, feedErr: errors.New("connection reset")}, "github.somecompany.net", nil, "")
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("wanted panic with http.ErrAbortHandler, got %v", r)
+		}
+		if got := recorder.Result().Trailer.Get(cursorTrailer); got != "" {
+			t.Errorf("wanted no cursor on an aborted response, got %q", got)
+		}
+	}()
+
+	s.handleIndex(recorder, request)
+}
+
+func TestCursorRoundtrip(t *testing.T) {
+	want := db.FeedCursor{FirstSeen: time.Date(2025, 1, 2, 3, 4, 5, 123456000, time.UTC), OrgRepoName: "someorg/repo1", TagName: "tools/cli/v0.4.0"}
+	got, err := decodeCursor(encodeCursor(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected cursor: -want, +got: %s", diff)
+	}
+}
+
+func TestHandleRejected(t *testing.T) {
+	fakeTags := []*db.RepoTag{
+		{OrgRepoName: "someorg/repo1", TagName: "_gheMigrationPR-435", Rejection: "_gheMigrationPR-435 is not a semantic version"},
+		{OrgRepoName: "someorg/repo1", TagName: "v1.2", Rejection: "v1.2 is not a canonical semantic version (should be v1.2.0)"},
+		{OrgRepoName: "someorg/repo2", TagName: "v1.3", Rejection: "v1.3 is not a canonical semantic version (should be v1.3.0)"},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		repoParam      string
+		wantStatusCode int
+		wantResponse   string
+	}{
+		{
+			name:           "repo with rejected tags",
+			repoParam:      "someorg/repo1",
+			wantStatusCode: http.StatusOK,
+			wantResponse: "" +
+				`{"Tag":"_gheMigrationPR-435","Reason":"_gheMigrationPR-435 is not a semantic version"}` + "\n" +
+				`{"Tag":"v1.2","Reason":"v1.2 is not a canonical semantic version (should be v1.2.0)"}`,
+		},
+		{
+			name:           "repo without rejected tags",
+			repoParam:      "someorg/repo3",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "missing repo param",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newServer(0, &fakeDB{repoTagsToReturn: fakeTags}, "github.somecompany.net", nil, "")
+
+			request := httptest.NewRequest(http.MethodGet, "/rejected", nil)
+			query := request.URL.Query()
+			if tc.repoParam != "" {
+				query.Add("repo", tc.repoParam)
+			}
+			request.URL.RawQuery = query.Encode()
+
+			recorder := httptest.NewRecorder()
+
+			s.handleRejected(recorder, request)
+
+			if tc.wantStatusCode != recorder.Code {
+				t.Errorf("wanted status code %d, got %d", tc.wantStatusCode, recorder.Code)
+			}
+			if tc.wantStatusCode == http.StatusOK {
+				body, err := io.ReadAll(recorder.Body)
+				if err != nil {
+					t.Errorf("unexpected error while reading recorder body: %v", err)
+				}
+				if tc.wantResponse != string(body) {
+					t.Errorf("unexpected reponse: -want, +got: %s", cmp.Diff(tc.wantResponse, string(body)))
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMoves(t *testing.T) {
+	movedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	fakeMoves := []*db.RepoMove{
+		{NodeID: "R_1", FromOrgRepoName: "someorg/repo1", ToOrgRepoName: "otherorg/repo1", MovedAt: movedAt},
+		{NodeID: "R_2", FromOrgRepoName: "someorg/repo2", ToOrgRepoName: "someorg/repo3", MovedAt: movedAt.Add(time.Hour)},
+		{NodeID: "R_1", FromOrgRepoName: "otherorg/repo1", ToOrgRepoName: "otherorg/repo4", MovedAt: movedAt.Add(2 * time.Hour)},
+		{NodeID: "gitlab.somecompany.net/gid://gitlab/Project/1", FromOrgRepoName: "gitlab.somecompany.net/group/project1", ToOrgRepoName: "gitlab.somecompany.net/group/project2", MovedAt: movedAt.Add(3 * time.Hour)},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		query          string
+		wantStatusCode int
+		wantResponse   string
+	}{
+		{
+			name:           "since",
+			query:          "since=2025-01-02T04:00:00Z",
+			wantStatusCode: http.StatusOK,
+			wantResponse: "" +
+				`{"From":"github.somecompany.net/someorg/repo2","To":"github.somecompany.net/someorg/repo3","Timestamp":"2025-01-02T04:04:05Z"}` + "\n" +
+				`{"From":"github.somecompany.net/otherorg/repo1","To":"github.somecompany.net/otherorg/repo4","Timestamp":"2025-01-02T05:04:05Z"}` + "\n" +
+				`{"From":"gitlab.somecompany.net/group/project1","To":"gitlab.somecompany.net/group/project2","Timestamp":"2025-01-02T06:04:05Z"}` + "\n",
+		},
+		{
+			name:           "path history of a repo",
+			query:          "repo=otherorg/repo4",
+			wantStatusCode: http.StatusOK,
+			wantResponse: "" +
+				`{"From":"github.somecompany.net/someorg/repo1","To":"github.somecompany.net/otherorg/repo1","Timestamp":"2025-01-02T03:04:05Z"}` + "\n" +
+				`{"From":"github.somecompany.net/otherorg/repo1","To":"github.somecompany.net/otherorg/repo4","Timestamp":"2025-01-02T05:04:05Z"}` + "\n",
+		},
+		{
+			name:           "invalid limit",
+			query:          "limit=-1",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newServer(0, &fakeDB{movesToReturn: fakeMoves}, "github.somecompany.net", nil, "")
+			recorder := httptest.NewRecorder()
+			s.handleMoves(recorder, httptest.NewRequest(http.MethodGet, "/moves?"+tc.query, nil))
+
+			if tc.wantStatusCode != recorder.Code {
+				t.Errorf("wanted status code %d, got %d", tc.wantStatusCode, recorder.Code)
+			}
+			if tc.wantStatusCode == http.StatusOK {
+				if diff := cmp.Diff(tc.wantResponse, recorder.Body.String()); diff != "" {
+					t.Errorf("unexpected response: -want, +got: %s", diff)
+				}
 			}
 		})
 	}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Netflix-Skunkworks/golang-index/internal/db"
+	"golang.org/x/exp/slog"
+)
+
+// GitHub caps webhook payloads at 25MB.
+const maxWebhookPayloadSize = 25 << 20
+
+// The parts of GitHub webhook payloads that we use. See
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads.
+type webhookPayload struct {
+	Action string `json:"action"`
+
+	// Set for create and delete events: "tag" or "branch".
+	RefType string `json:"ref_type"`
+
+	// Set for push events, ex "refs/tags/v1.0.0".
+	Ref string `json:"ref"`
+
+	Repository struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+
+		// The repo's primary language as detected by GitHub, ex "Go". Empty
+		// for new, empty repos.
+		Language string `json:"language"`
+	} `json:"repository"`
+
+	// Set for repository renamed and transferred events.
+	Changes struct {
+		Repository struct {
+			Name struct {
+				From string `json:"from"`
+			} `json:"name"`
+		} `json:"repository"`
+		Owner struct {
+			From struct {
+				User struct {
+					Login string `json:"login"`
+				} `json:"user"`
+				Organization struct {
+					Login string `json:"login"`
+				} `json:"organization"`
+			} `json:"from"`
+		} `json:"owner"`
+	} `json:"changes"`
+}
+
+// Receives GitHub webhook deliveries, so that tag pushes are indexed within
+// seconds rather than at the next scheduled re-index.
+//
+// create, delete and push events for tags ask for the repo's tags to be
+// re-indexed. repository events keep the list of repos up to date as repos are
+// created, renamed, transferred and deleted. Other events are acknowledged and
+// ignored.
+func (s *server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "webhooks must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !validWebhookSignature(s.webhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "missing or invalid X-Hub-Signature-256 header", http.StatusUnauthorized)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, fmt.Sprintf("error unmarshalling %s payload: %v", event, err), http.StatusBadRequest)
+		return
+	}
+	orgRepoName := payload.Repository.FullName
+
+	ctx := r.Context()
+	switch {
+	case (event == "create" || event == "delete") && payload.RefType == "tag",
+		event == "push" && strings.HasPrefix(payload.Ref, "refs/tags/"):
+		known, err := s.idb.RequestReindex(ctx, orgRepoName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error requesting re-index of %s: %v", orgRepoName, err), http.StatusInternalServerError)
+			return
+		}
+		if !known {
+			slog.Info(fmt.Sprintf("webhook: ignoring %s event for unknown repo %s", event, orgRepoName))
+			break
+		}
+		s.wakeReindexWorker()
+
+	case event == "repository" && payload.Action == "created":
+		if !s.storeGoRepo(w, r, &payload) {
+			return
+		}
+
+	case event == "repository" && payload.Action == "deleted":
+		// Soft-deleted like repos missing from the listing of all repos, so
+		// that the repo's history is kept and it's restored if listed again.
+		if err := s.idb.SoftDeleteRepo(ctx, orgRepoName); err != nil {
+			http.Error(w, fmt.Sprintf("error deleting repo %s: %v", orgRepoName, err), http.StatusInternalServerError)
+			return
+		}
+
+	case event == "repository" && (payload.Action == "renamed" || payload.Action == "transferred"):
+		from := previousOrgRepoName(&payload)
+		if from == "" || from == orgRepoName {
+			http.Error(w, fmt.Sprintf("%s %s payload for %s is missing the previous name", event, payload.Action, orgRepoName), http.StatusBadRequest)
+			return
+		}
+		moved, err := s.idb.RenameRepo(ctx, from, orgRepoName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error renaming repo %s to %s: %v", from, orgRepoName, err), http.StatusInternalServerError)
+			return
+		}
+		if !moved {
+			// Ex a repo transferred from another org, or one that wasn't a
+			// Go repo when the repos were last listed.
+			if !s.storeGoRepo(w, r, &payload) {
+				return
+			}
+			break
+		}
+		s.wakeReindexWorker()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stores the payload's repo and wakes up a re-indexing worker if GitHub detects
+// it as a Go repo. Other repos, ex docs or infrastructure repos, are left to the
+// next listing of all repos, which only lists Go repos: otherwise their tags
+// would be served as module versions. Whether the repo was pushed to isn't
+// known, so stored repos are indexed regardless.
+//
+// Returns false if an error was written to w.
+func (s *server) storeGoRepo(w http.ResponseWriter, r *http.Request, payload *webhookPayload) bool {
+	orgRepoName := payload.Repository.FullName
+	if payload.Repository.Language != "Go" {
+		slog.Info(fmt.Sprintf("webhook: ignoring repository %s event for %s, which isn't known to be a Go repo", payload.Action, orgRepoName))
+		return true
+	}
+	if err := s.idb.UpsertRepos(r.Context(), []*db.Repo{{OrgRepoName: orgRepoName}}); err != nil {
+		http.Error(w, fmt.Sprintf("error storing repo %s: %v", orgRepoName, err), http.StatusInternalServerError)
+		return false
+	}
+	s.wakeReindexWorker()
+	return true
+}
+
+// Returns the org/repo name that a renamed or transferred repo had before, or
+// "" if the payload doesn't say.
+func previousOrgRepoName(payload *webhookPayload) string {
+	org, name, _ := strings.Cut(payload.Repository.FullName, "/")
+	if from := payload.Changes.Repository.Name.From; from != "" {
+		name = from
+	}
+	if from := payload.Changes.Owner.From.Organization.Login; from != "" {
+		org = from
+	} else if from := payload.Changes.Owner.From.User.Login; from != "" {
+		org = from
+	}
+	if org == "" || name == "" {
+		return ""
+	}
+	return org + "/" + name
+}
+
+// Whether signature is the X-Hub-Signature-256 header GitHub sends for the
+// given payload: the hex HMAC-SHA256 of the payload keyed with the webhook
+// secret, prefixed with "sha256=".
+func validWebhookSignature(secret string, payload []byte, signature string) bool {
+	if secret == "" {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Wakes up a repo tags re-indexing worker of this process that's waiting for
+// work, if any, so that requested re-indexes start right away rather than at
+// its next poll. Workers of other processes pick them up at their next poll.
+func (s *server) wakeReindexWorker() {
+	select {
+	case s.reindexWakeups <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testWebhookSecret = "s3cret"
+
+func signWebhook(payload string) string {
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandleWebhook(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		event           string
+		payload         string
+		signature       string // Defaults to a valid signature.
+		wantStatusCode  int
+		wantRepoChanges []string
+		wantWakeup      bool
+	}{
+		{
+			name:           "ping",
+			event:          "ping",
+			payload:        `{"zen":"Keep it logically awesome."}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "missing signature",
+			event:          "create",
+			payload:        `{"ref_type":"tag","repository":{"full_name":"someorg/repo1"}}`,
+			signature:      "none",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid signature",
+			event:          "create",
+			payload:        `{"ref_type":"tag","repository":{"full_name":"someorg/repo1"}}`,
+			signature:      signWebhook(`{"ref_type":"tag","repository":{"full_name":"someorg/other"}}`),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:            "tag created",
+			event:           "create",
+			payload:         `{"ref":"v1.0.0","ref_type":"tag","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"RequestReindex(someorg/repo1)"},
+			wantWakeup:      true,
+		},
+		{
+			name:           "branch created",
+			event:          "create",
+			payload:        `{"ref":"main","ref_type":"branch","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:            "tag deleted",
+			event:           "delete",
+			payload:         `{"ref":"v1.0.0","ref_type":"tag","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"RequestReindex(someorg/repo1)"},
+			wantWakeup:      true,
+		},
+		{
+			name:            "tag pushed",
+			event:           "push",
+			payload:         `{"ref":"refs/tags/v1.0.0","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"RequestReindex(someorg/repo1)"},
+			wantWakeup:      true,
+		},
+		{
+			name:           "branch pushed",
+			event:          "push",
+			payload:        `{"ref":"refs/heads/main","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "tag pushed to unknown repo",
+			event:          "push",
+			payload:        `{"ref":"refs/tags/v1.0.0","repository":{"name":"unknown","full_name":"someorg/unknown"}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:            "go repo created",
+			event:           "repository",
+			payload:         `{"action":"created","repository":{"name":"repo2","full_name":"someorg/repo2","language":"Go"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"UpsertRepos(someorg/repo2)"},
+			wantWakeup:      true,
+		},
+		{
+			name:           "other repo created",
+			event:          "repository",
+			payload:        `{"action":"created","repository":{"name":"docs","full_name":"someorg/docs","language":"HCL"}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "empty repo created",
+			event:          "repository",
+			payload:        `{"action":"created","repository":{"name":"repo2","full_name":"someorg/repo2","language":null}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:            "repo deleted",
+			event:           "repository",
+			payload:         `{"action":"deleted","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"SoftDeleteRepo(someorg/repo1)"},
+		},
+		{
+			name:            "repo renamed",
+			event:           "repository",
+			payload:         `{"action":"renamed","changes":{"repository":{"name":{"from":"repo1"}}},"repository":{"name":"renamed","full_name":"someorg/renamed"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"RenameRepo(someorg/repo1, someorg/renamed)"},
+			wantWakeup:      true,
+		},
+		{
+			name:            "repo transferred",
+			event:           "repository",
+			payload:         `{"action":"transferred","changes":{"owner":{"from":{"organization":{"login":"someorg"}}}},"repository":{"name":"repo1","full_name":"otherorg/repo1"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"RenameRepo(someorg/repo1, otherorg/repo1)"},
+			wantWakeup:      true,
+		},
+		{
+			name:            "unknown go repo transferred",
+			event:           "repository",
+			payload:         `{"action":"transferred","changes":{"owner":{"from":{"user":{"login":"someone"}}}},"repository":{"name":"repo1","full_name":"otherorg/repo1","language":"Go"}}`,
+			wantStatusCode:  http.StatusNoContent,
+			wantRepoChanges: []string{"UpsertRepos(otherorg/repo1)"},
+			wantWakeup:      true,
+		},
+		{
+			name:           "unknown other repo renamed",
+			event:          "repository",
+			payload:        `{"action":"renamed","changes":{"repository":{"name":{"from":"infra"}}},"repository":{"name":"terraform","full_name":"someorg/terraform","language":"HCL"}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "repo renamed without previous name",
+			event:          "repository",
+			payload:        `{"action":"renamed","repository":{"name":"renamed","full_name":"someorg/renamed"}}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "repo archived",
+			event:          "repository",
+			payload:        `{"action":"archived","repository":{"name":"repo1","full_name":"someorg/repo1"}}`,
+			wantStatusCode: http.StatusNoContent,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeDB{knownRepos: []string{"someorg/repo1"}}
+			s := newServer(0, fake, "github.somecompany.net", nil, testWebhookSecret)
+
+			request := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.payload))
+			request.Header.Set("X-GitHub-Event", tc.event)
+			switch tc.signature {
+			case "":
+				request.Header.Set("X-Hub-Signature-256", signWebhook(tc.payload))
+			case "none":
+			default:
+				request.Header.Set("X-Hub-Signature-256", tc.signature)
+			}
+			recorder := httptest.NewRecorder()
+
+			s.handleWebhook(recorder, request)
+
+			if tc.wantStatusCode != recorder.Code {
+				t.Fatalf("wanted status code %d, got %d: %s", tc.wantStatusCode, recorder.Code, recorder.Body.String())
+			}
+			if diff := cmp.Diff(tc.wantRepoChanges, fake.gotRepoChanges); diff != "" {
+				t.Errorf("unexpected repo changes: -want, +got: %s", diff)
+			}
+			gotWakeup := len(s.reindexWakeups) > 0
+			if tc.wantWakeup != gotWakeup {
+				t.Errorf("wanted worker wakeup %v, got %v", tc.wantWakeup, gotWakeup)
+			}
+		})
+	}
+}
+
+func TestValidWebhookSignature(t *testing.T) {
+	payload := []byte(`{"zen":"Design for failure."}`)
+	for _, tc := range []struct {
+		name      string
+		secret    string
+		signature string
+		want      bool
+	}{
+		{name: "valid", secret: testWebhookSecret, signature: signWebhook(string(payload)), want: true},
+		{name: "wrong secret", secret: "other", signature: signWebhook(string(payload))},
+		{name: "no secret configured", secret: "", signature: signWebhook(string(payload))},
+		{name: "missing prefix", secret: testWebhookSecret, signature: strings.TrimPrefix(signWebhook(string(payload)), "sha256=")},
+		{name: "not hex", secret: testWebhookSecret, signature: "sha256=zz"},
+		{name: "empty", secret: testWebhookSecret},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := validWebhookSignature(tc.secret, payload, tc.signature); got != tc.want {
+				t.Errorf("validWebhookSignature: wanted %v, got %v", tc.want, got)
+			}
+		})
+	}
+}